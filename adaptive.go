@@ -0,0 +1,118 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptiveTTLInterval is how often the adaptive TTL controller measures the eviction rate.
+	adaptiveTTLInterval = time.Second
+	// adaptiveTTLStep is the factor the adaptive TTL controller shortens or lengthens the global TTL by at most.
+	adaptiveTTLStep = 1.25
+)
+
+// adaptiveTTL is the state of the adaptive TTL controller, see EnableAdaptiveTTL.
+type adaptiveTTL struct {
+	min, max   time.Duration
+	target     float64
+	evictions  uint64
+	observedAt time.Time
+	stop       chan struct{}
+}
+
+// EnableAdaptiveTTL makes a background controller tune the global TTL, see SetTTL, within [min, max] to keep
+// the number of items evicted per second near the target. Every second it measures the eviction rate since its
+// previous measurement: above the target it shortens the TTL, so expiring items make room before items have to
+// be evicted, below the target it lengthens the TTL to keep items cached longer. The TTL changes by a quarter at
+// most per measurement, starting from the global TTL clamped into the range, or max without a global TTL.
+// Like SetTTL, a new TTL applies to items as they are stored. The controller stops on Close. A max of 0 stops it
+// and leaves the global TTL where it was tuned to, a max below min is raised to min.
+func (cache *Cache) EnableAdaptiveTTL(min, max time.Duration, target float64) {
+	cache.mutex.Lock()
+	if cache.adaptiveTTL != nil {
+		close(cache.adaptiveTTL.stop)
+		cache.adaptiveTTL = nil
+	}
+	if max <= 0 || cache.isShutDown {
+		cache.mutex.Unlock()
+		return
+	}
+	if max < min {
+		max = min
+	}
+	controller := &adaptiveTTL{
+		min:        min,
+		max:        max,
+		target:     target,
+		evictions:  atomic.LoadUint64(&cache.evictions),
+		observedAt: time.Now(),
+		stop:       make(chan struct{}),
+	}
+	cache.adaptiveTTL = controller
+	cache.ttl = controller.clamp(cache.ttl)
+	cache.backgroundWorkers.Add(1)
+	go cache.adaptTTLPeriodically(controller)
+	cache.mutex.Unlock()
+	cache.notifyExpiration()
+}
+
+// EffectiveTTL returns the global TTL items are currently stored with, as set by SetTTL or tuned by the adaptive
+// TTL controller, see EnableAdaptiveTTL.
+func (cache *Cache) EffectiveTTL() time.Duration {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.ttl
+}
+
+func (cache *Cache) adaptTTLPeriodically(controller *adaptiveTTL) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(adaptiveTTLInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-controller.stop:
+			return
+		case now := <-ticker.C:
+			cache.mutex.Lock()
+			cache.adaptTTL(now)
+			cache.mutex.Unlock()
+			cache.notifyExpiration()
+		}
+	}
+}
+
+// adaptTTL measures the eviction rate since the previous measurement and nudges the global TTL towards keeping it
+// at the target. Must be called with the lock held.
+func (cache *Cache) adaptTTL(now time.Time) {
+	controller := cache.adaptiveTTL
+	if controller == nil {
+		return
+	}
+	elapsed := now.Sub(controller.observedAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	evictions := atomic.LoadUint64(&cache.evictions)
+	rate := float64(evictions-controller.evictions) / elapsed
+	controller.evictions = evictions
+	controller.observedAt = now
+
+	switch {
+	case rate > controller.target:
+		cache.ttl = controller.clamp(time.Duration(float64(cache.ttl) / adaptiveTTLStep))
+	case rate < controller.target:
+		cache.ttl = controller.clamp(time.Duration(float64(cache.ttl) * adaptiveTTLStep))
+	}
+}
+
+// clamp keeps the TTL within the range of the controller, treating no TTL as the longest one.
+func (controller *adaptiveTTL) clamp(ttl time.Duration) time.Duration {
+	switch {
+	case ttl <= 0 || ttl > controller.max:
+		return controller.max
+	case ttl < controller.min:
+		return controller.min
+	}
+	return ttl
+}
@@ -0,0 +1,65 @@
+package ttlcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheEnableAdaptiveTTL(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Hour)
+	cache.SetMaxItems(10)
+	cache.EnableAdaptiveTTL(time.Minute, 10*time.Minute, 5)
+	assert.Equal(t, 10*time.Minute, cache.EffectiveTTL(), "Expected the global TTL to be clamped into the range")
+
+	// the controller is stepped with synthetic observation times, a second apart
+	cache.mutex.Lock()
+	now := cache.adaptiveTTL.observedAt
+	cache.mutex.Unlock()
+	step := func() {
+		now = now.Add(time.Second)
+		cache.mutex.Lock()
+		cache.adaptTTL(now)
+		cache.mutex.Unlock()
+	}
+
+	// high pressure: 100 items per second into a cache of 10 evict far more than the target
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 100; i++ {
+			cache.Set(fmt.Sprintf("key_%d_%d", round, i), "value")
+		}
+		previous := cache.EffectiveTTL()
+		step()
+		assert.True(t, cache.EffectiveTTL() <= previous, "Expected the TTL not to grow under eviction pressure")
+	}
+	assert.Equal(t, time.Minute, cache.EffectiveTTL(), "Expected the TTL to shrink to the minimum under eviction pressure")
+
+	// low pressure: nothing is evicted
+	for round := 0; round < 20; round++ {
+		previous := cache.EffectiveTTL()
+		step()
+		assert.True(t, cache.EffectiveTTL() >= previous, "Expected the TTL not to shrink without evictions")
+	}
+	assert.Equal(t, 10*time.Minute, cache.EffectiveTTL(), "Expected the TTL to grow to the maximum without evictions")
+
+	cache.Set("new", "value")
+	cache.mutex.RLock()
+	stored, _ := cache.items.get("new")
+	cache.mutex.RUnlock()
+	assert.Equal(t, 10*time.Minute, stored.ttl, "Expected new items to be stored with the tuned TTL")
+}
+
+func TestCacheAdaptiveTTLStopsOnClose(t *testing.T) {
+	cache := NewCache()
+	cache.EnableAdaptiveTTL(time.Second, time.Minute, 1)
+	cache.Close()
+
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	assert.Nil(t, cache.adaptiveTTL, "Expected Close to stop the controller")
+}
@@ -0,0 +1,103 @@
+package ttlcache
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EnableAutoSave makes a background goroutine save the cache to the file at path every interval using the codec,
+// so a crash loses at most the changes of the last interval. Each save is written to a temporary file next to it
+// that is renamed over the file once complete, so the file always holds a whole snapshot. Close stops the goroutine
+// and saves a last time. Errors of the saves are passed to the error callback, see SetAutoSaveErrorCallback.
+// Enabling it again replaces the previous auto save.
+func (cache *Cache) EnableAutoSave(path string, interval time.Duration, codec Codec) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.stopAutoSave()
+	if interval > 0 && !cache.isShutDown {
+		cache.autoSavePath = path
+		cache.autoSaveCodec = codec
+		cache.autoSaveStop = make(chan struct{})
+		cache.backgroundWorkers.Add(1)
+		go cache.autoSavePeriodically(cache.autoSaveStop, interval)
+	}
+}
+
+// DisableAutoSave stops the auto save, see EnableAutoSave. The file written last is left in place.
+func (cache *Cache) DisableAutoSave() {
+	cache.mutex.Lock()
+	cache.stopAutoSave()
+	cache.mutex.Unlock()
+}
+
+// SetAutoSaveErrorCallback sets the callback that receives the errors of auto saves.
+func (cache *Cache) SetAutoSaveErrorCallback(callback func(err error)) {
+	cache.mutex.Lock()
+	cache.autoSaveErrorCallback = callback
+	cache.mutex.Unlock()
+}
+
+// stopAutoSave stops the auto save goroutine and forgets the file, must be called with the lock held.
+func (cache *Cache) stopAutoSave() {
+	if cache.autoSaveStop != nil {
+		close(cache.autoSaveStop)
+		cache.autoSaveStop = nil
+	}
+	cache.autoSavePath = ""
+	cache.autoSaveCodec = nil
+}
+
+func (cache *Cache) autoSavePeriodically(stop chan struct{}, interval time.Duration) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			cache.autoSave()
+		}
+	}
+}
+
+// autoSave saves the cache to the auto save file, if auto save is enabled.
+func (cache *Cache) autoSave() {
+	cache.mutex.RLock()
+	path, codec, callback := cache.autoSavePath, cache.autoSaveCodec, cache.autoSaveErrorCallback
+	cache.mutex.RUnlock()
+	if codec == nil {
+		return
+	}
+	if err := cache.saveFile(path, codec); err != nil && callback != nil {
+		callback(err)
+	}
+}
+
+// saveFile saves the cache to a temporary file and renames it to path once it is complete.
+func (cache *Cache) saveFile(path string, codec Codec) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	w := bufio.NewWriter(file)
+	err = cache.SaveWithCodec(w, codec)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
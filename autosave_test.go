@@ -0,0 +1,64 @@
+package ttlcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadFile(t *testing.T, path string) map[string]interface{} {
+	file, err := os.Open(path)
+	if !assert.Nil(t, err, "Expected the snapshot to be written") {
+		return nil
+	}
+	defer file.Close()
+	records, err := JSONCodec{}.Decode(file)
+	assert.Nil(t, err)
+	values := make(map[string]interface{}, len(records))
+	for _, record := range records {
+		values[record.Key] = record.Value
+	}
+	return values
+}
+
+func TestCacheEnableAutoSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttlcache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	cache := NewCache()
+	cache.SetAutoSaveErrorCallback(func(err error) { t.Error(err) })
+	cache.EnableAutoSave(path, 20*time.Millisecond, JSONCodec{})
+	cache.Set("first", "value")
+	<-time.After(60 * time.Millisecond)
+	assert.Equal(t, map[string]interface{}{"first": "value"}, loadFile(t, path),
+		"Expected the snapshot to reflect the contents")
+
+	cache.Set("second", "value")
+	cache.Close()
+	assert.Equal(t, map[string]interface{}{"first": "value", "second": "value"}, loadFile(t, path),
+		"Expected a last save on Close")
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files), "Expected no temporary files to be left")
+}
+
+func TestCacheDisableAutoSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttlcache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	cache := NewCache()
+	cache.EnableAutoSave(path, 10*time.Millisecond, JSONCodec{})
+	cache.DisableAutoSave()
+	cache.Set("key", "value")
+	<-time.After(30 * time.Millisecond)
+	cache.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "Expected no saves once disabled")
+}
@@ -4,7 +4,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/ReneKroon/ttlcache"
+	"github.com/erwint/ttlcache"
 )
 
 func BenchmarkCacheSetWithoutTTL(b *testing.B) {
@@ -12,7 +12,7 @@ func BenchmarkCacheSetWithoutTTL(b *testing.B) {
 	defer cache.Close()
 
 	for n := 0; n < b.N; n++ {
-		cache.Set(string(n%1000000), "value")
+		cache.Set(string(rune(n%1000000)), "value")
 	}
 }
 
@@ -22,7 +22,7 @@ func BenchmarkCacheSetWithGlobalTTL(b *testing.B) {
 
 	cache.SetTTL(time.Duration(50 * time.Millisecond))
 	for n := 0; n < b.N; n++ {
-		cache.Set(string(n%1000000), "value")
+		cache.Set(string(rune(n%1000000)), "value")
 	}
 }
 
@@ -31,6 +31,144 @@ func BenchmarkCacheSetWithTTL(b *testing.B) {
 	defer cache.Close()
 
 	for n := 0; n < b.N; n++ {
-		cache.SetWithTTL(string(n%1000000), "value", time.Duration(50*time.Millisecond))
+		cache.SetWithTTL(string(rune(n%1000000)), "value", time.Duration(50*time.Millisecond))
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Duration(time.Minute))
+	cache.Set("key", "value")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cache.Get("key")
+	}
+}
+
+func BenchmarkCacheGetWithCoarseClock(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Duration(time.Minute))
+	cache.SetCoarseClock(time.Millisecond)
+	cache.Set("key", "value")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cache.Get("key")
+	}
+}
+
+func BenchmarkCacheGetOrSetParallel(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Duration(time.Minute))
+	benchmarkMostlyHits(b, func(key string) {
+		cache.GetOrSet(key, func(string) (interface{}, error) { return "value", nil })
+	})
+}
+
+func BenchmarkCacheGetOrComputeParallel(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Duration(time.Minute))
+	benchmarkMostlyHits(b, func(key string) {
+		cache.GetOrCompute(key, func() (interface{}, error) { return "value", nil })
+	})
+}
+
+// benchmarkMostlyHits runs lookup in parallel over a small key space, so all but the first lookups hit.
+func benchmarkMostlyHits(b *testing.B, lookup func(key string)) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = string(rune('a' + i))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			lookup(keys[n%len(keys)])
+			n++
+		}
+	})
+}
+
+func BenchmarkCacheSetGetWithTTL(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	benchmarkSetGet(b, cache)
+}
+
+func BenchmarkCacheSetGetWithManualSweep(b *testing.B) {
+	cache := ttlcache.NewCacheManualSweep()
+	defer cache.Close()
+
+	benchmarkSetGet(b, cache)
+}
+
+// benchmarkSetGet sets short lived items and looks them up, run it with -count to compare the variance.
+func benchmarkSetGet(b *testing.B, cache *ttlcache.Cache) {
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		key := string(rune(n % 1000))
+		cache.SetWithTTL(key, "value", time.Millisecond)
+		cache.Get(key)
+	}
+}
+
+func BenchmarkCacheChurn(b *testing.B) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	benchmarkChurn(b, cache)
+}
+
+func BenchmarkCacheChurnWithCapacity(b *testing.B) {
+	cache := ttlcache.NewCacheWithCapacity(1000)
+	defer cache.Close()
+
+	benchmarkChurn(b, cache)
+}
+
+// benchmarkChurn fills the cache with short lived items and purges them, like a cache whose items all expire
+// in waves does.
+func benchmarkChurn(b *testing.B, cache *ttlcache.Cache) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cache.SetWithTTL(string(rune(n%1000)), "value", time.Minute)
+		if n%1000 == 999 {
+			cache.Purge()
+		}
+	}
+}
+
+func BenchmarkCacheGetLRU(b *testing.B) {
+	benchmarkGetWithPolicy(b, ttlcache.LRU)
+}
+
+func BenchmarkCacheGetSampledLRU(b *testing.B) {
+	benchmarkGetWithPolicy(b, ttlcache.SampledLRU)
+}
+
+// benchmarkGetWithPolicy looks up items spread over a full cache, so the usage order of LRU is reordered.
+func benchmarkGetWithPolicy(b *testing.B, policy ttlcache.EvictionPolicy) {
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+
+	cache.SetEvictionPolicy(policy)
+	cache.SetMaxItems(10000)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = string(rune(i))
+		cache.Set(keys[i], "value")
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cache.Get(keys[n*7919%len(keys)])
 	}
 }
@@ -0,0 +1,30 @@
+package ttlcache
+
+// SetBytes is a thread-safe way to add a byte slice to the map, see GetBytes
+func (cache *Cache) SetBytes(key string, value []byte) error {
+	return cache.Set(key, value)
+}
+
+// GetBytes is a thread-safe way to lookup a byte slice. It returns false when the item is missing or does not
+// hold a byte slice. The slice is shared with the cache unless a copy function is set, see SetBytesCopyFunc.
+// Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetBytes(key string) ([]byte, bool) {
+	data, exists := cache.Get(key)
+	if !exists {
+		return nil, false
+	}
+	value, isBytes := data.([]byte)
+	if !isBytes {
+		return nil, false
+	}
+	if cache.bytesCopyFunc != nil {
+		value = cache.bytesCopyFunc(value)
+	}
+	return value, true
+}
+
+// SetBytesCopyFunc sets a function GetBytes passes the stored slice to before returning it, typically to
+// return a copy that callers can modify without affecting the cache. A nil function returns the stored slice.
+func (cache *Cache) SetBytesCopyFunc(copyFunc func([]byte) []byte) {
+	cache.bytesCopyFunc = copyFunc
+}
@@ -0,0 +1,41 @@
+package ttlcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheBytes(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetBytes("blob", []byte("value"))
+	value, exists := cache.GetBytes("blob")
+	assert.Equal(t, true, exists, "Expected the byte slice to be found")
+	assert.Equal(t, []byte("value"), value, "Expected the stored bytes")
+
+	cache.Set("string", "value")
+	value, exists = cache.GetBytes("string")
+	assert.Equal(t, false, exists, "Expected a value of another type to not be returned")
+	assert.Nil(t, value)
+
+	value, exists = cache.GetBytes("missing")
+	assert.Equal(t, false, exists, "Expected a missing item to not be returned")
+	assert.Nil(t, value)
+}
+
+func TestCacheBytesCopyFunc(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetBytesCopyFunc(func(value []byte) []byte {
+		return append([]byte(nil), value...)
+	})
+	cache.SetBytes("blob", []byte("value"))
+	value, _ := cache.GetBytes("blob")
+	value[0] = 'V'
+
+	value, _ = cache.GetBytes("blob")
+	assert.Equal(t, []byte("value"), value, "Expected a copy to protect the stored bytes")
+}
@@ -1,7 +1,15 @@
 package ttlcache
 
 import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,77 +19,541 @@ type checkExpireCallback func(key string, value interface{}) bool
 // ExpireCallback is used as a callback on item expiration or when notifying of an item new to the cache
 type expireCallback func(key string, value interface{})
 
+const (
+	// TTLHistogramOverflow is the TTLHistogram bucket of items whose remaining TTL exceeds all boundaries.
+	TTLHistogramOverflow time.Duration = math.MaxInt64
+	// TTLHistogramPermanent is the TTLHistogram bucket of items that do not expire.
+	TTLHistogramPermanent = ItemNotExpire
+)
+
+// defaultSweeperIdleTimeout is how long the sweeper keeps running while no item expires.
+const defaultSweeperIdleTimeout = time.Minute
+
+// highWaterMarkRearmRatio is the percentage of the high-water mark the number of items has to drop below,
+// before the high-water mark callback can be called again.
+const highWaterMarkRearmRatio = 90
+
+// ErrClosed is returned when the cache is used after Close.
+var ErrClosed = errors.New("ttlcache: cache is closed")
+
+// ErrRejected is returned when an item is not stored, because the before set callback rejected it.
+var ErrRejected = errors.New("ttlcache: item rejected")
+
+// ErrValueTooLarge is returned when a value is not stored because it exceeds the maximum value size,
+// see SetMaxValueSize.
+var ErrValueTooLarge = errors.New("ttlcache: value too large")
+
+// ErrExpiryConflict is returned when expirations are both spread by jitter and aligned, see SetExpiryAlignment.
+var ErrExpiryConflict = errors.New("ttlcache: expiry jitter and alignment are mutually exclusive")
+
+// ErrCacheFull is returned when a new item is not stored because the cache is full, see SetFullPolicy.
+var ErrCacheFull = errors.New("ttlcache: cache is full")
+
+// ErrMemoryPressure is returned when a new item is not stored because of memory pressure, see SetRejectOnPressure.
+var ErrMemoryPressure = errors.New("ttlcache: memory pressure")
+
+// ValueWithTTL is a cached value together with its remaining TTL at the time of lookup.
+type ValueWithTTL struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
 // Cache is a synchronized map of items that can auto-expire once stale
 type Cache struct {
-	mutex                  sync.Mutex
-	ttl                    time.Duration
-	items                  map[string]*item
-	expireCallback         expireCallback
-	removeCallback         expireCallback
-	checkExpireCallback    checkExpireCallback
-	newItemCallback        expireCallback
-	priorityQueue          *priorityQueue
-	expirationNotification chan bool
-	expirationTime         time.Time
-	skipTTLExtension       bool
-	shutdownSignal         chan (chan struct{})
-	isShutDown             bool
+	// 64-bit counters come first to keep them aligned for atomic access on 32-bit platforms
+	droppedEvents      uint64
+	hits               uint64
+	misses             uint64
+	rejectedKeys       uint64
+	oversizedValues    uint64
+	pressureRejections uint64
+	fullRejections     uint64
+	evictions          uint64
+	slowCallbacks      uint64
+	loaderInvocations  uint64
+	loaderErrors       uint64
+	loaderLatency      int64
+	loaderLatencyMin   int64
+	loaderLatencyMax   int64
+	opTimers           [opCount]opTimer
+	opTimingsEnabled   uint32
+	mutex              cacheMutex
+	// id orders the locks of caches that are locked together, see Move
+	id                          uint64
+	ttl                         time.Duration
+	ttlResolution               TTLResolution
+	items                       itemIndex
+	expireCallback              expireCallback
+	expireReasonCallback        func(key string, value interface{}, reason ExpirationReason)
+	idleTimeout                 time.Duration
+	lazyExpireOnGet             bool
+	removeCallback              expireCallback
+	batchExpiredEvents          bool
+	replaceCallback             func(key string, oldValue, newValue interface{})
+	evictionCallback            expireCallback
+	checkExpireCallback         func(key string, value interface{}) CheckResult
+	newItemCallback             expireCallback
+	newItemVisibility           NewItemVisibility
+	purgeCallback               func(count int)
+	emptyStateCallback          func(isEmpty bool)
+	backlogCallback             func(backlog int)
+	backlogThreshold            int
+	aboveBacklogThreshold       bool
+	isEmpty                     bool
+	highWaterMarkCallback       func(size int)
+	highWaterMark               int
+	aboveHighWaterMark          bool
+	beforeSetCallback           checkExpireCallback
+	keyValidator                func(key string) error
+	valueComparator             func(a, b interface{}) bool
+	bytesCopyFunc               func([]byte) []byte
+	defaultValue                interface{}
+	historyDepth                int
+	priorityQueue               *priorityQueue
+	insertions                  uint64
+	usageOrder                  *usageOrder
+	maxItems                    int
+	fullPolicy                  FullPolicy
+	evictionPolicy              EvictionPolicy
+	evictionSampleSize          int
+	lfuHalfLife                 time.Duration
+	expirationNotification      chan bool
+	expirationTime              time.Time
+	skipTTLExtension            bool
+	skipTTLExtensionOnLoaderHit bool
+	preserveTTLOnAppend         bool
+	drainOnClose                bool
+	strictMode                  bool
+	returnExpiredOnce           bool
+	gracePeriod                 time.Duration
+	ttlJitter                   time.Duration
+	expiryAlignment             time.Duration
+	coalesceWindow              time.Duration
+	callbackTimeout             time.Duration
+	maxCheckDenials             int
+	random                      *lockedRand
+	shutdownSignal              chan struct{}
+	done                        chan struct{}
+	isShutDown                  bool
+	sweeperRunning              bool
+	sweeperIdleTimeout          time.Duration
+	manualSweep                 bool
+	sweepBatchSize              int
+	closeLoaderPolicy           CloseLoaderPolicy
+	pendingLoads                sync.WaitGroup
+	loaderCalls                 map[string]*loaderCall
+	loaderSemaphore             chan struct{}
+	loaderAttempts              int
+	loaderBackoff               time.Duration
+	loaderPanicHandler          func(key string, recovered interface{})
+	singleFlightTimeout         time.Duration
+	memoryLimit                 uint64
+	maxValueSize                int64
+	sizeFunc                    func(value interface{}) int64
+	costFunc                    func(value interface{}) int64
+	totalCost                   int64
+	totalSize                   int64
+	memoryCheckInterval         time.Duration
+	memoryStats                 func() uint64
+	memoryMonitorStop           chan struct{}
+	pressureCheck               func() bool
+	store                       Store
+	fallback                    Fallback
+	storeErrorCallback          func(err error)
+	storeBatchSize              int
+	storeBuffer                 []storeWrite
+	storeIndex                  map[string]int
+	storeFlushStop              chan struct{}
+	metricsReportStop           chan struct{}
+	autoSaveStop                chan struct{}
+	integrityCheckStop          chan struct{}
+	adaptiveTTL                 *adaptiveTTL
+	integrityCallback           func(details string)
+	autoSavePath                string
+	autoSaveCodec               Codec
+	autoSaveErrorCallback       func(err error)
+	storeMutex                  sync.Mutex
+	clock                       *coarseClock
+	subscriptions               map[*subscription]struct{}
+	keyWaiters                  map[string]map[keyWaiter]struct{}
+	insertionOrder              *list.List
+	keyLocks                    [keyLockStripes]sync.Mutex
+	perKeyStats                 bool
+	hitCallback                 func(key string)
+	missCallback                func(key string)
+	samplingRate                float64
+	missedKeys                  *list.List
+	missedKeyIndex              map[string]*list.Element
+	auxiliaryMapLimit           int
+	backgroundWorkers           sync.WaitGroup
 }
 
 func (cache *Cache) getItem(key string) (*item, bool, bool) {
-	item, exists := cache.items[key]
-	if !exists || item.expired() {
+	return cache.getItemAt(key, cache.now(), true)
+}
+
+// getItemAt looks up the item like getItem, extending its life relative to the given time unless extend is false.
+func (cache *Cache) getItemAt(key string, now time.Time, extend bool) (*item, bool, bool) {
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(now) {
+		if exists && cache.lazyExpireOnGet {
+			cache.expireOnAccess(item)
+		}
+		cache.countMiss(key)
 		return nil, false, false
 	}
+	item.access(now)
+	cache.countHit(item)
+	item.countUse(now, cache.lfuHalfLife)
+	if cache.idleTimeout > 0 || !item.idleAt.IsZero() {
+		cache.resetIdle(item, now)
+		cache.priorityQueue.update(item)
+	}
+	if cache.evictionPolicy != SampledLRU {
+		cache.usageOrder.moveToFront(item)
+	}
+	cache.publish(EventAccessed, key, item.data)
 
 	if item.ttl >= 0 && (item.ttl > 0 || cache.ttl > 0) {
 		if cache.ttl > 0 && item.ttl == 0 {
 			item.ttl = cache.ttl
 		}
 
-		if !cache.skipTTLExtension {
-			item.touch()
+		if extend && cache.extendsOnHit(item) {
+			cache.touchAt(item, now)
 		}
 		cache.priorityQueue.update(item)
 	}
 
 	expirationNotification := false
-	if cache.expirationTime.After(time.Now().Add(item.ttl)) {
+	if cache.expirationTime.After(addClamped(now, item.ttl)) {
 		expirationNotification = true
 	}
 	return item, exists, expirationNotification
 }
 
+// extendsOnHit tells whether a lookup of the item resets its expiration time, see SkipTtlExtensionOnHit.
+// Must be called with the lock held.
+func (cache *Cache) extendsOnHit(item *item) bool {
+	return item.ttl >= 0 && (item.ttl > 0 || cache.ttl > 0) && !cache.skipTTLExtension && !item.fixedExpiry
+}
+
+// resetTTL applies the global TTL to items that use it and resets the expiration time.
+// Items with a fixed expiration keep it.
+func (cache *Cache) resetTTL(item *item) {
+	if item.fixedExpiry {
+		return
+	}
+	item.ttl = cache.resolveTTL(item.ttl)
+	if item.ttl >= 0 && (item.ttl > 0 || cache.ttl > 0) {
+		if cache.ttl > 0 && item.ttl == 0 {
+			item.ttl = cache.ttl
+		}
+		cache.touch(item)
+	} else {
+		item.expireAt = time.Time{}
+	}
+}
+
+// fixExpiry schedules the item to expire at the given time, which is not extended by lookups.
+func (cache *Cache) fixExpiry(item *item, expireAt time.Time) {
+	item.fixedExpiry = true
+	item.expireAt = expireAt
+	cache.priorityQueue.update(item)
+	cache.startSweeper()
+}
+
+// touch resets the expiration time of the item, spreading it by the configured jitter.
+func (cache *Cache) touch(item *item) {
+	cache.touchAt(item, cache.now())
+}
+
+// touchAt resets the expiration time of the item relative to the given time.
+func (cache *Cache) touchAt(item *item, now time.Time) {
+	item.touchAt(now)
+	if item.ttl > 0 && cache.ttlJitter > 0 {
+		item.expireAt = addClamped(item.expireAt, time.Duration(cache.random.int63n(int64(cache.ttlJitter))))
+	}
+	if item.ttl > 0 && cache.expiryAlignment > 0 {
+		if offset := time.Duration(item.expireAt.UnixNano() % int64(cache.expiryAlignment)); offset > 0 {
+			item.expireAt = item.expireAt.Add(cache.expiryAlignment - offset)
+		}
+	}
+	if item.ttl > 0 {
+		cache.startSweeper()
+	}
+}
+
+// replaceValue replaces the value of a live item, which then counts as newly created.
+func (cache *Cache) replaceValue(item *item, data interface{}) {
+	if cache.removeCallback != nil {
+		cache.mutex.guard(func() { cache.removeCallback(item.key, item.data) })
+	}
+	cache.notifyReplaced(item, data)
+	cache.publish(EventRemoved, item.key, item.data)
+	cache.recordHistory(item)
+	item.data = data
+	item.appended = false
+	cache.account(item)
+	item.createdAt = time.Now()
+	item.lastAccessAt = item.createdAt
+	item.accessCount = 0
+	item.checkDenials = 0
+	cache.resetIdle(item, item.createdAt)
+	cache.usageOrder.moveToFront(item)
+}
+
+// notifyReplaced calls the replace callback before the value of the live item is replaced with data.
+func (cache *Cache) notifyReplaced(item *item, data interface{}) {
+	if cache.replaceCallback != nil {
+		cache.mutex.guard(func() { cache.replaceCallback(item.key, item.data, data) })
+	}
+}
+
+// deleteItem removes the item from the map, the queue, the usage order and the insertion order.
+func (cache *Cache) deleteItem(item *item) {
+	cache.priorityQueue.remove(item)
+	if stored, _ := cache.items.get(item.key); stored == item {
+		cache.items.Delete(item.key)
+	}
+	cache.unaccount(item)
+	if item.usageElement != nil {
+		cache.usageOrder.remove(item)
+	}
+	if item.insertionElement != nil {
+		cache.insertionOrder.Remove(item.insertionElement)
+		item.insertionElement = nil
+	}
+}
+
+// clearItems removes all items, and returns them.
+func (cache *Cache) clearItems() []*item {
+	items := cache.items.clear()
+	cache.totalCost, cache.totalSize = 0, 0
+	cache.priorityQueue.reset()
+	cache.usageOrder = newUsageOrder()
+	if cache.insertionOrder != nil {
+		cache.insertionOrder = list.New()
+	}
+	return items
+}
+
+// expireItem removes the expired item, and calls the remove and expiration callbacks in the background.
+func (cache *Cache) expireItem(expired *item) {
+	cache.removeExpiredItem(expired)
+	cache.publishExpired([]*item{expired})
+	cache.notifyExpired([]*item{expired})
+}
+
+// expireOnAccess expires an item a lookup found expired, unless it is within the grace period or the check
+// expiration callback is set, which the sweeper consults first. Must be called with the lock held.
+func (cache *Cache) expireOnAccess(expired *item) {
+	if expired.hidden || cache.checkExpireCallback != nil || !expired.expiredFor(cache.gracePeriod, cache.now()) {
+		return
+	}
+	cache.removeExpiredItem(expired)
+	expired.expiredBy = ExpiredOnAccess
+	cache.checkSize()
+	cache.publishExpired([]*item{expired})
+	cache.notifyExpired([]*item{expired})
+}
+
+// removeExpiredItem removes the expired item without calling the callbacks or publishing it, see publishExpired.
+func (cache *Cache) removeExpiredItem(item *item) {
+	item.expiredBy = item.expirationReason()
+	cache.deleteItem(item)
+}
+
+// notifyExpired calls the remove and expiration callbacks for the expired items in a background goroutine,
+// one item after the other in the given order. Callbacks exceeding the callback timeout are left running.
+func (cache *Cache) notifyExpired(items []*item) {
+	removeCallback, expireCallback, reasonCallback := cache.removeCallback, cache.expireCallback, cache.expireReasonCallback
+	if len(items) == 0 || (removeCallback == nil && expireCallback == nil && reasonCallback == nil) {
+		return
+	}
+	timeout := cache.callbackTimeout
+	go func() {
+		for _, item := range items {
+			key, data := item.key, item.data
+			if removeCallback != nil {
+				cache.runWithTimeout(timeout, func() { removeCallback(key, data) })
+			}
+			if expireCallback != nil {
+				cache.runWithTimeout(timeout, func() { expireCallback(key, data) })
+			}
+			if reasonCallback != nil {
+				reason := item.expiredBy
+				cache.runWithTimeout(timeout, func() { reasonCallback(key, data, reason) })
+			}
+		}
+	}()
+}
+
+// checkExpiration asks the check expiration callback whether the item expires. When the callback exceeds the
+// callback timeout the item expires, and the callback is left running. Must be called with the lock held.
+func (cache *Cache) checkExpiration(key string, data interface{}) CheckResult {
+	callback := cache.checkExpireCallback
+	if cache.callbackTimeout <= 0 {
+		var checked CheckResult
+		cache.mutex.guard(func() { checked = callback(key, data) })
+		return checked
+	}
+	result := make(chan CheckResult, 1)
+	if !cache.runWithTimeout(cache.callbackTimeout, func() { result <- callback(key, data) }) {
+		return CheckExpire
+	}
+	return <-result
+}
+
+// runWithTimeout calls f and waits for it at most the timeout, 0 waits until it returns. It reports whether f
+// returned in time, slow calls are counted in the metrics and keep running in the background.
+func (cache *Cache) runWithTimeout(timeout time.Duration, f func()) bool {
+	if timeout <= 0 {
+		f()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		atomic.AddUint64(&cache.slowCallbacks, 1)
+		return false
+	}
+}
+
+// insertItem adds a new item to the map and the queue, replacing an expired item that was not yet evicted.
+// When the new item would exceed the maximum size, other items are evicted to make room and returned,
+// the caller passes them to notifyEvicted after releasing the lock.
+func (cache *Cache) insertItem(key string, data interface{}, ttl time.Duration) (*item, []*item) {
+	if stale, exists := cache.items.get(key); exists {
+		cache.deleteItem(stale)
+	}
+	var evicted []*item
+	if cache.maxItems > 0 {
+		evicted = cache.evict(cache.items.Len() + 1 - cache.maxItems)
+	}
+	inserted := newItem(key, data, ttl)
+	cache.insertions++
+	inserted.sequence = cache.insertions
+	cache.resetTTL(inserted)
+	cache.resetIdle(inserted, cache.now())
+	cache.account(inserted)
+	cache.items.Set(key, inserted)
+	cache.priorityQueue.push(inserted)
+	cache.usageOrder.pushFront(inserted)
+	if cache.insertionOrder != nil {
+		inserted.insertionElement = cache.insertionOrder.PushBack(inserted)
+	}
+	cache.publish(EventAdded, key, data)
+	cache.wakeWaiters(key, data)
+	cache.checkSize()
+	return inserted, evicted
+}
+
+// checkSize calls the empty state and high-water mark callbacks when the number of items crossed their
+// boundaries since the last check. Must be called with the lock held, once an operation is complete so
+// intermediate states are not reported.
+func (cache *Cache) checkSize() {
+	size := cache.items.Len()
+	if isEmpty := size == 0; isEmpty != cache.isEmpty {
+		cache.isEmpty = isEmpty
+		if cache.emptyStateCallback != nil {
+			cache.mutex.guard(func() { cache.emptyStateCallback(isEmpty) })
+		}
+	}
+
+	if cache.highWaterMarkCallback == nil {
+		return
+	}
+	if !cache.aboveHighWaterMark && size > cache.highWaterMark {
+		cache.aboveHighWaterMark = true
+		cache.mutex.guard(func() { cache.highWaterMarkCallback(size) })
+	} else if cache.aboveHighWaterMark && size < cache.highWaterMark*highWaterMarkRearmRatio/100 {
+		cache.aboveHighWaterMark = false
+	}
+}
+
+// SetHighWaterMarkCallback sets a callback that will be called with the number of items when it grows above the
+// threshold. It is called once per crossing, the number of items has to drop below 90% of the threshold before it
+// can be called again, so it does not flap around the threshold. The callback is called while the cache is locked
+// and must not use the cache.
+func (cache *Cache) SetHighWaterMarkCallback(threshold int, callback func(size int)) {
+	cache.mutex.Lock()
+	cache.highWaterMark = threshold
+	cache.highWaterMarkCallback = callback
+	cache.aboveHighWaterMark = false
+	cache.mutex.Unlock()
+}
+
+// startSweeper starts the goroutine that expires items, unless it is running or the cache is closed.
+// Must be called with the lock held.
+func (cache *Cache) startSweeper() {
+	if cache.sweeperRunning || cache.isShutDown || cache.manualSweep {
+		return
+	}
+	cache.sweeperRunning = true
+	cache.backgroundWorkers.Add(1)
+	go cache.startExpirationProcessing()
+}
+
+// notifyExpiration wakes the sweeper up to reschedule, it never blocks.
+func (cache *Cache) notifyExpiration() {
+	select {
+	case cache.expirationNotification <- true:
+	default:
+	}
+}
+
 func (cache *Cache) startExpirationProcessing() {
+	defer cache.backgroundWorkers.Done()
 	timer := time.NewTimer(time.Hour)
+	var idleSince time.Time
 	for {
 		var sleepTime time.Duration
 		cache.mutex.Lock()
-		if cache.priorityQueue.Len() > 0 {
-			sleepTime = time.Until(cache.priorityQueue.items[0].expireAt)
-			if sleepTime < 0 && cache.priorityQueue.items[0].expireAt.IsZero() {
-				sleepTime = time.Hour
-			} else if sleepTime < 0 {
+		cache.checkBacklog()
+		if cache.priorityQueue.Len() == 0 || cache.priorityQueue.items[0].dueAt().IsZero() {
+			// no item expires, the sweeper stops once that lasted for the idle timeout
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			}
+			sleepTime = cache.sweeperIdleTimeout - time.Since(idleSince)
+			if sleepTime <= 0 {
+				cache.sweeperRunning = false
+				cache.mutex.Unlock()
+				timer.Stop()
+				return
+			}
+		} else {
+			idleSince = time.Time{}
+			// measured with the clock that decides the expiration, so a coarse clock lagging behind does not spin
+			sleepTime = addClamped(cache.priorityQueue.items[0].dueAt(), cache.gracePeriod).Sub(cache.now())
+			if sleepTime < 0 {
 				sleepTime = time.Microsecond
 			}
+			if cache.clock != nil && sleepTime < cache.clock.resolution {
+				// the item expires once the clock ticked past its due time, checking before would spin
+				sleepTime = cache.clock.resolution
+			}
 			if cache.ttl > 0 {
 				sleepTime = min(sleepTime, cache.ttl)
 			}
-
-		} else if cache.ttl > 0 {
-			sleepTime = cache.ttl
-		} else {
-			sleepTime = time.Hour
 		}
 
-		cache.expirationTime = time.Now().Add(sleepTime)
+		cache.expirationTime = addClamped(time.Now(), sleepTime)
 		cache.mutex.Unlock()
 
 		timer.Reset(sleepTime)
 		select {
-		case shutdownFeedback := <-cache.shutdownSignal:
+		case <-cache.shutdownSignal:
 			timer.Stop()
-			shutdownFeedback <- struct{}{}
 			return
 		case <-timer.C:
 			timer.Stop()
@@ -90,238 +562,1707 @@ func (cache *Cache) startExpirationProcessing() {
 				cache.mutex.Unlock()
 				continue
 			}
+			cache.expireDueItems(cache.sweepBatchSize)
+			cache.mutex.Unlock()
 
-			// index will only be advanced if the current entry will not be evicted
-			i := 0
-			for item := cache.priorityQueue.items[i]; item.expired(); item = cache.priorityQueue.items[i] {
-
-				if cache.checkExpireCallback != nil {
-					if !cache.checkExpireCallback(item.key, item.data) {
-						item.touch()
-						cache.priorityQueue.update(item)
-						i++
-						if i == cache.priorityQueue.Len() {
-							break
-						}
-						continue
-					}
-				}
+		case <-cache.expirationNotification:
+			timer.Stop()
+			continue
+		}
+	}
+}
 
-				cache.priorityQueue.remove(item)
-				delete(cache.items, item.key)
-				if cache.removeCallback != nil {
-					go cache.removeCallback(item.key, item.data)
-				}
-				if cache.expireCallback != nil {
-					go cache.expireCallback(item.key, item.data)
-				}
-				if cache.priorityQueue.Len() == 0 {
-					goto done
+// expireDueItems expires up to limit items that are due, all of them for a limit of 0, and returns how many were
+// expired. Must be called with the lock held. The callbacks are called in the order the items expired, items
+// expiring at the same time in insertion order.
+func (cache *Cache) expireDueItems(limit int) int {
+	cache.checkBacklog()
+	var expired []*item
+	// index will only be advanced if the current entry will not be evicted
+	i := 0
+	for i < cache.priorityQueue.Len() && cache.priorityQueue.items[i].expiredFor(cache.gracePeriod, cache.now()) &&
+		(limit <= 0 || len(expired) < limit) {
+		item := cache.priorityQueue.items[i]
+
+		if cache.checkExpireCallback != nil {
+			checked := cache.checkExpiration(item.key, item.data)
+			if checked.keep && (cache.maxCheckDenials <= 0 || item.checkDenials < cache.maxCheckDenials) {
+				item.checkDenials++
+				cache.resetIdle(item, cache.now())
+				if checked.ttl > 0 {
+					item.expireAt = addClamped(cache.now(), checked.ttl)
+				} else {
+					cache.touch(item)
 				}
+				cache.priorityQueue.update(item)
+				i++
+				continue
 			}
-		done:
-			cache.mutex.Unlock()
+		}
 
-		case <-cache.expirationNotification:
-			timer.Stop()
+		// the callbacks may have given a Set the chance to refresh the item, which wins over the expiration
+		if stored, _ := cache.items.get(item.key); stored != item || !item.expiredFor(cache.gracePeriod, cache.now()) {
+			i++
 			continue
 		}
+
+		cache.removeExpiredItem(item)
+		expired = append(expired, item)
 	}
+	cache.checkSize()
+	cache.checkBacklog()
+	cache.publishExpired(expired)
+	cache.notifyExpired(expired)
+	return len(expired)
+}
+
+// RunCleanup expires the items that are due right away, and returns how many were expired. Caches created
+// with NewCacheManualSweep only expire items when it is called, other caches do not need to call it.
+func (cache *Cache) RunCleanup() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.expireDueItems(0)
+}
+
+// SetSweepBatchSize limits how many items the sweeper expires per acquisition of the lock. When more items are
+// due, the sweeper releases the lock and continues with the next batch right away, so lookups can interleave
+// with the expiration of many items, at the cost of a slower sweep. A value of 0 expires all due items at once.
+func (cache *Cache) SetSweepBatchSize(size int) {
+	cache.mutex.Lock()
+	cache.sweepBatchSize = size
+	cache.mutex.Unlock()
 }
 
 // Close calls Purge, and then stops the goroutine that does ttl checking, for a clean shutdown.
 // The cache is no longer cleaning up after the first call to Close, repeated calls are safe though.
+// With SetDrainOnClose the first call passes all remaining items to the expiration callbacks before purging.
 func (cache *Cache) Close() {
 
 	cache.mutex.Lock()
-	if !cache.isShutDown {
+	closing := !cache.isShutDown
+	if closing {
 		cache.isShutDown = true
-		cache.mutex.Unlock()
-		feedback := make(chan struct{})
-		cache.shutdownSignal <- feedback
-		<-feedback
+		if cache.memoryMonitorStop != nil {
+			close(cache.memoryMonitorStop)
+			cache.memoryMonitorStop = nil
+		}
+		if cache.storeFlushStop != nil {
+			close(cache.storeFlushStop)
+			cache.storeFlushStop = nil
+		}
+		if cache.metricsReportStop != nil {
+			close(cache.metricsReportStop)
+			cache.metricsReportStop = nil
+		}
+		if cache.autoSaveStop != nil {
+			close(cache.autoSaveStop)
+			cache.autoSaveStop = nil
+		}
+		if cache.integrityCheckStop != nil {
+			close(cache.integrityCheckStop)
+			cache.integrityCheckStop = nil
+		}
+		if cache.adaptiveTTL != nil {
+			close(cache.adaptiveTTL.stop)
+			cache.adaptiveTTL = nil
+		}
+		if cache.clock != nil {
+			close(cache.clock.stop)
+			cache.clock = nil
+		}
 		close(cache.shutdownSignal)
+		drainLoads := cache.closeLoaderPolicy == DrainPending
+		cache.mutex.Unlock()
+		cache.backgroundWorkers.Wait()
+		if drainLoads {
+			cache.pendingLoads.Wait()
+		}
+		cache.flushStore()
+		cache.autoSave()
+		if cache.drainOnClose {
+			cache.drain()
+		}
+		cache.mutex.Lock()
+		cache.closeSubscriptions()
+		cache.mutex.Unlock()
 	} else {
 		cache.mutex.Unlock()
 	}
 	cache.Purge()
+	if closing {
+		close(cache.done)
+	}
 }
 
-// Set is a thread-safe way to add new items to the map
-func (cache *Cache) Set(key string, data interface{}) {
-	cache.SetWithTTL(key, data, ItemExpireWithGlobalTTL)
+// Done returns a channel that is closed once Close has torn down the cache, to select on its shutdown.
+// It returns the same channel before and after Close.
+func (cache *Cache) Done() <-chan struct{} {
+	return cache.done
 }
 
-// SetWithTTL is a thread-safe way to add new items to the map with individual ttl
-func (cache *Cache) SetWithTTL(key string, data interface{}, ttl time.Duration) {
+// drain removes all items, calling the expiration and remove callbacks for each of them.
+func (cache *Cache) drain() {
 	cache.mutex.Lock()
-	item, exists, _ := cache.getItem(key)
+	items := cache.clearItems()
+	cache.publishExpired(items)
+	cache.checkSize()
+	cache.mutex.Unlock()
 
-	if exists {
+	for _, item := range items {
 		if cache.removeCallback != nil {
-			cache.removeCallback(key, item.data)
+			cache.removeCallback(item.key, item.data)
+		}
+		if cache.expireCallback != nil {
+			cache.expireCallback(item.key, item.data)
 		}
-		item.data = data
-		item.ttl = ttl
-	} else {
-		item = newItem(key, data, ttl)
-		cache.items[key] = item
 	}
+}
 
-	if item.ttl >= 0 && (item.ttl > 0 || cache.ttl > 0) {
-		if cache.ttl > 0 && item.ttl == 0 {
-			item.ttl = cache.ttl
+// Set is a thread-safe way to add new items to the map.
+// It returns ErrRejected when the item is not stored, see SetBeforeSetCallback, and ErrClosed after Close.
+func (cache *Cache) Set(key string, data interface{}) error {
+	return cache.SetWithTTL(key, data, ItemExpireWithGlobalTTL)
+}
+
+// SetWithTTL is a thread-safe way to add new items to the map with individual ttl.
+// It returns ErrRejected when the item is not stored, see SetBeforeSetCallback, and ErrClosed after Close.
+func (cache *Cache) SetWithTTL(key string, data interface{}, ttl time.Duration) error {
+	_, err := cache.SetWithTTLAt(key, data, ttl)
+	return err
+}
+
+// SetWithTTLAt works like SetWithTTL, and returns the time the item is scheduled to expire at,
+// after jitter and clamping were applied. Items that do not expire return the zero time.
+func (cache *Cache) SetWithTTLAt(key string, data interface{}, ttl time.Duration) (time.Time, error) {
+	return cache.set(key, data, ttl, time.Time{}, 0)
+}
+
+// SetWithExpiryTime is a thread-safe way to add new items to the map that expire at the given time.
+// Lookups do not extend the life of such items. When the time already passed the item is not stored,
+// and a previous value for the key is removed.
+// It returns ErrRejected when the item is not stored, see SetBeforeSetCallback.
+func (cache *Cache) SetWithExpiryTime(key string, data interface{}, expireAt time.Time) error {
+	ttl := time.Until(expireAt)
+	if ttl <= 0 {
+		cache.Remove(key)
+		return nil
+	}
+	_, err := cache.set(key, data, ttl, expireAt, 0)
+	return err
+}
+
+// SetWithRemainingTTL is a thread-safe way to add an item that already aged elsewhere, for example in another
+// cache tier, and has only the given remaining life left. It works like SetWithExpiryTime, so lookups do not
+// extend the remaining life and a remaining TTL that is not positive drops the item.
+func (cache *Cache) SetWithRemainingTTL(key string, data interface{}, remaining time.Duration) error {
+	return cache.SetWithExpiryTime(key, data, time.Now().Add(remaining))
+}
+
+// SetWithPriority is a thread-safe way to add new items to the map with individual ttl and eviction priority.
+// When the cache evicts items because of its size or memory limit, items with a lower priority are evicted
+// before items with a higher one, the eviction policy orders items of the same priority. Other Set functions
+// store items with priority 0. The priority does not affect the expiration.
+// It returns ErrRejected when the item is not stored, see SetBeforeSetCallback, and ErrClosed after Close.
+func (cache *Cache) SetWithPriority(key string, data interface{}, ttl time.Duration, priority int) error {
+	_, err := cache.set(key, data, ttl, time.Time{}, priority)
+	return err
+}
+
+// set stores the item with the given ttl and priority, and writes it through to the store. A non-zero expireAt
+// fixes the expiration at that time instead.
+func (cache *Cache) set(key string, data interface{}, ttl time.Duration, expireAt time.Time, priority int) (time.Time, error) {
+	expireAt, err := cache.setLocal(key, data, ttl, expireAt, priority)
+	if err == ErrClosed {
+		cache.mutex.RLock()
+		strict := cache.strictMode
+		cache.mutex.RUnlock()
+		if strict {
+			panic("ttlcache: Set called after Close")
+		}
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expireAt, cache.putToStore(key, data, expireAt)
+}
+
+// storeLoaded caches a value returned by a loader with the global TTL, and writes it through to the store.
+// Loads can finish after Close, their values are dropped then, also in strict mode.
+func (cache *Cache) storeLoaded(key string, value interface{}) {
+	expireAt, err := cache.setLocal(key, value, ItemExpireWithGlobalTTL, time.Time{}, 0)
+	if err == nil {
+		cache.putToStore(key, value, expireAt)
+	}
+}
+
+// setLocal stores the item like set, without writing it through to the store.
+func (cache *Cache) setLocal(key string, data interface{}, ttl time.Duration, expireAt time.Time, priority int) (time.Time, error) {
+	if cache.mutex.reentrant() {
+		// the callback runs for the goroutine holding the lock, so strictMode can be read
+		if cache.strictMode {
+			panic(ErrReentrant)
 		}
-		item.touch()
+		return time.Time{}, ErrReentrant
+	}
+	timing := cache.lockOp(opSet)
+	if cache.isShutDown {
+		cache.unlockOp(timing)
+		return time.Time{}, ErrClosed
+	}
+	if ttl < 0 && ttl != ItemNotExpire && cache.strictMode {
+		cache.unlockOp(timing)
+		panic(fmt.Sprintf("ttlcache: negative TTL %v, use ItemNotExpire for items that do not expire", ttl))
+	}
+	if err := cache.validateKey(key); err != nil {
+		cache.unlockOp(timing)
+		return time.Time{}, err
+	}
+	if !cache.acceptSet(key, data) {
+		cache.unlockOp(timing)
+		return time.Time{}, ErrRejected
+	}
+	if cache.oversized(data) {
+		cache.unlockOp(timing)
+		return time.Time{}, ErrValueTooLarge
+	}
+	var evicted []*item
+	item, exists := cache.items.get(key)
+	exists = exists && !item.expiredAt(cache.now())
+
+	if exists && expireAt.IsZero() && cache.coalesceWindow > 0 && time.Since(item.createdAt) < cache.coalesceWindow {
+		// coalesce with the previous set, only the value changes
+		cache.notifyReplaced(item, data)
+		cache.recordHistory(item)
+		item.data = data
+		item.appended = false
+		cache.account(item)
+		expireAt = item.expireAt
+		cache.unlockOp(timing)
+		return expireAt, nil
 	}
 
+	if !exists {
+		if err := cache.rejectInsert(key); err != nil {
+			cache.unlockOp(timing)
+			return time.Time{}, err
+		}
+	}
 	if exists {
+		cache.replaceValue(item, data)
+		item.ttl = ttl
+		item.fixedExpiry = false
+		cache.resetTTL(item)
 		cache.priorityQueue.update(item)
 	} else {
-		cache.priorityQueue.push(item)
+		item, evicted = cache.insertItem(key, data, ttl)
+	}
+	if !expireAt.IsZero() {
+		cache.fixExpiry(item, expireAt)
+	}
+	hidden := !exists && cache.newItemVisibility == CallbackFirst && cache.newItemCallback != nil
+	item.hidden = hidden
+	if item.priority != priority && item.pinned {
+		item.priority = priority
+	} else if item.priority != priority {
+		cache.usageOrder.remove(item)
+		item.priority = priority
+		cache.usageOrder.pushFront(item)
 	}
+	expireAt = item.expireAt
 
-	cache.mutex.Unlock()
+	cache.unlockOp(timing)
+	cache.notifyEvicted(evicted)
 	if !exists && cache.newItemCallback != nil {
 		cache.newItemCallback(key, data)
 	}
-	cache.expirationNotification <- true
+	if hidden {
+		cache.mutex.Lock()
+		item.hidden = false
+		cache.mutex.Unlock()
+	}
+	cache.notifyExpiration()
+	return expireAt, nil
 }
 
-// Get is a thread-safe way to lookup items
-// Every lookup, also touches the item, hence extending it's life
-func (cache *Cache) Get(key string) (interface{}, bool) {
+// SetIf is a thread-safe way to store an item only when cond returns true for the current value, and whether
+// the item exists. It returns whether the item was stored. Updating an existing item resets its expiration using
+// its own TTL, new items use the global TTL. The condition is called while the cache is locked and must not use
+// the cache.
+func (cache *Cache) SetIf(key string, data interface{}, cond func(existing interface{}, exists bool) bool) bool {
+	var evicted []*item
 	cache.mutex.Lock()
-	item, exists, triggerExpirationNotification := cache.getItem(key)
-
-	var dataToReturn interface{}
+	item, exists := cache.items.get(key)
+	exists = exists && !item.expiredAt(cache.now())
+	var existing interface{}
 	if exists {
-		dataToReturn = item.data
+		existing = item.data
 	}
-	cache.mutex.Unlock()
-	if triggerExpirationNotification {
-		cache.expirationNotification <- true
+	accepted := false
+	cache.mutex.guard(func() { accepted = cond(existing, exists) })
+	if !accepted || cache.validateKey(key) != nil ||
+		!cache.acceptSet(key, data) || cache.oversized(data) || (!exists && cache.rejectInsert(key) != nil) {
+		cache.mutex.Unlock()
+		return false
 	}
-	return dataToReturn, exists
-}
-
-// GetOrDefault is a thread-safe way to lookup items and invoke
-// a function to create and store a default value if it is not.
-// This operation is atomic, and the whole cache is locked while
-// the generator is called to create the default value.
-// Every lookup, also touches the item, hence extending it's life
-func (cache *Cache) GetOrDefault(key string, generator func(string) (interface{}, error)) (interface{}, error) {
-	cache.mutex.Lock()
-	item, exists, triggerExpirationNotification := cache.getItem(key)
-
-	var dataToReturn interface{}
 
 	if exists {
-		dataToReturn = item.data
+		cache.replaceValue(item, data)
+		cache.resetTTL(item)
+		cache.priorityQueue.update(item)
 	} else {
-		var err error
-		dataToReturn, err = generator(key)
-		if err != nil {
-			cache.mutex.Unlock()
-			return nil, err
-		}
-		item = newItem(key, dataToReturn, ItemExpireWithGlobalTTL)
-		cache.items[key] = item
-		cache.priorityQueue.push(item)
+		_, evicted = cache.insertItem(key, data, ItemExpireWithGlobalTTL)
 	}
 	cache.mutex.Unlock()
+
+	cache.notifyEvicted(evicted)
 	if !exists && cache.newItemCallback != nil {
-		cache.newItemCallback(key, dataToReturn)
-	}
-	if triggerExpirationNotification {
-		cache.expirationNotification <- true
+		cache.newItemCallback(key, data)
 	}
-	return dataToReturn, nil
+	cache.notifyExpiration()
+	return true
+}
 
+// CompareAndSwap is a thread-safe way to replace the value of an item only when its current value equals old,
+// as decided by the value comparator, see SetValueComparator. It returns whether the value was replaced,
+// the expiration is reset like SetIf does.
+func (cache *Cache) CompareAndSwap(key string, old, new interface{}) bool {
+	return cache.SetIf(key, new, func(existing interface{}, exists bool) bool {
+		if cache.valueComparator != nil {
+			return exists && cache.valueComparator(existing, old)
+		}
+		return exists && reflect.DeepEqual(existing, old)
+	})
 }
 
-func (cache *Cache) Remove(key string) bool {
+// SetValueComparator sets the function CompareAndSwap uses to compare values, for example to compare only an ID
+// field. Without a comparator values are compared with reflect.DeepEqual. The comparator is called while the
+// cache is locked and must not use the cache.
+func (cache *Cache) SetValueComparator(comparator func(a, b interface{}) bool) {
 	cache.mutex.Lock()
-	object, exists := cache.items[key]
-	if !exists {
+	cache.valueComparator = comparator
+	cache.mutex.Unlock()
+}
+
+// ReplaceIfPresent is a thread-safe way to replace the value of an item only if it is in the cache.
+// When resetTTL is true the expiration is reset like Set does, otherwise the item keeps its expiration.
+// The remove callback is called for the old value. It returns false when the item is not in the cache
+// or the new value was rejected, see SetBeforeSetCallback.
+func (cache *Cache) ReplaceIfPresent(key string, data interface{}, resetTTL bool) bool {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) || !cache.acceptSet(key, data) ||
+		cache.oversized(data) {
 		cache.mutex.Unlock()
 		return false
 	}
-	delete(cache.items, object.key)
-	cache.priorityQueue.remove(object)
-	if cache.removeCallback != nil {
-		go cache.removeCallback(key, object)
+	cache.replaceValue(item, data)
+	if resetTTL {
+		cache.resetTTL(item)
+		cache.priorityQueue.update(item)
 	}
 	cache.mutex.Unlock()
 
+	if resetTTL {
+		cache.notifyExpiration()
+	}
 	return true
 }
 
-// Count returns the number of items in the cache
-func (cache *Cache) Count() int {
+// Update is a thread-safe way to read, modify and write an item atomically. It calls f with the current value,
+// and whether the item exists, and stores the value f returns, or removes the item when f returns keep false.
+// When resetTTL is true an updated item gets a new expiration like Set does, otherwise it keeps its expiration,
+// new items use the global TTL. It returns whether the cache changed, which it does not when f removes a missing
+// item or the new value is rejected, see SetKeyValidator and SetBeforeSetCallback. The remove callback is called
+// for the old value. f is called while the cache is locked and must not use the cache.
+func (cache *Cache) Update(key string, f func(current interface{}, exists bool) (newValue interface{}, keep bool), resetTTL bool) bool {
+	var evicted []*item
 	cache.mutex.Lock()
-	length := len(cache.items)
-	cache.mutex.Unlock()
-	return length
-}
+	item, exists := cache.items.get(key)
+	exists = exists && !item.expiredAt(cache.now())
+	var current interface{}
+	if exists {
+		current = item.data
+	}
+	var data interface{}
+	keep := false
+	if !cache.mutex.guard(func() { data, keep = f(current, exists) }) {
+		// f panicked, the panic is raised by Unlock
+		cache.mutex.Unlock()
+		return false
+	}
 
-func (cache *Cache) SetTTL(ttl time.Duration) {
-	cache.mutex.Lock()
+	if !keep {
+		if !exists {
+			cache.mutex.Unlock()
+			return false
+		}
+		cache.deleteItem(item)
+		cache.checkSize()
+		cache.publish(EventRemoved, key, item.data)
+		cache.mutex.Unlock()
+
+		cache.deleteFromStore(key)
+		if cache.removeCallback != nil {
+			cache.removeCallback(key, item.data)
+		}
+		return true
+	}
+
+	if (!exists && cache.validateKey(key) != nil) || !cache.acceptSet(key, data) || cache.oversized(data) ||
+		(!exists && cache.rejectInsert(key) != nil) {
+		cache.mutex.Unlock()
+		return false
+	}
+	if exists {
+		cache.replaceValue(item, data)
+		if resetTTL {
+			cache.resetTTL(item)
+			cache.priorityQueue.update(item)
+		}
+	} else {
+		_, evicted = cache.insertItem(key, data, ItemExpireWithGlobalTTL)
+	}
+	cache.mutex.Unlock()
+
+	cache.notifyEvicted(evicted)
+	if !exists && cache.newItemCallback != nil {
+		cache.newItemCallback(key, data)
+	}
+	cache.notifyExpiration()
+	return true
+}
+
+// Refresh is a thread-safe way to replace the value of an item with fresher data, like a refresh-ahead loader
+// does, and reset its TTL. Unlike Set it keeps the lifecycle of the item: its creation time, access count and
+// priority are preserved, so the item stays the same logical entry. It returns false without storing the
+// value when the key is missing or expired, or the value is rejected.
+func (cache *Cache) Refresh(key string, value interface{}) bool {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) || !cache.acceptSet(key, value) || cache.oversized(value) {
+		cache.mutex.Unlock()
+		return false
+	}
+	createdAt, lastAccessAt, accessCount := item.createdAt, item.lastAccessAt, item.accessCount
+	cache.replaceValue(item, value)
+	item.createdAt, item.lastAccessAt, item.accessCount = createdAt, lastAccessAt, accessCount
+	cache.resetTTL(item)
+	cache.priorityQueue.update(item)
+	cache.mutex.Unlock()
+
+	cache.notifyExpiration()
+	return true
+}
+
+// ReplaceAll is a thread-safe way to swap the entire contents of the cache for the given items, stored with
+// the given ttl. The swap happens in a single lock acquisition, so lookups see either all old or all new items.
+// The remove callback is called for every old item and the new item callback for every new one, items
+// rejected by the key validator or the before set callback are skipped.
+func (cache *Cache) ReplaceAll(items map[string]interface{}, ttl time.Duration) {
+	cache.mutex.Lock()
+	old := cache.clearItems()
+	for _, item := range old {
+		cache.publish(EventRemoved, item.key, item.data)
+	}
+	var evicted []*item
+	added := make(map[string]interface{}, len(items))
+	for key, data := range items {
+		if cache.validateKey(key) != nil {
+			continue
+		}
+		if !cache.acceptSet(key, data) {
+			continue
+		}
+		if cache.oversized(data) || cache.rejectInsert(key) != nil {
+			continue
+		}
+		_, overflow := cache.insertItem(key, data, ttl)
+		evicted = append(evicted, overflow...)
+		added[key] = data
+	}
+	cache.checkSize()
+	cache.mutex.Unlock()
+
+	if cache.removeCallback != nil {
+		for _, item := range old {
+			cache.removeCallback(item.key, item.data)
+		}
+	}
+	cache.notifyEvicted(evicted)
+	if cache.newItemCallback != nil {
+		for key, data := range added {
+			cache.newItemCallback(key, data)
+		}
+	}
+	cache.notifyExpiration()
+}
+
+// ExpiredItem is an item that was removed from the cache because it expired.
+type ExpiredItem struct {
+	Key       string
+	Value     interface{}
+	ExpiredAt time.Time
+}
+
+// DrainExpired is a thread-safe way to remove up to n expired items that were not evicted yet, oldest first,
+// so they can be processed in batches. The remove and expiration callbacks are called for every drained item,
+// the check expiration callback is not. Items in their grace period are drained as well, see SetGracePeriod.
+func (cache *Cache) DrainExpired(n int) []ExpiredItem {
+	var drained []ExpiredItem
+	cache.mutex.Lock()
+	for len(drained) < n && cache.priorityQueue.Len() > 0 && cache.priorityQueue.items[0].expiredAt(cache.now()) {
+		item := cache.priorityQueue.items[0]
+		cache.expireItem(item)
+		drained = append(drained, ExpiredItem{Key: item.key, Value: item.data, ExpiredAt: item.expireAt})
+	}
+	cache.checkSize()
+	cache.mutex.Unlock()
+	return drained
+}
+
+// Get is a thread-safe way to lookup items, consulting the fallback on a miss, see SetFallback.
+// An item stored with a nil value is found, it returns nil and true while a missing item returns nil and false.
+// Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) Get(key string) (interface{}, bool) {
+	timing := cache.lockOp(opGet)
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+
+	var dataToReturn interface{}
+	if exists {
+		dataToReturn = item.data
+	}
+	fallback := cache.fallback
+	cache.unlockOp(timing)
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	if !exists && fallback != nil {
+		return cache.getFromFallback(fallback, key)
+	}
+	return dataToReturn, exists
+}
+
+// GetEx is a thread-safe way to lookup items like Get, additionally reporting whether the lookup reset the
+// expiration time of the item, which depends on SkipTtlExtensionOnHit and on whether the item expires and
+// was stored with a fixed expiration time. Values found through the fallback are never extended.
+func (cache *Cache) GetEx(key string) (value interface{}, found bool, extended bool) {
+	timing := cache.lockOp(opGet)
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+
+	if exists {
+		value = item.data
+		extended = cache.extendsOnHit(item)
+	}
+	fallback := cache.fallback
+	cache.unlockOp(timing)
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	if !exists && fallback != nil {
+		value, found = cache.getFromFallback(fallback, key)
+		return value, found, false
+	}
+	return value, exists, extended
+}
+
+// TryGet is a thread-safe way to lookup items like Get, without waiting for the lock: when another goroutine holds
+// or waits for it, for example during a burst of writes, it returns right away with ok set to false, so the caller
+// can turn to the origin rather than queue. Otherwise ok is true, and the value and found are those of Get.
+func (cache *Cache) TryGet(key string) (value interface{}, found bool, ok bool) {
+	if !cache.mutex.tryLock() {
+		return nil, false, false
+	}
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+	if exists {
+		value = item.data
+	}
+	fallback := cache.fallback
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	if !exists && fallback != nil {
+		value, exists = cache.getFromFallback(fallback, key)
+	}
+	return value, exists, true
+}
+
+// GetOrDefaultValue is a thread-safe way to lookup items like Get, returning the default value on a miss,
+// see SetDefaultValue. The default value is never stored, and an item stored with a nil value is a hit.
+func (cache *Cache) GetOrDefaultValue(key string) interface{} {
+	cache.mutex.Lock()
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+	dataToReturn := cache.defaultValue
+	if exists {
+		dataToReturn = item.data
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return dataToReturn
+}
+
+// Append is a thread-safe way to add a value to the slice stored at key. The slice is created on the
+// first append using the global TTL, a stored value that is not a slice becomes its first element. A slice
+// stored with Set is copied on the first append, so the caller's backing array is never written to.
+// By default every append resets the TTL like Set does, see PreserveTTLOnAppend. A slice for a key rejected
+// by the key validator is not created, which is only visible in the metrics. The before set callback gets the
+// slice with the value appended, and when it rejects it the stored value is left unchanged, as it is when the
+// appended slice exceeds the maximum value size, see SetMaxValueSize.
+func (cache *Cache) Append(key string, value interface{}) {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	if exists && !item.expiredAt(cache.now()) {
+		values, isSlice := item.data.([]interface{})
+		if !isSlice {
+			values = []interface{}{item.data}
+		} else if !item.appended {
+			// the slice belongs to the caller who stored it, growing it must not write to its backing array
+			values = values[:len(values):len(values)]
+		}
+		appended := append(values, value)
+		if !cache.acceptSet(key, appended) || cache.oversized(appended) {
+			cache.mutex.Unlock()
+			return
+		}
+		item.data = appended
+		item.appended = true
+		cache.account(item)
+		if !cache.preserveTTLOnAppend {
+			cache.resetTTL(item)
+			cache.priorityQueue.update(item)
+		}
+		cache.mutex.Unlock()
+		return
+	}
+	data := []interface{}{value}
+	if cache.validateKey(key) != nil || !cache.acceptSet(key, data) || cache.oversized(data) || cache.rejectInsert(key) != nil {
+		cache.mutex.Unlock()
+		return
+	}
+	item, evicted := cache.insertItem(key, data, ItemExpireWithGlobalTTL)
+	item.appended = true
+	cache.mutex.Unlock()
+	cache.notifyEvicted(evicted)
+	if cache.newItemCallback != nil {
+		cache.newItemCallback(key, data)
+	}
+	cache.notifyExpiration()
+}
+
+// GetSlice is a thread-safe way to lookup the values added with Append. The returned slice is a copy
+// and can be modified freely. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetSlice(key string) ([]interface{}, bool) {
+	cache.mutex.Lock()
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+
+	var dataToReturn []interface{}
+	if exists {
+		if values, isSlice := item.data.([]interface{}); isSlice {
+			dataToReturn = make([]interface{}, len(values))
+			copy(dataToReturn, values)
+		} else {
+			dataToReturn = []interface{}{item.data}
+		}
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return dataToReturn, exists
+}
+
+// GetExpiring is a thread-safe way to lookup items like Get, that also reports whether the item expired.
+// With SetReturnExpiredOnce enabled, an expired item that was not evicted yet is returned once with expired set
+// to true, and evicted right away calling the expiration callbacks. Subsequent lookups miss.
+func (cache *Cache) GetExpiring(key string) (value interface{}, expired bool, found bool) {
+	cache.mutex.Lock()
+	if item, exists := cache.items.get(key); exists && item.expiredAt(cache.now()) && cache.returnExpiredOnce {
+		cache.expireItem(item)
+		cache.checkSize()
+		cache.mutex.Unlock()
+		return item.data, true, true
+	}
+
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+	if exists {
+		value = item.data
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return value, false, exists
+}
+
+// GetWithStale is a thread-safe way to lookup items that also returns items which expired less than
+// the grace period ago, see SetGracePeriod. Fresh reports whether the item has not expired yet,
+// only fresh items have their life extended by the lookup.
+func (cache *Cache) GetWithStale(key string) (value interface{}, fresh bool, found bool) {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	now := cache.now()
+	if exists && item.expiredAt(now) {
+		if !item.expiredFor(cache.gracePeriod, now) {
+			value = item.data
+			found = true
+		}
+		cache.mutex.Unlock()
+		return value, false, found
+	}
+
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+	if exists {
+		value = item.data
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return value, exists, exists
+}
+
+// GetMultiWithTTL is a thread-safe way to lookup several items at once, together with their remaining TTL.
+// Only found items are part of the result, items without expiration report ItemNotExpire as TTL.
+// Every found item is touched like Get does, all in a single lock acquisition.
+func (cache *Cache) GetMultiWithTTL(keys []string) map[string]ValueWithTTL {
+	result := make(map[string]ValueWithTTL, len(keys))
+	triggerExpirationNotification := false
+
+	cache.mutex.Lock()
+	now := cache.now()
+	for _, key := range keys {
+		item, exists, expirationNotification := cache.getItem(key)
+		if !exists {
+			continue
+		}
+		triggerExpirationNotification = triggerExpirationNotification || expirationNotification
+		ttl := ItemNotExpire
+		if !item.expireAt.IsZero() {
+			ttl = item.expireAt.Sub(now)
+		}
+		result[key] = ValueWithTTL{Value: item.data, TTL: ttl}
+	}
+	cache.mutex.Unlock()
+
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return result
+}
+
+// GetGroup is a thread-safe way to lookup several related items at once. All found items are touched
+// relative to the same moment in a single lock acquisition, so items sharing a TTL keep expiring together.
+// Missing items are not part of the result.
+func (cache *Cache) GetGroup(keys []string) map[string]interface{} {
+	result := make(map[string]interface{}, len(keys))
+	triggerExpirationNotification := false
+
+	cache.mutex.Lock()
+	now := cache.now()
+	for _, key := range keys {
+		item, exists, expirationNotification := cache.getItemAt(key, now, true)
+		if exists {
+			result[key] = item.data
+			triggerExpirationNotification = triggerExpirationNotification || expirationNotification
+		}
+	}
+	cache.mutex.Unlock()
+
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return result
+}
+
+// GetConsistent is a thread-safe way to read several items as they are at a single instant, so neither an
+// expiration nor a concurrent Set can tear the result. It is a pure read: the items are not touched and do not
+// count as use for eviction. Missing items are not part of the result. It returns the error of the key validator
+// for an invalid key, see SetKeyValidator, and ErrClosed after Close.
+func (cache *Cache) GetConsistent(keys []string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(keys))
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	if cache.isShutDown {
+		return nil, ErrClosed
+	}
+	now := cache.now()
+	for _, key := range keys {
+		if err := cache.validateKey(key); err != nil {
+			return nil, err
+		}
+		if item, exists := cache.items.get(key); exists && !item.expiredAt(now) {
+			result[key] = item.data
+		}
+	}
+	return result, nil
+}
+
+// ContainsMany reports for every key whether a live item is stored under it, in the order of the keys. All keys
+// are checked under a single acquisition of the read lock, without touching the items or counting hits.
+func (cache *Cache) ContainsMany(keys []string) []bool {
+	present := make([]bool, len(keys))
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	now := cache.now()
+	for i, key := range keys {
+		item, exists := cache.items.get(key)
+		present[i] = exists && !item.expiredAt(now)
+	}
+	return present
+}
+
+// NextExpiration returns the time the soonest expiring item expires at, so an external scheduler can wake up when
+// the next item is due. That item may already be expired and waiting to be evicted. It returns false when no item
+// expires. Nothing is changed by the call.
+func (cache *Cache) NextExpiration() (time.Time, bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	if cache.priorityQueue.Len() == 0 || cache.priorityQueue.items[0].dueAt().IsZero() {
+		return time.Time{}, false
+	}
+	return cache.priorityQueue.items[0].dueAt(), true
+}
+
+// ExpiryStatus tells whether an item is in the cache, and whether it expired.
+type ExpiryStatus int
+
+const (
+	// Absent means the item is not in the cache.
+	Absent ExpiryStatus = iota
+	// Live means the item is in the cache and did not expire.
+	Live
+	// ExpiredPendingSweep means the item expired, but was not evicted yet.
+	ExpiredPendingSweep
+)
+
+func (status ExpiryStatus) String() string {
+	switch status {
+	case Absent:
+		return "Absent"
+	case Live:
+		return "Live"
+	case ExpiredPendingSweep:
+		return "ExpiredPendingSweep"
+	}
+	return "Unknown"
+}
+
+// ExpiryStatus is a thread-safe way to tell an expired item that was not evicted yet from a missing one,
+// without touching the item.
+func (cache *Cache) ExpiryStatus(key string) ExpiryStatus {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	item, exists := cache.items.get(key)
+	if !exists {
+		return Absent
+	}
+	if item.expiredAt(cache.now()) {
+		return ExpiredPendingSweep
+	}
+	return Live
+}
+
+// GetItemInfo is a thread-safe way to lookup the lifecycle of an item, without touching it.
+func (cache *Cache) GetItemInfo(key string) (ItemInfo, bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		return ItemInfo{}, false
+	}
+	return item.info(), true
+}
+
+// GetWithFallbackKeys is a thread-safe way to lookup the first found item of several keys, from specific to general.
+// It returns the key that was found with its value, only that item is touched.
+func (cache *Cache) GetWithFallbackKeys(keys ...string) (key string, value interface{}, found bool) {
+	cache.mutex.Lock()
+	var triggerExpirationNotification bool
+	for _, candidate := range keys {
+		var item *item
+		item, found, triggerExpirationNotification = cache.getItem(candidate)
+		if found {
+			key = candidate
+			value = item.data
+			break
+		}
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return key, value, found
+}
+
+// GetOrExtend is a thread-safe way to lookup an item and reset its expiration to the given ttl from now.
+// Missing items are not created.
+func (cache *Cache) GetOrExtend(key string, ttl time.Duration) (interface{}, bool) {
+	cache.mutex.Lock()
+	item, exists, _ := cache.getItem(key)
+	if !exists {
+		cache.mutex.Unlock()
+		return nil, false
+	}
+	item.ttl = ttl
+	item.fixedExpiry = false
+	cache.resetTTL(item)
+	cache.priorityQueue.update(item)
+	dataToReturn := item.data
+	cache.mutex.Unlock()
+
+	cache.notifyExpiration()
+	return dataToReturn, true
+}
+
+// ExtendIf resets the expiration of an item to the given ttl from now only when the predicate accepts its
+// current value, and returns whether it did, for example to renew a lease only while it is still held.
+// Missing and expired items are not extended. The predicate is called while the cache is locked and must
+// not use the cache.
+func (cache *Cache) ExtendIf(key string, ttl time.Duration, pred func(value interface{}) bool) bool {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		cache.mutex.Unlock()
+		return false
+	}
+	accepted := false
+	cache.mutex.guard(func() { accepted = pred(item.data) })
+	if !accepted {
+		cache.mutex.Unlock()
+		return false
+	}
+	item.ttl = ttl
+	item.fixedExpiry = false
+	cache.resetTTL(item)
+	cache.priorityQueue.update(item)
+	cache.mutex.Unlock()
+
+	cache.notifyExpiration()
+	return true
+}
+
+// TouchMany resets the expiration of all given items to the given ttl from now in a single lock acquisition,
+// so they share the same deadline, and returns how many were extended. TTL jitter is not applied, see
+// SetTTLJitter. Missing and expired items are skipped.
+func (cache *Cache) TouchMany(keys []string, ttl time.Duration) int {
+	cache.mutex.Lock()
+	now := cache.now()
+	touched := 0
+	for _, key := range keys {
+		item, exists := cache.items.get(key)
+		if !exists || item.expiredAt(now) {
+			continue
+		}
+		item.ttl = ttl
+		item.fixedExpiry = false
+		item.touchAt(now)
+		cache.priorityQueue.update(item)
+		touched++
+	}
+	if touched > 0 && ttl > 0 {
+		cache.startSweeper()
+	}
+	cache.mutex.Unlock()
+
+	cache.notifyExpiration()
+	return touched
+}
+
+// GetOrDefault is a thread-safe way to lookup items and invoke
+// a function to create and store a default value if it is not.
+// This operation is atomic, and the whole cache is locked while
+// the generator is called to create the default value.
+// A successfully generated default is cached using the global TTL, errors are never cached.
+// A cached nil value is found like any other value, the generator is not called for it.
+// Every lookup, also touches the item, hence extending it's life
+// After Close it returns ErrClosed without calling the generator.
+func (cache *Cache) GetOrDefault(key string, generator func(string) (interface{}, error)) (interface{}, error) {
+	return cache.GetOrDefaultWithTTL(key, generator, ItemExpireWithGlobalTTL)
+}
+
+// GetOrDefaultWithTTL works like GetOrDefault, but caches a generated default with the given ttl
+// instead of the global one.
+func (cache *Cache) GetOrDefaultWithTTL(key string, generator func(string) (interface{}, error), ttl time.Duration) (interface{}, error) {
+	var evicted []*item
+	cache.mutex.Lock()
+	if cache.isShutDown {
+		cache.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	item, exists, triggerExpirationNotification := cache.getItemAt(key, cache.now(), !cache.skipTTLExtensionOnLoaderHit)
+
+	if semaphore := cache.loaderSemaphore; !exists && semaphore != nil {
+		// wait for a loader slot without holding the lock, so the running loaders can use the cache meanwhile,
+		// and look the item up again, another caller may have stored it
+		cache.mutex.Unlock()
+		semaphore <- struct{}{}
+		defer func() { <-semaphore }()
+		cache.mutex.Lock()
+		if cache.isShutDown {
+			cache.mutex.Unlock()
+			return nil, ErrClosed
+		}
+		if stored, found := cache.items.get(key); found && !stored.expiredAt(cache.now()) {
+			item, exists, triggerExpirationNotification = cache.getItemAt(key, cache.now(), !cache.skipTTLExtensionOnLoaderHit)
+		}
+	}
+
+	var dataToReturn interface{}
+
+	if exists {
+		dataToReturn = item.data
+	} else {
+		if err := cache.validateKey(key); err != nil {
+			cache.mutex.Unlock()
+			return nil, err
+		}
+		var err error
+		if !cache.mutex.guard(func() { dataToReturn, err = cache.invokeLoader(nil, key, generator) }) {
+			cache.mutex.Unlock()
+			return nil, ErrReentrant
+		}
+		if err != nil {
+			cache.mutex.Unlock()
+			return nil, err
+		}
+		if !cache.acceptSet(key, dataToReturn) || cache.oversized(dataToReturn) || cache.rejectInsert(key) != nil {
+			cache.mutex.Unlock()
+			return dataToReturn, nil
+		}
+		_, evicted = cache.insertItem(key, dataToReturn, ttl)
+		triggerExpirationNotification = true
+	}
+	cache.mutex.Unlock()
+	cache.notifyEvicted(evicted)
+	if !exists && cache.newItemCallback != nil {
+		cache.newItemCallback(key, dataToReturn)
+	}
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+	return dataToReturn, nil
+
+}
+
+func (cache *Cache) Remove(key string) bool {
+	cache.deleteFromStore(key)
+	timing := cache.lockOp(opRemove)
+	object, exists := cache.items.get(key)
+	if !exists {
+		cache.unlockOp(timing)
+		return false
+	}
+	cache.deleteItem(object)
+	cache.checkSize()
+	cache.publish(EventRemoved, key, object.data)
+	if cache.removeCallback != nil {
+		go cache.removeCallback(key, object)
+	}
+	cache.unlockOp(timing)
+
+	return true
+}
+
+// Expire schedules the item to expire right away, so it is evicted by the next sweep like an item whose TTL
+// elapsed, calling the expiration callbacks rather than only the remove callback as Remove does. Until then
+// lookups miss it. It returns false when the item is not in the cache.
+func (cache *Cache) Expire(key string) bool {
+	cache.mutex.Lock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		cache.mutex.Unlock()
+		return exists
+	}
+	cache.fixExpiry(item, cache.now())
+	cache.mutex.Unlock()
+	cache.notifyExpiration()
+	return true
+}
+
+// RemoveMatching removes all live items the predicate matches, and returns how many were removed. The predicate
+// receives the creation time of the item, the time its current value was stored, so items stored in a time
+// window can be targeted. It is called while the cache is locked and must not use the cache. The remove
+// callback is called for every removed item, and the keys are deleted from the store, see SetStore.
+func (cache *Cache) RemoveMatching(pred func(key string, value interface{}, createdAt time.Time) bool) int {
+	var removed []*item
+	cache.mutex.Lock()
+	now := cache.now()
+	if !cache.mutex.guard(func() {
+		cache.items.each(func(item *item) bool {
+			if !item.expiredAt(now) && pred(item.key, item.data, item.createdAt) {
+				removed = append(removed, item)
+			}
+			return true
+		})
+	}) {
+		removed = nil
+	}
+	for _, item := range removed {
+		cache.deleteItem(item)
+		cache.publish(EventRemoved, item.key, item.data)
+	}
+	cache.checkSize()
+	cache.mutex.Unlock()
+
+	for _, item := range removed {
+		cache.deleteFromStore(item.key)
+		if cache.removeCallback != nil {
+			cache.removeCallback(item.key, item.data)
+		}
+	}
+	return len(removed)
+}
+
+// Promote marks the item as most recently used for eviction purposes only, without touching it.
+// It returns false when the item is not in the cache.
+func (cache *Cache) Promote(key string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		return false
+	}
+	cache.usageOrder.moveToFront(item)
+	return true
+}
+
+// Count returns the number of live items in the cache, the same items Keys returns. Expired items that were not
+// evicted yet are not counted, see RawCount.
+func (cache *Cache) Count() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	now := cache.now()
+	return cache.items.Len() - cache.priorityQueue.countDue(func(item *item) bool {
+		return item.expiredAt(now)
+	})
+}
+
+// RawCount returns the number of items held by the cache, including expired items that were not evicted yet.
+func (cache *Cache) RawCount() int {
+	cache.mutex.RLock()
+	length := cache.items.Len()
+	cache.mutex.RUnlock()
+	return length
+}
+
+func (cache *Cache) SetTTL(ttl time.Duration) {
+	cache.mutex.Lock()
 	cache.ttl = ttl
 	cache.mutex.Unlock()
-	cache.expirationNotification <- true
+	cache.notifyExpiration()
+}
+
+// TTLResolution decides the TTL of items that have an individual TTL while a global TTL is set.
+type TTLResolution int
+
+const (
+	// TTLResolutionItem uses the individual TTL, also when it is longer than the global TTL. It is the default.
+	TTLResolutionItem TTLResolution = iota
+	// TTLResolutionMin uses the shorter of the individual and the global TTL, so no item lives longer
+	// than the global TTL. Items stored with ItemNotExpire use the global TTL.
+	TTLResolutionMin
+	// TTLResolutionGlobal uses the global TTL for all items, individual TTLs only apply without a global TTL.
+	TTLResolutionGlobal
+)
+
+// SetTTLResolution sets how the TTL of items with an individual TTL is chosen while a global TTL is set.
+// It applies to items as they are stored, items already in the cache keep their TTL.
+func (cache *Cache) SetTTLResolution(resolution TTLResolution) {
+	cache.mutex.Lock()
+	cache.ttlResolution = resolution
+	cache.mutex.Unlock()
+}
+
+// resolveTTL returns the TTL of an item stored with the given individual TTL, see SetTTLResolution.
+func (cache *Cache) resolveTTL(ttl time.Duration) time.Duration {
+	if cache.ttl <= 0 || ttl == ItemExpireWithGlobalTTL {
+		return ttl
+	}
+	switch cache.ttlResolution {
+	case TTLResolutionMin:
+		if ttl < 0 || ttl > cache.ttl {
+			return cache.ttl
+		}
+	case TTLResolutionGlobal:
+		return cache.ttl
+	}
+	return ttl
+}
+
+// TTLHistogram bins the remaining TTL of every live item into the given bucket boundaries.
+// An item is counted in the smallest boundary it does not exceed, items exceeding all boundaries
+// are counted in TTLHistogramOverflow and items without expiration in TTLHistogramPermanent. The remaining TTL
+// of an item that goes idle first is the time until it does, see SetIdleTimeout. Looking at the items does not
+// extend their TTL.
+func (cache *Cache) TTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	boundaries := make([]time.Duration, len(buckets))
+	copy(boundaries, buckets)
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i] < boundaries[j] })
+
+	histogram := make(map[time.Duration]int, len(boundaries)+2)
+	for _, boundary := range boundaries {
+		histogram[boundary] = 0
+	}
+	histogram[TTLHistogramOverflow] = 0
+	histogram[TTLHistogramPermanent] = 0
+
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	now := cache.now()
+	cache.items.each(func(item *item) bool {
+		if item.expiredAt(now) {
+			return true
+		}
+		dueAt := item.dueAt()
+		if dueAt.IsZero() {
+			histogram[TTLHistogramPermanent]++
+			return true
+		}
+		remaining := dueAt.Sub(now)
+		bucket := sort.Search(len(boundaries), func(i int) bool { return remaining <= boundaries[i] })
+		if bucket == len(boundaries) {
+			histogram[TTLHistogramOverflow]++
+		} else {
+			histogram[boundaries[bucket]]++
+		}
+		return true
+	})
+	return histogram
+}
+
+// Handler groups the callbacks on the lifecycle of items, see SetHandler. Functions that are nil are not called.
+type Handler struct {
+	// OnAdd is called when a new item is added, see SetNewItemCallback.
+	OnAdd func(key string, value interface{})
+	// OnExpire is called when an item expires, see SetExpirationCallback.
+	OnExpire func(key string, value interface{})
+	// OnRemove is called when an item is removed, see SetRemoveCallback.
+	OnRemove func(key string, value interface{})
+	// ShouldExpire decides whether an item expires, see SetCheckExpirationCallback.
+	ShouldExpire func(key string, value interface{}) bool
+}
+
+// SetHandler sets all callbacks of the handler at once, replacing the ones set before. No item sees only some
+// of them.
+func (cache *Cache) SetHandler(handler Handler) {
+	cache.mutex.Lock()
+	cache.newItemCallback = handler.OnAdd
+	cache.expireCallback = handler.OnExpire
+	cache.removeCallback = handler.OnRemove
+	cache.checkExpireCallback = checkResultCallback(handler.ShouldExpire)
+	cache.mutex.Unlock()
 }
 
-// SetExpirationCallback sets a callback that will be called when an item expires
+// SetExpirationCallback sets a callback that will be called when an item expires.
+// The callbacks for the items expired by one sweep are called one after the other in the order the items expired,
+// items expiring at the same time in the order they were inserted.
 func (cache *Cache) SetExpirationCallback(callback expireCallback) {
+	cache.mutex.Lock()
 	cache.expireCallback = callback
+	cache.mutex.Unlock()
 }
 
 // RemoveCallback sets a callback that will be called when an item is removed
 func (cache *Cache) SetRemoveCallback(callback expireCallback) {
+	cache.mutex.Lock()
 	cache.removeCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetReplaceCallback sets a callback that is called with the old and the new value when the value of a live item
+// is replaced, to emit change events. It is not called for new items, see SetNewItemCallback, nor for expired
+// ones. When both are set the remove callback is called with the old value first. The callback is called while
+// the cache is locked and must not use the cache.
+func (cache *Cache) SetReplaceCallback(callback func(key string, oldValue, newValue interface{})) {
+	cache.mutex.Lock()
+	cache.replaceCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetEvictionCallback sets a callback that will be called when an item is evicted because the cache
+// exceeded its size or memory limit, see SetMaxItems, SetMemoryLimit and TrimToSize. It is not called for
+// expired or removed items. An evicted item is passed to the remove callback first, then to this callback.
+func (cache *Cache) SetEvictionCallback(callback expireCallback) {
+	cache.mutex.Lock()
+	cache.evictionCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetEmptyStateCallback sets a callback that will be called when the cache becomes empty, or holds items again.
+// Only real transitions are reported, an operation that leaves the cache as empty as before does not call it.
+// Purge and Close report a single transition to empty. The callback is called while the cache is locked
+// and must not use the cache.
+func (cache *Cache) SetEmptyStateCallback(callback func(isEmpty bool)) {
+	cache.mutex.Lock()
+	cache.emptyStateCallback = callback
+	cache.mutex.Unlock()
 }
 
 // SetCheckExpirationCallback sets a callback that will be called when an item is about to expire
 // in order to allow external code to decide whether the item expires or remains for another TTL cycle
 func (cache *Cache) SetCheckExpirationCallback(callback checkExpireCallback) {
+	cache.mutex.Lock()
+	cache.checkExpireCallback = checkResultCallback(callback)
+	cache.mutex.Unlock()
+}
+
+// checkResultCallback adapts a check expiration callback to the results of SetCheckExpirationCallbackV2.
+func checkResultCallback(callback checkExpireCallback) func(key string, value interface{}) CheckResult {
+	if callback == nil {
+		return nil
+	}
+	return func(key string, value interface{}) CheckResult {
+		if callback(key, value) {
+			return CheckExpire
+		}
+		return CheckKeep
+	}
+}
+
+// SetMaxCheckDenials limits how often the check expiration callback can keep an item, after which the item
+// expires regardless of the callback, so a callback that keeps an item forever by mistake cannot pin it.
+// Storing a new value for the item resets its count. A value of 0 allows unlimited denials, the default.
+func (cache *Cache) SetMaxCheckDenials(n int) {
+	cache.mutex.Lock()
+	cache.maxCheckDenials = n
+	cache.mutex.Unlock()
+}
+
+// CheckResult is the decision of the callback set by SetCheckExpirationCallbackV2 about an item that is about
+// to expire.
+type CheckResult struct {
+	keep bool
+	ttl  time.Duration
+}
+
+var (
+	// CheckExpire lets the item expire.
+	CheckExpire = CheckResult{}
+	// CheckKeep keeps the item for another TTL cycle.
+	CheckKeep = CheckResult{keep: true}
+)
+
+// CheckKeepWithTTL keeps the item and reschedules its expiration to the given ttl from now, after which it is
+// checked again. Lookups that extend the item reset it to its own TTL as usual.
+func CheckKeepWithTTL(ttl time.Duration) CheckResult {
+	return CheckResult{keep: true, ttl: ttl}
+}
+
+// SetCheckExpirationCallbackV2 works like SetCheckExpirationCallback, except that the callback can also grant
+// the item a specific amount of time with CheckKeepWithTTL rather than another TTL cycle. It replaces the
+// callback set by SetCheckExpirationCallback.
+func (cache *Cache) SetCheckExpirationCallbackV2(callback func(key string, value interface{}) CheckResult) {
+	cache.mutex.Lock()
 	cache.checkExpireCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetCallbackTimeout bounds how long the sweeper waits for the check expiration callback, and for the expiration
+// and remove callbacks of expired items, so a slow callback cannot stall the expiration of other items. A check
+// that exceeds the timeout expires the item. Slow callbacks are left running in the background and counted in the
+// metrics. Timing a callback costs a goroutine per call. A value of 0 waits for callbacks to return.
+func (cache *Cache) SetCallbackTimeout(timeout time.Duration) {
+	cache.mutex.Lock()
+	cache.callbackTimeout = timeout
+	cache.mutex.Unlock()
+}
+
+// SetBeforeSetCallback sets a callback that will be called before an item is stored by Set, SetWithTTL or
+// one of the loader functions. When it returns false the item is not stored and an existing value is kept.
+// The callback is called while the cache is locked and must not use the cache.
+func (cache *Cache) SetBeforeSetCallback(callback func(key string, value interface{}) bool) {
+	cache.mutex.Lock()
+	cache.beforeSetCallback = callback
+	cache.mutex.Unlock()
 }
 
-// SetNewItemCallback sets a callback that will be called when a new item is added to the cache
+// acceptSet asks the before set callback whether the item may be stored. Must be called with the lock held.
+func (cache *Cache) acceptSet(key string, data interface{}) bool {
+	if cache.beforeSetCallback == nil {
+		return true
+	}
+	accepted := false
+	cache.mutex.guard(func() { accepted = cache.beforeSetCallback(key, data) })
+	return accepted
+}
+
+// SetKeyValidator sets a function that checks every key before an item is stored, for example to limit its length.
+// When it returns an error the item is not stored, Set, SetWithTTL and the loader functions return the error,
+// while Append and ReplaceAll skip the item. Rejected keys are counted in the metrics, see GetMetrics.
+// The validator is called while the cache is locked and must not use the cache.
+func (cache *Cache) SetKeyValidator(validator func(key string) error) {
+	cache.mutex.Lock()
+	cache.keyValidator = validator
+	cache.mutex.Unlock()
+}
+
+// validateKey checks the key with the key validator, counting rejected keys. Must be called with the lock held.
+func (cache *Cache) validateKey(key string) error {
+	if cache.keyValidator == nil {
+		return nil
+	}
+	var err error
+	if !cache.mutex.guard(func() { err = cache.keyValidator(key) }) {
+		return ErrReentrant
+	}
+	if err != nil {
+		atomic.AddUint64(&cache.rejectedKeys, 1)
+	}
+	return err
+}
+
+// SetNewItemCallback sets a callback that will be called when a new item is added to the cache.
+// It is called after the item became visible to lookups, see SetNewItemVisibilityMode.
 func (cache *Cache) SetNewItemCallback(callback expireCallback) {
+	cache.mutex.Lock()
 	cache.newItemCallback = callback
+	cache.mutex.Unlock()
+}
+
+// NewItemVisibility orders the new item callback and the moment a new item becomes visible to lookups.
+type NewItemVisibility int
+
+const (
+	// VisibleFirst makes new items visible before the new item callback is called, it is the default.
+	VisibleFirst NewItemVisibility = iota
+	// CallbackFirst hides new items stored by the Set functions from lookups until the new item callback returned.
+	// The lock is not held while the callback runs: the item is stored hidden, so lookups miss it as if it
+	// expired, and revealed after the callback. A Set of the same key in between stores a new item, which
+	// gets a callback of its own. Other functions that add items call the callback after they became visible.
+	CallbackFirst
+)
+
+// SetNewItemVisibilityMode sets whether new items become visible to lookups before or after the new item callback
+// is called for them.
+func (cache *Cache) SetNewItemVisibilityMode(mode NewItemVisibility) {
+	cache.mutex.Lock()
+	cache.newItemVisibility = mode
+	cache.mutex.Unlock()
 }
 
 // SkipTtlExtensionOnHit allows the user to change the cache behaviour. When this flag is set to true it will
 // no longer extend TTL of items when they are retrieved using Get, or when their expiration condition is evaluated
 // using SetCheckExpirationCallback.
 func (cache *Cache) SkipTtlExtensionOnHit(value bool) {
+	cache.mutex.Lock()
 	cache.skipTTLExtension = value
+	cache.mutex.Unlock()
 }
 
-// Purge will remove all entries
-func (cache *Cache) Purge() {
+// SkipTtlExtensionOnLoaderHit allows the user to change the cache behaviour for the loader functions GetOrDefault
+// and GetOrSet. When this flag is set to true they no longer extend the TTL of items they find, even when Get does,
+// so loaded items are refreshed on a fixed schedule regardless of how often they are read.
+func (cache *Cache) SkipTtlExtensionOnLoaderHit(value bool) {
 	cache.mutex.Lock()
-	cache.items = make(map[string]*item)
-	cache.priorityQueue = newPriorityQueue()
+	cache.skipTTLExtensionOnLoaderHit = value
 	cache.mutex.Unlock()
 }
 
-// NewCache is a helper to create instance of the Cache struct
-func NewCache() *Cache {
+// SetLazyExpireOnGet sets whether lookups that find an item expired, but not evicted yet, evict it right away
+// and call the expiration callbacks, rather than leaving it to the sweeper. It is enabled by default, so the
+// callbacks fire promptly also when the sweeper lags behind or is disabled, see NewCacheManualSweep. The reason
+// passed to the expiration reason callback is ExpiredOnAccess. Items within the grace period, and all items
+// while a check expiration callback is set, are left to the sweeper. Either way such lookups miss.
+func (cache *Cache) SetLazyExpireOnGet(value bool) {
+	cache.mutex.Lock()
+	cache.lazyExpireOnGet = value
+	cache.mutex.Unlock()
+}
 
-	shutdownChan := make(chan chan struct{})
+// SetReturnExpiredOnce allows the user to observe expired items that were not evicted yet with GetExpiring.
+// When this flag is set to true GetExpiring returns such an item once, flagged as expired, before evicting it.
+func (cache *Cache) SetReturnExpiredOnce(value bool) {
+	cache.mutex.Lock()
+	cache.returnExpiredOnce = value
+	cache.mutex.Unlock()
+}
 
+// SetGracePeriod keeps expired items around for the given duration, during which they are still
+// returned by GetWithStale. All other lookups treat them as expired, and the expiration callbacks fire
+// once the grace period elapsed.
+func (cache *Cache) SetGracePeriod(gracePeriod time.Duration) {
+	cache.mutex.Lock()
+	cache.gracePeriod = gracePeriod
+	cache.mutex.Unlock()
+	cache.notifyExpiration()
+}
+
+// SetCoalesceWindow collapses repeated sets of the same key. A Set or SetWithTTL within the window after the
+// item was stored only replaces its value in place, without resetting the expiration or calling the callbacks,
+// so the last value always wins. A value of 0 disables coalescing.
+func (cache *Cache) SetCoalesceWindow(window time.Duration) {
+	cache.mutex.Lock()
+	cache.coalesceWindow = window
+	cache.mutex.Unlock()
+}
+
+// SetTTLJitter spreads expirations by adding a random duration in [0, jitter) to the expiration
+// time every time the TTL of an item is reset. A value of 0 disables jitter. It returns ErrExpiryConflict
+// when expirations are aligned, see SetExpiryAlignment.
+func (cache *Cache) SetTTLJitter(jitter time.Duration) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if jitter > 0 && cache.expiryAlignment > 0 {
+		return ErrExpiryConflict
+	}
+	cache.ttlJitter = jitter
+	return nil
+}
+
+// SetExpiryAlignment rounds the expiration time of items up to the next multiple of alignment since the epoch
+// every time their TTL is reset, so items expire together at wall clock boundaries, such as every minute, also
+// across the nodes of a fleet. Items stored with an expiration time are not aligned. A value of 0 disables the
+// alignment. It returns ErrExpiryConflict when expirations are spread by jitter, see SetTTLJitter.
+func (cache *Cache) SetExpiryAlignment(alignment time.Duration) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if alignment > 0 && cache.ttlJitter > 0 {
+		return ErrExpiryConflict
+	}
+	cache.expiryAlignment = alignment
+	return nil
+}
+
+// SetRandSource replaces the source all randomized decisions of the cache draw from, such as TTL jitter.
+// Seeding it with a fixed value makes those decisions reproducible. By default a time seeded source is used.
+// The source does not need to be safe for concurrent use.
+func (cache *Cache) SetRandSource(src rand.Source) {
+	cache.mutex.Lock()
+	cache.random = newLockedRand(src)
+	cache.mutex.Unlock()
+}
+
+// PreserveTTLOnAppend allows the user to change the behaviour of Append. When this flag is set to true
+// appending to an existing slice no longer resets the TTL of the item.
+func (cache *Cache) PreserveTTLOnAppend(value bool) {
+	cache.mutex.Lock()
+	cache.preserveTTLOnAppend = value
+	cache.mutex.Unlock()
+}
+
+// SetDefaultValue sets the value GetOrDefaultValue returns on a miss, it is nil by default.
+func (cache *Cache) SetDefaultValue(value interface{}) {
+	cache.mutex.Lock()
+	cache.defaultValue = value
+	cache.mutex.Unlock()
+}
+
+// SetPurgeCallback sets a callback that will be called with the number of dropped items after Purge
+func (cache *Cache) SetPurgeCallback(callback func(count int)) {
+	cache.mutex.Lock()
+	cache.purgeCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetStrictMode allows the user to find misuse of the cache during development. When this flag is set to true
+// a Set after Close panics instead of returning ErrClosed, a Set from a callback that runs while the cache is
+// locked panics with ErrReentrant instead of returning it, and so does a negative TTL other than ItemNotExpire,
+// which is otherwise treated as ItemNotExpire.
+func (cache *Cache) SetStrictMode(value bool) {
+	cache.mutex.Lock()
+	cache.strictMode = value
+	cache.mutex.Unlock()
+}
+
+// SetDrainOnClose allows the user to flush the cache on Close. When this flag is set to true Close calls
+// the expiration and remove callbacks for every remaining item, so they are not lost on shutdown.
+func (cache *Cache) SetDrainOnClose(value bool) {
+	cache.mutex.Lock()
+	cache.drainOnClose = value
+	cache.mutex.Unlock()
+}
+
+// Purge will remove all entries and returns how many were dropped.
+// No per item callbacks are called, see SetPurgeCallback instead.
+func (cache *Cache) Purge() int {
+	cache.mutex.Lock()
+	count := len(cache.clearItems())
+	cache.checkSize()
+	purgeCallback := cache.purgeCallback
+	cache.mutex.Unlock()
+	if purgeCallback != nil {
+		purgeCallback(count)
+	}
+	return count
+}
+
+// Reset removes all items, and clears the metrics and the tracked missed keys, while keeping the configuration
+// such as the TTL, callbacks and limits. No callbacks are called for the removed items.
+func (cache *Cache) Reset() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.clearItems()
+	cache.checkSize()
+	for _, counter := range []*uint64{&cache.droppedEvents, &cache.hits, &cache.misses, &cache.rejectedKeys, &cache.oversizedValues, &cache.pressureRejections, &cache.fullRejections, &cache.evictions, &cache.slowCallbacks, &cache.loaderInvocations, &cache.loaderErrors} {
+		atomic.StoreUint64(counter, 0)
+	}
+	for _, counter := range []*int64{&cache.loaderLatency, &cache.loaderLatencyMin, &cache.loaderLatencyMax} {
+		atomic.StoreInt64(counter, 0)
+	}
+	cache.resetOpTimings()
+	if cache.missedKeys != nil {
+		cache.missedKeys.Init()
+		cache.missedKeyIndex = make(map[string]*list.Element)
+	}
+}
+
+// NewCache is a helper to create instance of the Cache struct
+func NewCache() *Cache {
 	cache := &Cache{
-		items:                  make(map[string]*item),
-		priorityQueue:          newPriorityQueue(),
-		expirationNotification: make(chan bool),
+		id:                     atomic.AddUint64(&cacheIDs, 1),
+		items:                  itemIndex{ItemMap: make(builtinItemMap)},
+		priorityQueue:          newPriorityQueue(0),
+		usageOrder:             newUsageOrder(),
+		expirationNotification: make(chan bool, 1),
 		expirationTime:         time.Now(),
-		shutdownSignal:         shutdownChan,
+		shutdownSignal:         make(chan struct{}),
+		done:                   make(chan struct{}),
 		isShutDown:             false,
+		isEmpty:                true,
+		sweeperIdleTimeout:     defaultSweeperIdleTimeout,
+		auxiliaryMapLimit:      defaultAuxiliaryMapLimit,
+		samplingRate:           1,
+		lazyExpireOnGet:        true,
+		evictionSampleSize:     defaultEvictionSampleSize,
+		loaderCalls:            make(map[string]*loaderCall),
+		random:                 newLockedRand(nil),
+		memoryCheckInterval:    defaultMemoryCheckInterval,
+		memoryStats:            heapAlloc,
+		subscriptions:          make(map[*subscription]struct{}),
+		keyWaiters:             make(map[string]map[keyWaiter]struct{}),
 	}
-	go cache.startExpirationProcessing()
+	return cache
+}
+
+// NewCacheManualSweep creates a cache that never runs a background sweeper, expired items are only evicted
+// by RunCleanup, and by lookups that find them, see SetLazyExpireOnGet. Lookups treat expired items as missing.
+// This keeps background work from interfering with benchmarks, or leaves the timing of evictions to the caller.
+func NewCacheManualSweep() *Cache {
+	cache := NewCache()
+	cache.manualSweep = true
+	return cache
+}
+
+// NewCacheWithCapacity creates a cache sized for the given number of items. Its item map and the queue ordering
+// the items by expiration never give back their capacity, also when the cache is purged, so churning through
+// short lived items reuses them instead of reallocating. The price is that the memory for the largest number of
+// items the cache held stays allocated until the cache is released, so only hint a capacity the cache actually
+// reaches.
+func NewCacheWithCapacity(capacity int) *Cache {
+	cache := NewCache()
+	cache.items = itemIndex{ItemMap: make(builtinItemMap, capacity), keepCapacity: true}
+	cache.priorityQueue = newPriorityQueue(capacity)
+	return cache
+}
+
+// NewLRUCache creates a cache without time based expiration that holds at most maxItems items, evicting the least
+// recently used ones beyond that. Items only leave it by eviction or removal, so no sweeper goroutine runs as
+// long as no TTL is set on the cache or its items.
+func NewLRUCache(maxItems int) *Cache {
+	cache := NewCache()
+	cache.maxItems = maxItems
 	return cache
 }
 
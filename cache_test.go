@@ -2,7 +2,11 @@ package ttlcache
 
 import (
 	"errors"
+	"math"
 	"math/rand"
+	"runtime"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -41,6 +45,51 @@ func TestCache_MultipleCloseCalls(t *testing.T) {
 	cache.Close()
 }
 
+func TestCacheDone(t *testing.T) {
+	cache := NewCache()
+	done := cache.Done()
+
+	observed := make(chan struct{})
+	go func() {
+		defer close(observed)
+		select {
+		case <-cache.Done():
+		case <-time.After(time.Second):
+			t.Error("Expected the cache to be closed")
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected Done not to be closed before Close")
+	default:
+	}
+	cache.Close()
+	cache.Close()
+	<-observed
+	assert.Equal(t, done, cache.Done(), "Expected the same channel after Close")
+}
+
+func TestCache_DrainOnClose(t *testing.T) {
+	cache := NewCache()
+
+	expired := make(map[string]int)
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired[key]++
+	})
+	cache.SetDrainOnClose(true)
+	cache.SetTTL(time.Hour)
+	cache.Set("key1", "value")
+	cache.Set("key2", "value")
+	cache.SetWithTTL("key3", "value", ItemNotExpire)
+
+	cache.Close()
+	cache.Close()
+
+	assert.Equal(t, map[string]int{"key1": 1, "key2": 1, "key3": 1}, expired, "Expected one expiration per remaining item")
+	assert.Equal(t, 0, cache.Count(), "Expected the cache to be empty after close")
+}
+
 // test for Feature request in issue #12
 //
 func TestCache_SkipTtlExtensionOnHit(t *testing.T) {
@@ -186,6 +235,64 @@ func TestCache_SetCheckExpirationCallback(t *testing.T) {
 	<-ch
 }
 
+func TestCacheSetCheckExpirationCallbackV2(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var checks int32
+	cache.SetCheckExpirationCallbackV2(func(key string, value interface{}) CheckResult {
+		if key == "granted" && atomic.AddInt32(&checks, 1) == 1 {
+			return CheckKeepWithTTL(time.Minute)
+		}
+		return CheckExpire
+	})
+	start := time.Now()
+	cache.SetWithTTL("granted", "value", 10*time.Millisecond)
+	cache.SetWithTTL("other", "value", 10*time.Millisecond)
+	cache.SkipTtlExtensionOnHit(true)
+
+	for atomic.LoadInt32(&checks) == 0 && time.Since(start) < time.Second {
+		<-time.After(5 * time.Millisecond)
+	}
+	_, found := cache.Get("granted")
+	assert.Equal(t, true, found, "Expected the granted item to survive its TTL")
+	_, otherFound := cache.Get("other")
+	assert.Equal(t, false, otherFound, "Expected the other item to expire")
+	info, _ := cache.GetItemInfo("granted")
+	assert.InDelta(t, float64(time.Minute), float64(time.Until(info.ExpiresAt)), float64(time.Second),
+		"Expected the granted item to live until its new deadline")
+
+	ageItem(cache, "granted", time.Minute)
+	_, found = cache.Get("granted")
+	assert.Equal(t, false, found, "Expected the granted item to expire at its new deadline")
+}
+
+func TestCacheSetMaxCheckDenials(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var checks int32
+	expired := make(chan string, 1)
+	cache.SetMaxCheckDenials(3)
+	cache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		atomic.AddInt32(&checks, 1)
+		return false
+	})
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired <- key
+	})
+	cache.SetWithTTL("stuck", "value", 10*time.Millisecond)
+
+	select {
+	case key := <-expired:
+		assert.Equal(t, "stuck", key)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the item to be force expired")
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&checks), "Expected the item to expire after 3 denials")
+	assert.Equal(t, 0, cache.Count())
+}
+
 // test github issue #9
 // Due to scheduling the expected TTL of the top entry can become negative (already expired)
 // This is an issue because negative TTL at the item level was interpreted as 'use global TTL'
@@ -215,6 +322,24 @@ func TestCache_SetExpirationCallback(t *testing.T) {
 	}
 }
 
+func TestCacheSetWinsOverConcurrentExpiration(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.SetWithTTL("key", i, 100*time.Microsecond)
+		time.Sleep(time.Duration(rand.Int63n(int64(200 * time.Microsecond))))
+		cache.SetWithTTL("key", i, time.Minute)
+
+		data, exists := cache.Get("key")
+		if !assert.Equal(t, true, exists, "Expected refreshed item to survive the expiration") {
+			break
+		}
+		assert.Equal(t, i, data, "Expected the refreshed value")
+	}
+	assert.Nil(t, cache.Verify(), "Expected invariants to hold")
+}
+
 // test github issue #4
 func TestRemovalAndCountDoesNotPanic(t *testing.T) {
 	cache := NewCache()
@@ -258,6 +383,46 @@ func TestRemovalWithTtlDoesNotPanic(t *testing.T) {
 	t.Logf("cache has %d keys\n", count)
 }
 
+func TestCacheSetExpiryAlignment(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	assert.Nil(t, cache.SetExpiryAlignment(time.Minute))
+	boundary := time.Now().Add(2 * time.Minute).Truncate(time.Minute).Add(time.Minute)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		<-time.After(time.Duration(random.Intn(3)) * time.Millisecond)
+		ttl := time.Until(boundary) - time.Duration(random.Int63n(int64(50*time.Second)))
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), i, ttl)
+	}
+	for i := 0; i < 10; i++ {
+		info, _ := cache.GetItemInfo(fmt.Sprintf("key_%d", i))
+		assert.True(t, boundary.Equal(info.ExpiresAt), "Expected all items to expire at the aligned boundary")
+	}
+
+	assert.Equal(t, ErrExpiryConflict, cache.SetTTLJitter(time.Second), "Expected jitter to conflict with alignment")
+	assert.Nil(t, cache.SetExpiryAlignment(0))
+	assert.Nil(t, cache.SetTTLJitter(time.Second))
+	assert.Equal(t, ErrExpiryConflict, cache.SetExpiryAlignment(time.Minute), "Expected alignment to conflict with jitter")
+}
+
+func TestCacheNearMaxTTLDoesNotExpire(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTLJitter(time.Hour)
+	cache.SetGracePeriod(time.Duration(math.MaxInt64))
+	cache.SetWithTTL("max", "value", time.Duration(math.MaxInt64))
+	cache.SetWithTTL("nearMax", "value", time.Duration(math.MaxInt64-1))
+	<-time.After(50 * time.Millisecond)
+
+	_, exists := cache.Get("max")
+	assert.Equal(t, true, exists, "Expected item with max TTL to not expire")
+	_, exists = cache.Get("nearMax")
+	assert.Equal(t, true, exists, "Expected item with near max TTL to not expire")
+	assert.Equal(t, 2, cache.Count(), "Expected no item to be evicted")
+}
+
 func TestCacheIndividualExpirationBiggerThanGlobal(t *testing.T) {
 	cache := NewCache()
 	defer cache.Close()
@@ -368,13 +533,259 @@ func TestCacheGetOrDefault(t *testing.T) {
 	assert.Equal(t, "world", data, "Expected data content to be the last set 'world'")
 
 	cache.Remove("hello")
+	loaderErr := errors.New("error")
 	data, err = cache.GetOrDefault("hello", func(key string) (interface{}, error) {
-		return nil, errors.New("error")
+		return nil, loaderErr
 	})
 	assert.Error(t, err, "Expected cache to succeed")
 	if assert.Error(t, err) {
-		assert.Equal(t, errors.New("error"), err)
+		assert.True(t, errors.Is(err, loaderErr), "Expected the loader error to be returned")
+	}
+}
+
+func TestCacheGetOrDefaultWithTTL(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Hour)
+	cache.SkipTtlExtensionOnHit(true)
+
+	data, err := cache.GetOrDefaultWithTTL("hello", func(key string) (interface{}, error) {
+		return "value", nil
+	}, time.Minute)
+	assert.Nil(t, err, "Expected cache to succeed")
+	assert.Equal(t, "value", data, "Expected data content to be the default 'value'")
+
+	data, exists := cache.Get("hello")
+	assert.Equal(t, true, exists, "Expected default to be cached")
+	assert.Equal(t, "value", data, "Expected cached default to be 'value'")
+
+	ageItem(cache, "hello", time.Minute)
+	_, exists = cache.Get("hello")
+	assert.Equal(t, false, exists, "Expected default to expire with the supplied TTL")
+
+	_, err = cache.GetOrDefaultWithTTL("failing", func(key string) (interface{}, error) {
+		return nil, errors.New("error")
+	}, time.Minute)
+	assert.Error(t, err, "Expected loader error to be returned")
+	_, exists = cache.Get("failing")
+	assert.Equal(t, false, exists, "Expected nothing to be cached on loader error")
+}
+
+func TestCacheTTLHistogram(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("short", "value", 50*time.Millisecond)
+	cache.SetWithTTL("medium", "value", 500*time.Millisecond)
+	cache.SetWithTTL("medium2", "value", 700*time.Millisecond)
+	cache.SetWithTTL("long", "value", time.Hour)
+	cache.SetWithTTL("permanent", "value", ItemNotExpire)
+
+	histogram := cache.TTLHistogram([]time.Duration{time.Second, 100 * time.Millisecond})
+	assert.Equal(t, 1, histogram[100*time.Millisecond], "Expected 1 item in the 100ms bucket")
+	assert.Equal(t, 2, histogram[time.Second], "Expected 2 items in the 1s bucket")
+	assert.Equal(t, 1, histogram[TTLHistogramOverflow], "Expected 1 item in the overflow bucket")
+	assert.Equal(t, 1, histogram[TTLHistogramPermanent], "Expected 1 item in the permanent bucket")
+}
+
+func TestCacheTTLHistogramWithIdleTimeout(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetIdleTimeout(time.Minute)
+	cache.SetWithTTL("long", "value", time.Hour)
+	cache.SetWithTTL("short", "value", time.Second)
+	cache.SetWithTTL("permanent", "value", ItemNotExpire)
+
+	histogram := cache.TTLHistogram([]time.Duration{time.Second, time.Minute})
+	assert.Equal(t, 1, histogram[time.Second], "Expected the item expiring before it goes idle in the 1s bucket")
+	assert.Equal(t, 2, histogram[time.Minute], "Expected the items going idle first in the 1m bucket")
+	assert.Equal(t, 0, histogram[TTLHistogramOverflow], "Expected no item in the overflow bucket")
+	assert.Equal(t, 0, histogram[TTLHistogramPermanent], "Expected no item in the permanent bucket")
+}
+
+func TestCacheAppendFromGoroutines(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				cache.Append("events", i*10+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	values, exists := cache.GetSlice("events")
+	assert.Equal(t, true, exists, "Expected slice to exist")
+	assert.Equal(t, 1000, len(values), "Expected every append to be stored")
+
+	values[0] = "modified"
+	stored, _ := cache.GetSlice("events")
+	assert.NotEqual(t, "modified", stored[0], "Expected GetSlice to return a copy")
+}
+
+func TestCacheAppendDoesNotWriteToStoredSlice(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	backing := make([]interface{}, 1, 4)
+	backing[0] = 1
+	cache.Set("events", backing)
+	cache.Append("events", 2)
+	cache.Append("events", 3)
+
+	assert.Nil(t, backing[:2][1], "Expected Append to not write to the backing array of the stored slice")
+	values, _ := cache.GetSlice("events")
+	assert.Equal(t, []interface{}{1, 2, 3}, values, "Expected both appended values")
+}
+
+func TestCachePreserveTTLOnAppend(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Minute)
+	cache.SkipTtlExtensionOnHit(true)
+	cache.PreserveTTLOnAppend(true)
+
+	cache.Append("events", 1)
+	ageItem(cache, "events", 40*time.Second)
+	cache.Append("events", 2)
+	ageItem(cache, "events", 40*time.Second)
+	_, exists := cache.GetSlice("events")
+	assert.Equal(t, false, exists, "Expected append to preserve the TTL")
+
+	cache.PreserveTTLOnAppend(false)
+	cache.Append("events", 1)
+	ageItem(cache, "events", 40*time.Second)
+	cache.Append("events", 2)
+	ageItem(cache, "events", 40*time.Second)
+	values, exists := cache.GetSlice("events")
+	assert.Equal(t, true, exists, "Expected append to reset the TTL")
+	assert.Equal(t, []interface{}{1, 2}, values, "Expected both appended values")
+}
+
+func TestCacheBeforeSetCallbackVetoesAppend(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetBeforeSetCallback(func(key string, value interface{}) bool {
+		values, isSlice := value.([]interface{})
+		return isSlice && len(values) <= 2 && key != "rejected"
+	})
+
+	cache.Append("rejected", 1)
+	_, exists := cache.Get("rejected")
+	assert.Equal(t, false, exists, "Expected the rejected slice to not be created")
+
+	cache.Append("events", 1)
+	cache.Append("events", 2)
+	cache.Append("events", 3)
+	values, _ := cache.GetSlice("events")
+	assert.Equal(t, []interface{}{1, 2}, values, "Expected the rejected append to leave the slice unchanged")
+}
+
+func TestCacheGetWithStale(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SkipTtlExtensionOnHit(true)
+	cache.SetGracePeriod(time.Minute)
+	cache.SetWithTTL("key", "value", time.Minute)
+
+	data, fresh, found := cache.GetWithStale("key")
+	assert.Equal(t, true, found, "Expected fresh item to be found")
+	assert.Equal(t, true, fresh, "Expected item to be fresh")
+	assert.Equal(t, "value", data, "Expected fresh value")
+
+	ageItem(cache, "key", 90*time.Second)
+	data, fresh, found = cache.GetWithStale("key")
+	assert.Equal(t, true, found, "Expected item within grace period to be found")
+	assert.Equal(t, false, fresh, "Expected item within grace period to be stale")
+	assert.Equal(t, "value", data, "Expected stale value")
+	_, exists := cache.Get("key")
+	assert.Equal(t, false, exists, "Expected Get to miss a stale item")
+
+	ageItem(cache, "key", time.Minute)
+	data, fresh, found = cache.GetWithStale("key")
+	assert.Equal(t, false, found, "Expected item beyond grace period to be gone")
+	assert.Equal(t, false, fresh, "Expected item beyond grace period to not be fresh")
+	assert.Nil(t, data, "Expected no value beyond grace period")
+	cache.RunCleanup()
+	assert.Equal(t, 0, cache.Count(), "Expected item to be evicted after the grace period")
+}
+
+func TestCacheGetMultiWithTTL(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SkipTtlExtensionOnHit(true)
+	cache.SetWithTTL("short", "value1", time.Second)
+	cache.SetWithTTL("long", "value2", time.Minute)
+	cache.SetWithTTL("permanent", "value3", ItemNotExpire)
+
+	result := cache.GetMultiWithTTL([]string{"short", "long", "permanent", "missing"})
+	assert.Equal(t, 3, len(result), "Expected only found items")
+	assert.Equal(t, "value1", result["short"].Value, "Expected value of 'short'")
+	assert.InDelta(t, float64(time.Second), float64(result["short"].TTL), float64(100*time.Millisecond), "Expected remaining TTL of 'short'")
+	assert.Equal(t, "value2", result["long"].Value, "Expected value of 'long'")
+	assert.InDelta(t, float64(time.Minute), float64(result["long"].TTL), float64(100*time.Millisecond), "Expected remaining TTL of 'long'")
+	assert.Equal(t, "value3", result["permanent"].Value, "Expected value of 'permanent'")
+	assert.Equal(t, ItemNotExpire, result["permanent"].TTL, "Expected no TTL for 'permanent'")
+	_, found := result["missing"]
+	assert.Equal(t, false, found, "Expected missing key to be absent")
+}
+
+func TestCacheGetGroup(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Minute)
+	cache.Set("key1", "value1")
+	<-time.After(10 * time.Millisecond)
+	cache.Set("key2", "value2")
+	<-time.After(10 * time.Millisecond)
+	cache.Set("key3", "value3")
+
+	result := cache.GetGroup([]string{"key1", "key2", "key3", "missing"})
+	assert.Equal(t, map[string]interface{}{"key1": "value1", "key2": "value2", "key3": "value3"}, result, "Expected only found items")
+
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	assert.Equal(t, storedItem(cache, "key1").expireAt, storedItem(cache, "key2").expireAt, "Expected identical expiration")
+	assert.Equal(t, storedItem(cache, "key1").expireAt, storedItem(cache, "key3").expireAt, "Expected identical expiration")
+}
+
+func TestCacheGetItemInfo(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	_, exists := cache.GetItemInfo("key")
+	assert.Equal(t, false, exists, "Expected no info for a missing item")
+
+	before := time.Now()
+	cache.SetWithTTL("key", "value", time.Minute)
+	for i := 0; i < 3; i++ {
+		<-time.After(20 * time.Millisecond)
+		cache.Get("key")
 	}
+	lastAccess := time.Now()
+
+	info, exists := cache.GetItemInfo("key")
+	assert.Equal(t, true, exists, "Expected info for the item")
+	assert.Equal(t, int64(3), info.AccessCount, "Expected 3 accesses")
+	assert.False(t, info.CreatedAt.Before(before), "Expected creation time after the set")
+	assert.True(t, info.LastAccessedAt.Sub(info.CreatedAt) >= 60*time.Millisecond, "Expected last access after the delays")
+	assert.False(t, info.LastAccessedAt.After(lastAccess), "Expected last access before the lookup of the info")
+	assert.Equal(t, info.LastAccessedAt.Add(time.Minute), info.ExpiresAt, "Expected expiration to be extended by the last access")
+
+	again, _ := cache.GetItemInfo("key")
+	assert.Equal(t, info, again, "Expected GetItemInfo to not count as access")
 }
 
 func TestCacheExpirationCallbackFunction(t *testing.T) {
@@ -483,6 +894,354 @@ func TestCacheNewItemCallbackFunction(t *testing.T) {
 	assert.Equal(t, 2, newItemCount, "Expected only 2 new items")
 }
 
+func TestCacheSetNewItemVisibilityMode(t *testing.T) {
+	for _, mode := range []NewItemVisibility{VisibleFirst, CallbackFirst} {
+		cache := NewCache()
+		cache.SetNewItemVisibilityMode(mode)
+
+		// the callback looks the item up itself, and waits for a concurrent lookup while it runs
+		var visibleInCallback, visibleConcurrently int32
+		cache.SetNewItemCallback(func(key string, value interface{}) {
+			if _, found := cache.Get(key); found {
+				atomic.AddInt32(&visibleInCallback, 1)
+			}
+			concurrent := make(chan bool)
+			go func() {
+				_, found := cache.Get(key)
+				concurrent <- found
+			}()
+			if <-concurrent {
+				atomic.AddInt32(&visibleConcurrently, 1)
+			}
+		})
+
+		for j := 0; j < 5; j++ {
+			cache.Set(fmt.Sprintf("key_%d", j), j)
+		}
+
+		if mode == CallbackFirst {
+			assert.Equal(t, int32(0), atomic.LoadInt32(&visibleInCallback), "Expected items to be hidden during the callback")
+			assert.Equal(t, int32(0), atomic.LoadInt32(&visibleConcurrently), "Expected no lookup to see an item before its callback returned")
+		} else {
+			assert.Equal(t, int32(5), atomic.LoadInt32(&visibleInCallback), "Expected items to be visible during the callback")
+			assert.Equal(t, int32(5), atomic.LoadInt32(&visibleConcurrently), "Expected lookups to see items during the callback")
+		}
+		assert.Equal(t, 5, cache.Count(), "Expected all items to be visible after the callbacks")
+		cache.Close()
+	}
+}
+
+func TestCacheBeforeSetCallbackRejectsNil(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	newItemCount := 0
+	cache.SetNewItemCallback(func(key string, value interface{}) {
+		newItemCount++
+	})
+	cache.SetBeforeSetCallback(func(key string, value interface{}) bool {
+		return value != nil
+	})
+
+	assert.Equal(t, ErrRejected, cache.Set("missing", nil), "Expected nil value to be rejected")
+	_, exists := cache.Get("missing")
+	assert.Equal(t, false, exists, "Expected rejected item to not be inserted")
+	assert.Equal(t, 0, newItemCount, "Expected no new item callback for a rejected item")
+
+	assert.Nil(t, cache.Set("key", "value"), "Expected value to be accepted")
+	assert.Equal(t, ErrRejected, cache.SetWithTTL("key", nil, time.Minute), "Expected nil overwrite to be rejected")
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected prior item to survive a rejected overwrite")
+	assert.Equal(t, "value", data, "Expected prior value to survive a rejected overwrite")
+}
+
+func TestCachePromote(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SkipTtlExtensionOnHit(true)
+	cache.SetWithTTL("idle", "value", time.Minute)
+	<-time.After(time.Millisecond)
+	cache.SetWithTTL("busy", "value", time.Minute)
+	<-time.After(time.Millisecond)
+	expireAt := storedItem(cache, "idle").expireAt
+
+	assert.Equal(t, true, cache.Promote("idle"), "Expected present item to be promoted")
+	assert.Equal(t, false, cache.Promote("missing"), "Expected missing item to not be promoted")
+
+	cache.mutex.Lock()
+	evicted := cache.evictLeastRecentlyUsed(1)
+	cache.mutex.Unlock()
+	assert.Equal(t, 1, len(evicted), "Expected one item to be evicted")
+	assert.Equal(t, "busy", evicted[0].key, "Expected the not promoted item to be evicted")
+
+	info, exists := cache.GetItemInfo("idle")
+	assert.Equal(t, true, exists, "Expected promoted item to survive")
+	assert.Equal(t, expireAt, info.ExpiresAt, "Expected promotion to not extend the TTL")
+	assert.Equal(t, int64(0), info.AccessCount, "Expected promotion to not count as access")
+}
+
+func TestCacheGetOrExtend(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Minute)
+	cache.SkipTtlExtensionOnHit(true)
+	cache.Set("session", "value")
+
+	for i := 0; i < 5; i++ {
+		ageItem(cache, "session", 30*time.Second)
+		data, exists := cache.GetOrExtend("session", time.Minute)
+		assert.Equal(t, true, exists, "Expected extended item to survive past the global TTL")
+		assert.Equal(t, "value", data, "Expected the stored value")
+	}
+
+	data, exists := cache.GetOrExtend("missing", time.Minute)
+	assert.Equal(t, false, exists, "Expected missing item to not be found")
+	assert.Nil(t, data, "Expected no value for a missing item")
+	assert.Equal(t, 1, cache.Count(), "Expected GetOrExtend to not create items")
+}
+
+func TestCacheExtendIf(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("lease", "holder-a", time.Minute)
+
+	var wg sync.WaitGroup
+	extended := make([]int32, 2)
+	for i, token := range []string{"holder-a", "holder-b"} {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if cache.ExtendIf("lease", time.Hour, func(value interface{}) bool { return value == token }) {
+					atomic.AddInt32(&extended[i], 1)
+				}
+			}
+		}(i, token)
+	}
+	wg.Wait()
+	assert.Equal(t, []int32{100, 0}, extended, "Expected only the holder of the lease to extend it")
+
+	ageItem(cache, "lease", 2*time.Minute)
+	value, found := cache.Get("lease")
+	assert.Equal(t, true, found, "Expected the lease to outlive its original TTL")
+	assert.Equal(t, "holder-a", value)
+
+	assert.Equal(t, false, cache.ExtendIf("missing", time.Second, func(interface{}) bool { return true }))
+	cache.SetWithTTL("expired", "holder-a", time.Nanosecond)
+	<-time.After(time.Millisecond)
+	assert.Equal(t, false, cache.ExtendIf("expired", time.Second, func(interface{}) bool { return true }),
+		"Expected an expired lease not to be renewed")
+}
+
+func TestCacheTouchMany(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("session/a", "a", time.Minute)
+	<-time.After(2 * time.Millisecond)
+	cache.SetWithTTL("session/b", "b", time.Minute)
+	cache.SetWithTTL("expired", "c", time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	touched := cache.TouchMany([]string{"session/a", "session/b", "missing", "expired"}, time.Hour)
+	assert.Equal(t, 2, touched, "Expected only the present items to be counted")
+	cache.mutex.RLock()
+	a, b := storedItem(cache, "session/a"), storedItem(cache, "session/b")
+	assert.Equal(t, a.expireAt, b.expireAt, "Expected the items to share the new deadline")
+	assert.InDelta(t, float64(time.Hour), float64(time.Until(a.expireAt)), float64(time.Second))
+	cache.mutex.RUnlock()
+
+	ageItem(cache, "session/a", 2*time.Minute)
+	_, found := cache.Get("session/a")
+	assert.Equal(t, true, found, "Expected the touched item to outlive its TTL")
+}
+
+func TestCacheGetWithFallbackKeys(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("config", "general")
+	cache.Set("config/eu/nl", "specific")
+
+	key, data, found := cache.GetWithFallbackKeys("config/eu/nl", "config/eu", "config")
+	assert.Equal(t, true, found, "Expected the specific key to be found")
+	assert.Equal(t, "config/eu/nl", key, "Expected the specific key to match")
+	assert.Equal(t, "specific", data, "Expected the specific value")
+
+	cache.Remove("config/eu/nl")
+	key, data, found = cache.GetWithFallbackKeys("config/eu/nl", "config/eu", "config")
+	assert.Equal(t, true, found, "Expected the general key to be found")
+	assert.Equal(t, "config", key, "Expected the general key to match")
+	assert.Equal(t, "general", data, "Expected the general value")
+
+	key, data, found = cache.GetWithFallbackKeys("missing", "absent")
+	assert.Equal(t, false, found, "Expected no key to be found")
+	assert.Equal(t, "", key, "Expected no key to match")
+	assert.Nil(t, data, "Expected no value")
+}
+
+func TestCacheUpdate(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	type counter struct{ count int }
+	increment := func(current interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return counter{count: 1}, true
+		}
+		return counter{count: current.(counter).count + 1}, true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, true, cache.Update("counter", increment, false))
+			}
+		}()
+	}
+	wg.Wait()
+	value, _ := cache.Get("counter")
+	assert.Equal(t, counter{count: 1000}, value, "Expected no update to be lost")
+
+	cache.SetWithTTL("ttl", 1, time.Minute)
+	cache.mutex.RLock()
+	expireAt := storedItem(cache, "ttl").expireAt
+	cache.mutex.RUnlock()
+	<-time.After(5 * time.Millisecond)
+	cache.Update("ttl", func(current interface{}, exists bool) (interface{}, bool) { return 2, true }, false)
+	cache.mutex.RLock()
+	assert.Equal(t, expireAt, storedItem(cache, "ttl").expireAt, "Expected the expiration to be preserved")
+	cache.mutex.RUnlock()
+	cache.Update("ttl", func(current interface{}, exists bool) (interface{}, bool) { return 3, true }, true)
+	cache.mutex.RLock()
+	assert.True(t, storedItem(cache, "ttl").expireAt.After(expireAt), "Expected the expiration to be reset")
+	cache.mutex.RUnlock()
+
+	remove := func(current interface{}, exists bool) (interface{}, bool) { return nil, false }
+	assert.Equal(t, true, cache.Update("counter", remove, false), "Expected the item to be removed")
+	_, found := cache.Get("counter")
+	assert.Equal(t, false, found)
+	assert.Equal(t, false, cache.Update("counter", remove, false), "Expected no change for a missing item")
+}
+
+func TestCacheRefresh(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("key", "old", time.Minute)
+	cache.Get("key")
+	cache.Get("key")
+	before, _ := cache.GetItemInfo("key")
+	<-time.After(5 * time.Millisecond)
+
+	assert.Equal(t, true, cache.Refresh("key", "new"), "Expected the present key to be refreshed")
+	value, _ := cache.Get("key")
+	assert.Equal(t, "new", value, "Expected the refreshed value")
+	after, _ := cache.GetItemInfo("key")
+	assert.Equal(t, before.CreatedAt, after.CreatedAt, "Expected the creation time to be preserved")
+	assert.Equal(t, before.AccessCount+1, after.AccessCount, "Expected the access count to be preserved")
+	assert.True(t, after.ExpiresAt.After(before.ExpiresAt), "Expected the TTL to be reset")
+
+	assert.Equal(t, false, cache.Refresh("missing", "value"), "Expected no refresh of a missing key")
+	_, found := cache.Get("missing")
+	assert.Equal(t, false, found, "Expected the missing key not to be created")
+}
+
+func TestCacheSetReplaceCallback(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var mutex sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mutex.Lock()
+		calls = append(calls, call)
+		mutex.Unlock()
+	}
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		record(fmt.Sprintf("remove %s %v", key, value))
+	})
+	cache.SetReplaceCallback(func(key string, oldValue, newValue interface{}) {
+		record(fmt.Sprintf("replace %s %v %v", key, oldValue, newValue))
+	})
+
+	cache.Set("key", 1)
+	cache.Set("key", 2)
+	cache.SetWithTTL("expiring", 1, time.Millisecond)
+	<-time.After(20 * time.Millisecond)
+	cache.Set("expiring", 2)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"remove key 1", "replace key 1 2", "remove expiring 1"}, calls,
+		"Expected the replace callback with the old and the new value after the remove callback, "+
+			"and only for replaced live items")
+}
+
+func TestCacheReplaceIfPresent(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var replaced []interface{}
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		replaced = append(replaced, value)
+	})
+
+	assert.Equal(t, false, cache.ReplaceIfPresent("key", "value", true), "Expected absent item to not be replaced")
+	assert.Equal(t, 0, cache.Count(), "Expected absent item to not be inserted")
+
+	expireAt, _ := cache.SetWithTTLAt("key", "value", time.Minute)
+	<-time.After(time.Millisecond)
+	assert.Equal(t, true, cache.ReplaceIfPresent("key", "value2", false), "Expected present item to be replaced")
+	info, _ := cache.GetItemInfo("key")
+	assert.Equal(t, expireAt, info.ExpiresAt, "Expected the expiration to be preserved")
+
+	assert.Equal(t, true, cache.ReplaceIfPresent("key", "value3", true), "Expected present item to be replaced")
+	info, _ = cache.GetItemInfo("key")
+	assert.True(t, info.ExpiresAt.After(expireAt), "Expected the expiration to be reset")
+
+	data, _ := cache.Get("key")
+	assert.Equal(t, "value3", data, "Expected the replaced value")
+	assert.Equal(t, []interface{}{"value", "value2"}, replaced, "Expected the remove callback for the old values")
+}
+
+func TestCacheGetExpiring(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	expired := make(chan string, 1)
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired <- key
+	})
+	cache.SetReturnExpiredOnce(true)
+	// a grace period keeps the sweeper from evicting the item before it is looked up
+	cache.SetGracePeriod(time.Hour)
+	cache.SetWithTTL("key", "value", time.Minute)
+
+	data, isExpired, found := cache.GetExpiring("key")
+	assert.Equal(t, true, found, "Expected live item to be found")
+	assert.Equal(t, false, isExpired, "Expected live item to not be expired")
+	assert.Equal(t, "value", data, "Expected the stored value")
+
+	ageItem(cache, "key", time.Minute)
+	data, isExpired, found = cache.GetExpiring("key")
+	assert.Equal(t, true, found, "Expected expired item to be returned once")
+	assert.Equal(t, true, isExpired, "Expected item to be flagged as expired")
+	assert.Equal(t, "value", data, "Expected the stale value")
+	assert.Equal(t, "key", <-expired, "Expected the expiration callback to be called")
+
+	data, isExpired, found = cache.GetExpiring("key")
+	assert.Equal(t, false, found, "Expected the second lookup to miss")
+	assert.Equal(t, false, isExpired, "Expected a miss to not be flagged as expired")
+	assert.Nil(t, data, "Expected no value on a miss")
+}
+
 func TestCacheRemove(t *testing.T) {
 	cache := NewCache()
 	defer cache.Close()
@@ -528,3 +1287,894 @@ func TestCache_Purge(t *testing.T) {
 	}
 
 }
+
+func TestCache_PurgeReturnsCount(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	purged := -1
+	cache.SetPurgeCallback(func(count int) {
+		purged = count
+	})
+	removed := 0
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		removed++
+	})
+
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+
+	assert.Equal(t, 5, cache.Purge(), "Expected Purge to return the number of dropped items")
+	assert.Equal(t, 5, purged, "Expected the purge callback to receive the number of dropped items")
+	assert.Equal(t, 0, removed, "Expected Purge to not call the remove callback")
+	assert.Equal(t, 0, cache.Purge(), "Expected an empty cache to purge nothing")
+}
+
+func TestCache_PurgeWhileSettingPurgeCallback(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cache.SetPurgeCallback(func(count int) {})
+		}
+	}()
+	// the race detector reports a purge callback read without the lock
+	for i := 0; i < 100; i++ {
+		cache.Purge()
+	}
+	<-done
+}
+
+func TestCacheStartsSweeperLazily(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+	cache.mutex.Lock()
+	cache.sweeperIdleTimeout = 200 * time.Millisecond
+	cache.mutex.Unlock()
+
+	goroutines := runtime.NumGoroutine()
+	cache.SetWithTTL("permanent", "value", ItemNotExpire)
+	cache.Set("global", "value")
+	cache.Get("permanent")
+	assert.Equal(t, goroutines, runtime.NumGoroutine(), "Expected no sweeper for items that do not expire")
+
+	cache.SetWithTTL("expiring", "value", 10*time.Millisecond)
+	cache.SetWithTTL("another", "value", 10*time.Millisecond)
+	assert.Equal(t, goroutines+1, runtime.NumGoroutine(), "Expected a single sweeper once an item expires")
+
+	start := time.Now()
+	for (cache.Count() != 2 || runtime.NumGoroutine() != goroutines) && time.Since(start) < 2*time.Second {
+		<-time.After(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, cache.Count(), "Expected the expiring items to be evicted")
+	assert.Equal(t, goroutines, runtime.NumGoroutine(), "Expected the idle sweeper to stop")
+
+	cache.SetWithTTL("expiring", "value", 10*time.Millisecond)
+	start = time.Now()
+	for cache.Count() != 2 && time.Since(start) < time.Second {
+		<-time.After(5 * time.Millisecond)
+	}
+	assert.Equal(t, 2, cache.Count(), "Expected a restarted sweeper to evict the item")
+}
+
+func TestCacheReplaceAll(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var lock sync.Mutex
+	removed := map[string]bool{}
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		lock.Lock()
+		removed[key] = true
+		lock.Unlock()
+	})
+	snapshot := func(version int) map[string]interface{} {
+		return map[string]interface{}{"a": version, "b": version, "c": version}
+	}
+	cache.ReplaceAll(snapshot(0), ItemNotExpire)
+	cache.Set("stale", 0)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			values := cache.GetMultiWithTTL([]string{"a", "b", "c"})
+			assert.Equal(t, 3, len(values), "Expected a complete snapshot")
+			for _, value := range values {
+				assert.Equal(t, values["a"].Value, value.Value, "Expected a consistent snapshot")
+			}
+		}
+	}()
+	for version := 1; version <= 100; version++ {
+		cache.ReplaceAll(snapshot(version), ItemNotExpire)
+	}
+	close(done)
+	wg.Wait()
+
+	assert.Equal(t, 3, cache.Count(), "Expected only the new items")
+	data, _ := cache.Get("a")
+	assert.Equal(t, 100, data, "Expected the last snapshot")
+	lock.Lock()
+	assert.Equal(t, true, removed["stale"], "Expected the remove callback for old items")
+	lock.Unlock()
+}
+
+func TestCacheEmptyStateCallback(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var transitions []bool
+	cache.SetEmptyStateCallback(func(isEmpty bool) {
+		transitions = append(transitions, isEmpty)
+	})
+
+	cache.Set("key", "value")
+	cache.Set("key", "value")
+	cache.Set("another", "value")
+	cache.Remove("key")
+	cache.Remove("another")
+	cache.Remove("missing")
+	cache.SetWithTTL("expiring", "value", 10*time.Millisecond)
+	<-time.After(50 * time.Millisecond)
+	cache.Set("key", "value")
+	cache.Set("another", "value")
+	cache.Purge()
+	cache.Purge()
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	assert.Equal(t, []bool{false, true, false, true, false, true}, transitions, "Expected a call per real transition")
+}
+
+func TestCacheKeyValidator(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	errTooLong := errors.New("key too long")
+	cache.SetKeyValidator(func(key string) error {
+		if len(key) > 8 {
+			return errTooLong
+		}
+		return nil
+	})
+
+	assert.Nil(t, cache.Set("valid", "value"), "Expected a valid key to be stored")
+	assert.Equal(t, errTooLong, cache.Set("much_too_long", "value"), "Expected the validator error")
+	cache.Append("also_too_long", "value")
+	_, err := cache.GetOrDefault("way_too_long", func(string) (interface{}, error) { return "value", nil })
+	assert.Equal(t, errTooLong, err, "Expected the validator error from the loader function")
+
+	assert.Equal(t, 1, cache.Count(), "Expected only the valid key to be stored")
+	_, exists := cache.Get("much_too_long")
+	assert.Equal(t, false, exists, "Expected the rejected key to not be stored")
+	assert.Equal(t, uint64(3), cache.GetMetrics().RejectedKeys, "Expected every rejected key to be counted")
+}
+
+func TestCacheSetWithExpiryTime(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Hour)
+	expireAt := time.Now().Add(50 * time.Millisecond)
+	assert.Nil(t, cache.SetWithExpiryTime("key", "value", expireAt))
+	info, _ := cache.GetItemInfo("key")
+	assert.Equal(t, expireAt, info.ExpiresAt, "Expected the exact expiration time")
+
+	<-time.After(30 * time.Millisecond)
+	_, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected the item to live until its expiration time")
+	<-time.After(40 * time.Millisecond)
+	_, exists = cache.Get("key")
+	assert.Equal(t, false, exists, "Expected the lookup to not extend the expiration time")
+
+	cache.Set("past", "value")
+	assert.Nil(t, cache.SetWithExpiryTime("past", "value", time.Now().Add(-time.Second)))
+	_, exists = cache.Get("past")
+	assert.Equal(t, false, exists, "Expected an expiration time in the past to drop the item")
+	assert.Nil(t, cache.Verify())
+}
+
+func TestCacheSetWithRemainingTTL(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Hour)
+	assert.Nil(t, cache.SetWithRemainingTTL("key", "value", time.Minute))
+
+	ageItem(cache, "key", 30*time.Second)
+	_, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected the item to live for its remaining TTL")
+	ageItem(cache, "key", 30*time.Second)
+	_, exists = cache.Get("key")
+	assert.Equal(t, false, exists, "Expected the item to expire after its remaining TTL instead of the global TTL")
+	cache.RunCleanup()
+	assert.Equal(t, 0, cache.Count(), "Expected the item to be evicted")
+}
+
+func TestCacheNilValue(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("nil", nil)
+	data, exists := cache.Get("nil")
+	assert.Equal(t, true, exists, "Expected a nil value to be found")
+	assert.Nil(t, data)
+	data, exists = cache.Get("missing")
+	assert.Equal(t, false, exists, "Expected a missing item to not be found")
+	assert.Nil(t, data)
+
+	loader := func(string) (interface{}, error) {
+		t.Error("Expected the loader to not be called for a cached nil value")
+		return "value", nil
+	}
+	data, err := cache.GetOrSet("nil", loader)
+	assert.Nil(t, err)
+	assert.Nil(t, data, "Expected the cached nil value")
+	data, err = cache.GetOrDefault("nil", loader)
+	assert.Nil(t, err)
+	assert.Nil(t, data, "Expected the cached nil value")
+}
+
+func TestCacheGetOrDefaultValue(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	assert.Nil(t, cache.GetOrDefaultValue("missing"), "Expected nil without a default value")
+
+	cache.SetDefaultValue("default")
+	cache.Set("key", "value")
+	cache.Set("nil", nil)
+	assert.Equal(t, "value", cache.GetOrDefaultValue("key"), "Expected the stored value on a hit")
+	assert.Equal(t, "default", cache.GetOrDefaultValue("missing"), "Expected the default value on a miss")
+	assert.Nil(t, cache.GetOrDefaultValue("nil"), "Expected a stored nil value to be a hit")
+	assert.Equal(t, 2, cache.Count(), "Expected the default value to not be stored")
+}
+
+func TestCacheSetIf(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	newer := func(version int) func(interface{}, bool) bool {
+		return func(existing interface{}, exists bool) bool {
+			return !exists || existing.(int) < version
+		}
+	}
+	var stored []int
+	for _, version := range []int{3, 1, 4, 2, 6, 5} {
+		if cache.SetIf("key", version, newer(version)) {
+			stored = append(stored, version)
+		}
+	}
+
+	assert.Equal(t, []int{3, 4, 6}, stored, "Expected only newer versions to be stored")
+	data, _ := cache.Get("key")
+	assert.Equal(t, 6, data, "Expected the newest version")
+}
+
+func TestCacheSetCoalesceWindow(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var replaced int32
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		atomic.AddInt32(&replaced, 1)
+	})
+	cache.SetCoalesceWindow(20 * time.Millisecond)
+
+	sets := 0
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; sets++ {
+		cache.Set("key", sets)
+	}
+
+	assert.True(t, atomic.LoadInt32(&replaced) <= 3, "Expected far fewer replacements than sets")
+	data, _ := cache.Get("key")
+	assert.Equal(t, sets-1, data, "Expected the last value to win")
+}
+
+func TestCacheExpiryStatus(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetGracePeriod(time.Hour)
+	cache.SetWithTTL("expiring", "value", time.Minute)
+	cache.Set("live", "value")
+
+	assert.Equal(t, Live, cache.ExpiryStatus("expiring"), "Expected the item to be live before its TTL")
+	ageItem(cache, "expiring", time.Minute)
+	assert.Equal(t, ExpiredPendingSweep, cache.ExpiryStatus("expiring"), "Expected the unswept item to be pending")
+	assert.Equal(t, ExpiredPendingSweep, cache.ExpiryStatus("expiring"), "Expected the status to not evict the item")
+	assert.Equal(t, Live, cache.ExpiryStatus("live"), "Expected the item without TTL to be live")
+	assert.Equal(t, Absent, cache.ExpiryStatus("missing"), "Expected a missing item to be absent")
+	assert.Equal(t, "ExpiredPendingSweep", ExpiredPendingSweep.String())
+}
+
+func TestCacheDrainExpired(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var expired int32
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		atomic.AddInt32(&expired, 1)
+	})
+	// the grace period keeps the sweeper from expiring the items first
+	cache.SetGracePeriod(time.Hour)
+	for i, ttl := range []int{40, 10, 30, 20, 1000} {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), i, time.Duration(ttl)*time.Millisecond)
+	}
+	<-time.After(60 * time.Millisecond)
+
+	first := cache.DrainExpired(3)
+	assert.Equal(t, 3, len(first), "Expected a bounded batch")
+	assert.Equal(t, "key_1", first[0].Key, "Expected the oldest expired item first")
+	assert.Equal(t, "key_3", first[1].Key, "Expected the expired items in order")
+	assert.Equal(t, "key_2", first[2].Key, "Expected the expired items in order")
+	assert.Equal(t, 2, first[2].Value, "Expected the value of the item")
+
+	second := cache.DrainExpired(3)
+	assert.Equal(t, 1, len(second), "Expected only the remaining expired item")
+	assert.Equal(t, "key_0", second[0].Key, "Expected the remaining expired item")
+	assert.Equal(t, 0, len(cache.DrainExpired(3)), "Expected no more expired items")
+	assert.Equal(t, 1, cache.Count(), "Expected the live item to remain")
+
+	<-time.After(10 * time.Millisecond)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&expired), "Expected the expiration callback for every drained item")
+}
+
+func TestCacheManualSweep(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+	cache.SetLazyExpireOnGet(false)
+
+	var expired int32
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		atomic.AddInt32(&expired, 1)
+	})
+	goroutines := runtime.NumGoroutine()
+	cache.SetWithTTL("expiring", "value", 10*time.Millisecond)
+	cache.SetWithTTL("another", "value", 10*time.Millisecond)
+	cache.Set("permanent", "value")
+	assert.Equal(t, goroutines, runtime.NumGoroutine(), "Expected no sweeper")
+
+	<-time.After(30 * time.Millisecond)
+	_, exists := cache.Get("expiring")
+	assert.Equal(t, false, exists, "Expected the expired item to miss")
+	assert.Equal(t, 3, cache.RawCount(), "Expected the expired items to remain until the cleanup")
+
+	assert.Equal(t, 2, cache.RunCleanup(), "Expected the cleanup to expire the due items")
+	assert.Equal(t, 1, cache.RawCount(), "Expected the permanent item to remain")
+	assert.Equal(t, 0, cache.RunCleanup(), "Expected nothing left to expire")
+	<-time.After(10 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&expired), "Expected the expiration callbacks")
+}
+
+func TestCacheLazyExpireOnGet(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	expired := make(chan string, 1)
+	reasons := make(chan ExpirationReason, 1)
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired <- key
+	})
+	cache.SetExpirationReasonCallback(func(key string, value interface{}, reason ExpirationReason) {
+		reasons <- reason
+	})
+	cache.SetWithTTL("expiring", "value", time.Millisecond)
+	cache.Set("permanent", "value")
+	<-time.After(20 * time.Millisecond)
+	assert.Equal(t, 2, cache.RawCount(), "Expected the expired item to wait for a sweep")
+
+	_, exists := cache.Get("expiring")
+	assert.Equal(t, false, exists, "Expected the expired item to miss")
+	assert.Equal(t, 1, cache.RawCount(), "Expected the lookup to evict the expired item")
+	select {
+	case key := <-expired:
+		assert.Equal(t, "expiring", key)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the expiration callback")
+	}
+	assert.Equal(t, ExpiredOnAccess, <-reasons)
+	assert.Equal(t, 0, cache.RunCleanup(), "Expected nothing left for the sweep")
+
+	cache.SetLazyExpireOnGet(false)
+	cache.SetWithTTL("expiring", "value", time.Millisecond)
+	<-time.After(20 * time.Millisecond)
+	_, exists = cache.Get("expiring")
+	assert.Equal(t, false, exists)
+	assert.Equal(t, 2, cache.RawCount(), "Expected the expired item to be left to the sweeper")
+}
+
+func TestCacheHighWaterMarkCallback(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var sizes []int
+	cache.SetHighWaterMarkCallback(10, func(size int) {
+		sizes = append(sizes, size)
+	})
+
+	for i := 0; i < 15; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+	for i := 14; i >= 9; i-- {
+		cache.Remove(fmt.Sprintf("key_%d", i))
+	}
+	cache.Set("key_9", "value")
+	cache.Set("key_10", "value")
+	for i := 10; i >= 8; i-- {
+		cache.Remove(fmt.Sprintf("key_%d", i))
+	}
+	for i := 8; i < 11; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	assert.Equal(t, []int{11, 11}, sizes, "Expected a call per upward crossing after dropping below the low-water mark")
+}
+
+func TestCacheSetHandler(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		t.Error("Expected the handler to replace the remove callback")
+	})
+	added := make(chan string, 1)
+	expired := make(chan string, 1)
+	cache.SetHandler(Handler{
+		OnAdd: func(key string, value interface{}) {
+			added <- key
+		},
+		OnExpire: func(key string, value interface{}) {
+			expired <- key
+		},
+	})
+
+	cache.SetWithTTL("key", "value", 10*time.Millisecond)
+	assert.Equal(t, "key", <-added, "Expected OnAdd for the new item")
+	select {
+	case key := <-expired:
+		assert.Equal(t, "key", key, "Expected OnExpire for the expired item")
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnExpire to be called")
+	}
+}
+
+func TestCacheSetHandlerWhileSweeping(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), i, time.Millisecond)
+	}
+	// the race detector reports handlers that are set without the lock while the sweeper calls them
+	for i := 0; i < 100; i++ {
+		cache.SetHandler(Handler{
+			OnExpire:     func(key string, value interface{}) {},
+			ShouldExpire: func(key string, value interface{}) bool { return true },
+		})
+		cache.SkipTtlExtensionOnHit(i%2 == 0)
+		<-time.After(100 * time.Microsecond)
+	}
+}
+
+func TestCacheReset(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(20 * time.Millisecond)
+	cache.EnablePerKeyStats(true)
+	cache.SetKeyValidator(func(key string) error {
+		if key == "invalid" {
+			return errors.New("invalid key")
+		}
+		return nil
+	})
+	cache.Set("key", "value")
+	cache.Set("invalid", "value")
+	cache.GetOrSet("loaded", func(string) (interface{}, error) { return "value", nil })
+	cache.Get("missing")
+
+	cache.Reset()
+	assert.Equal(t, 0, cache.Count(), "Expected no items after the reset")
+	assert.Equal(t, Metrics{}, cache.GetMetrics(), "Expected zeroed metrics after the reset")
+	_, _, ok := cache.KeyStats("missing")
+	assert.Equal(t, false, ok, "Expected the missed keys to be cleared")
+
+	assert.NotNil(t, cache.Set("invalid", "value"), "Expected the key validator to be kept")
+	cache.Set("key", "value")
+	<-time.After(40 * time.Millisecond)
+	_, exists := cache.Get("key")
+	assert.Equal(t, false, exists, "Expected the TTL to be kept")
+}
+
+func TestCacheStrictMode(t *testing.T) {
+	lenient := NewCache()
+	lenient.Close()
+	assert.NotPanics(t, func() {
+		assert.Equal(t, ErrClosed, lenient.Set("key", "value"), "Expected a Set after Close to fail")
+	})
+	assert.Equal(t, 0, lenient.Count(), "Expected a Set after Close to not store the item")
+
+	strict := NewCache()
+	strict.SetStrictMode(true)
+	assert.Panics(t, func() {
+		strict.SetWithTTL("key", "value", -time.Second)
+	}, "Expected an ambiguous negative TTL to panic")
+	assert.NotPanics(t, func() {
+		strict.SetWithTTL("key", "value", ItemNotExpire)
+	})
+	strict.Close()
+	assert.Panics(t, func() {
+		strict.Set("key", "value")
+	}, "Expected a Set after Close to panic")
+}
+
+func TestCacheSkipTtlExtensionOnLoaderHit(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(time.Minute)
+	cache.SkipTtlExtensionOnLoaderHit(true)
+	var loads int32
+	loader := func(string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+
+	cache.GetOrDefault("loaded", loader)
+	cache.Set("read", "value")
+	for i := 0; i < 3; i++ {
+		ageItem(cache, "loaded", 25*time.Second)
+		ageItem(cache, "read", 25*time.Second)
+		cache.GetOrDefault("loaded", loader)
+		cache.Get("read")
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads), "Expected the item to be reloaded on its fixed schedule")
+	_, exists := cache.Get("read")
+	assert.Equal(t, true, exists, "Expected Get to keep extending the TTL")
+}
+
+func TestCacheExpirationCallbacksFollowInsertionOrder(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	expired := make(chan string, 30)
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired <- key
+	})
+	expireAt := time.Now().Add(20 * time.Millisecond)
+	var expected []string
+	for i := 0; i < 20; i++ {
+		cache.SetWithExpiryTime(fmt.Sprintf("key_%d", i), "value", expireAt)
+		expected = append(expected, fmt.Sprintf("key_%d", i))
+	}
+
+	var order []string
+	for len(order) < len(expected) {
+		select {
+		case key := <-expired:
+			order = append(order, key)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected all items to expire, got %v", order)
+		}
+	}
+	assert.Equal(t, expected, order, "Expected callbacks for items expiring at the same time in insertion order")
+}
+
+func TestCacheCountMatchesKeysBeforeSweep(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.SetWithTTL(fmt.Sprintf("short_%d", i), "value", time.Millisecond)
+		cache.SetWithTTL(fmt.Sprintf("long_%d", i), "value", time.Minute)
+	}
+	cache.Set("permanent", "value")
+	<-time.After(5 * time.Millisecond)
+
+	assert.Equal(t, len(cache.Keys()), cache.Count(), "Expected Count to match the keys")
+	assert.Equal(t, 11, cache.Count(), "Expected expired items to not be counted")
+	assert.Equal(t, 21, cache.RawCount(), "Expected the raw count to include expired items")
+
+	cache.RunCleanup()
+	assert.Equal(t, 11, cache.RawCount())
+}
+
+func TestCacheSetSweepBatchSizeInterleavesLookups(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetSweepBatchSize(10)
+	expireAt := time.Now().Add(20 * time.Millisecond)
+	for i := 0; i < 2000; i++ {
+		cache.SetWithExpiryTime(fmt.Sprintf("key_%d", i), "value", expireAt)
+	}
+
+	// lookups during the sweep see the cache partially swept
+	partial := false
+	start := time.Now()
+	for cache.RawCount() > 0 && time.Since(start) < 5*time.Second {
+		lookup := time.Now()
+		cache.Get("key_0")
+		assert.True(t, time.Since(lookup) < time.Second, "Expected the lookup to not be starved")
+		if count := cache.RawCount(); count > 0 && count < 2000 {
+			partial = true
+		}
+		<-time.After(100 * time.Microsecond)
+	}
+	assert.True(t, partial, "Expected lookups to interleave with the sweep")
+	assert.Equal(t, 0, cache.RawCount(), "Expected all expired items to be removed")
+	assert.Nil(t, cache.Verify())
+}
+
+func TestCacheSetTTLResolution(t *testing.T) {
+	cases := []struct {
+		resolution TTLResolution
+		shorter    time.Duration
+		longer     time.Duration
+		permanent  time.Duration
+	}{
+		{TTLResolutionItem, time.Minute, time.Hour, 0},
+		{TTLResolutionMin, time.Minute, 10 * time.Minute, 10 * time.Minute},
+		{TTLResolutionGlobal, 10 * time.Minute, 10 * time.Minute, 10 * time.Minute},
+	}
+	for _, c := range cases {
+		cache := NewCache()
+		cache.SetTTL(10 * time.Minute)
+		cache.SetTTLResolution(c.resolution)
+		start := time.Now()
+		cache.SetWithTTL("shorter", "value", time.Minute)
+		cache.SetWithTTL("longer", "value", time.Hour)
+		cache.SetWithTTL("permanent", "value", ItemNotExpire)
+
+		for key, expected := range map[string]time.Duration{"shorter": c.shorter, "longer": c.longer, "permanent": c.permanent} {
+			info, _ := cache.GetItemInfo(key)
+			if expected == 0 {
+				assert.True(t, info.ExpiresAt.IsZero(), "Expected %s to not expire under resolution %d", key, c.resolution)
+				continue
+			}
+			ttl := info.ExpiresAt.Sub(start)
+			assert.True(t, ttl >= expected && ttl < expected+time.Second, "Expected %s to expire after %v under resolution %d, got %v", key, expected, c.resolution, ttl)
+		}
+		cache.Close()
+	}
+}
+
+func TestCacheGetConsistentNeverTears(t *testing.T) {
+	cache := NewCache()
+
+	cache.Set("first", 0)
+	cache.Set("second", 0)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for version := 1; ; version++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// the pair is updated in a single lock hold, like a writer holding the keys of one object
+			cache.ReplaceAll(map[string]interface{}{"first": version, "second": version}, ItemExpireWithGlobalTTL)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		values, err := cache.GetConsistent([]string{"first", "second"})
+		assert.Nil(t, err)
+		if !assert.Equal(t, values["first"], values["second"], "Expected a consistent pair") {
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	cache.Close()
+	_, err := cache.GetConsistent([]string{"first"})
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestCacheRemoveMatchingWithinTimeWindow(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var removed []string
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		removed = append(removed, key)
+	})
+	cache.Set("before_bad", "bad")
+	cache.Set("before_good", "good")
+	<-time.After(5 * time.Millisecond)
+	windowStart := time.Now()
+	cache.Set("during_bad", "bad")
+	cache.Set("during_good", "good")
+
+	count := cache.RemoveMatching(func(key string, value interface{}, createdAt time.Time) bool {
+		return value == "bad" && !createdAt.Before(windowStart)
+	})
+	assert.Equal(t, 1, count, "Expected only the matching item of the window to be removed")
+	assert.Equal(t, []string{"during_bad"}, removed, "Expected the remove callback for the removed item")
+	keys := cache.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"before_bad", "before_good", "during_good"}, keys)
+}
+
+func TestCacheExpireUsesExpirationPath(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+	cache.SetLazyExpireOnGet(false)
+
+	expired := make(chan string, 2)
+	var removed int32
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		expired <- key
+	})
+	cache.SetRemoveCallback(func(key string, value interface{}) {
+		atomic.AddInt32(&removed, 1)
+	})
+	cache.Set("permanent", "value")
+	cache.SetWithTTL("expiring", "value", time.Hour)
+
+	assert.Equal(t, true, cache.Expire("permanent"))
+	assert.Equal(t, true, cache.Expire("expiring"))
+	assert.Equal(t, false, cache.Expire("missing"))
+	<-time.After(time.Millisecond)
+	_, exists := cache.Get("permanent")
+	assert.Equal(t, false, exists, "Expected the expired item to miss")
+
+	assert.Equal(t, 2, cache.RunCleanup(), "Expected the next sweep to expire the items")
+	for i := 0; i < 2; i++ {
+		select {
+		case key := <-expired:
+			assert.Contains(t, []string{"permanent", "expiring"}, key)
+		case <-time.After(time.Second):
+			t.Fatal("Expected the expiration callback")
+		}
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&removed))
+	assert.Equal(t, 0, cache.RawCount())
+}
+
+func TestCacheCompareAndSwapWithValueComparator(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	type record struct {
+		ID      int
+		Payload []string
+	}
+	cache.Set("key", record{ID: 1, Payload: []string{"old"}})
+	assert.Equal(t, false, cache.CompareAndSwap("key", record{ID: 1}, record{ID: 2}), "Expected DeepEqual to compare the whole value")
+	assert.Equal(t, true, cache.CompareAndSwap("key", record{ID: 1, Payload: []string{"old"}}, record{ID: 2}))
+
+	cache.SetValueComparator(func(a, b interface{}) bool {
+		return a.(record).ID == b.(record).ID
+	})
+	assert.Equal(t, true, cache.CompareAndSwap("key", record{ID: 2, Payload: []string{"other"}}, record{ID: 3}), "Expected only the IDs to be compared")
+	assert.Equal(t, false, cache.CompareAndSwap("key", record{ID: 2}, record{ID: 4}), "Expected a different ID to not match")
+	assert.Equal(t, false, cache.CompareAndSwap("missing", record{}, record{ID: 5}), "Expected a missing item to not match")
+	value, _ := cache.Get("key")
+	assert.Equal(t, 3, value.(record).ID)
+}
+
+func TestCacheNextExpiration(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	_, scheduled := cache.NextExpiration()
+	assert.Equal(t, false, scheduled, "Expected no expiration for an empty cache")
+	cache.Set("permanent", "value")
+	_, scheduled = cache.NextExpiration()
+	assert.Equal(t, false, scheduled, "Expected no expiration for permanent items")
+
+	soon := time.Now().Add(time.Minute)
+	cache.SetWithExpiryTime("later", "value", soon.Add(time.Hour))
+	cache.SetWithExpiryTime("soon", "value", soon)
+	cache.SetWithTTL("hour", "value", time.Hour)
+	next, scheduled := cache.NextExpiration()
+	assert.Equal(t, true, scheduled)
+	assert.Equal(t, soon, next, "Expected the expiration of the soonest expiring item")
+}
+
+func TestCacheContainsMany(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	cache.Set("present", "value")
+	cache.SetWithTTL("expired", "value", time.Millisecond)
+	cache.SetWithTTL("live", "value", time.Minute)
+	<-time.After(5 * time.Millisecond)
+
+	present := cache.ContainsMany([]string{"present", "expired", "absent", "live"})
+	assert.Equal(t, []bool{true, false, false, true}, present)
+	info, _ := cache.GetItemInfo("live")
+	assert.Equal(t, int64(0), info.AccessCount, "Expected the items to not be touched")
+}
+
+func TestCacheTryGetDoesNotBlock(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("key", "value")
+	value, found, ok := cache.TryGet("key")
+	assert.Equal(t, true, ok, "Expected the uncontended lock to be acquired")
+	assert.Equal(t, true, found)
+	assert.Equal(t, "value", value)
+
+	for _, hold := range []struct{ lock, unlock func() }{
+		{cache.mutex.Lock, cache.mutex.Unlock},
+		{cache.mutex.RLock, cache.mutex.RUnlock},
+	} {
+		held := make(chan struct{})
+		release := make(chan struct{})
+		done := make(chan struct{})
+		go func(lock, unlock func()) {
+			defer close(done)
+			lock()
+			close(held)
+			<-release
+			unlock()
+		}(hold.lock, hold.unlock)
+		<-held
+
+		start := time.Now()
+		_, _, ok = cache.TryGet("key")
+		assert.Equal(t, false, ok, "Expected the held lock to not be acquired")
+		assert.True(t, time.Since(start) < 10*time.Millisecond, "Expected TryGet to return promptly")
+		close(release)
+		<-done
+	}
+
+	_, found, ok = cache.TryGet("missing")
+	assert.Equal(t, true, ok, "Expected the released lock to be acquired")
+	assert.Equal(t, false, found)
+}
+
+func TestCacheGetExReportsExtension(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("key", "value", time.Minute)
+	cache.SetWithTTL("permanent", "value", ItemNotExpire)
+	cache.SetWithExpiryTime("fixed", "value", time.Now().Add(time.Minute))
+
+	value, found, extended := cache.GetEx("key")
+	assert.Equal(t, "value", value)
+	assert.Equal(t, true, found)
+	assert.Equal(t, true, extended, "Expected the lookup to extend the TTL")
+
+	_, _, extended = cache.GetEx("permanent")
+	assert.Equal(t, false, extended, "Expected items that do not expire not to be extended")
+	_, _, extended = cache.GetEx("fixed")
+	assert.Equal(t, false, extended, "Expected items with a fixed expiration not to be extended")
+
+	cache.SkipTtlExtensionOnHit(true)
+	value, found, extended = cache.GetEx("key")
+	assert.Equal(t, "value", value)
+	assert.Equal(t, true, found)
+	assert.Equal(t, false, extended, "Expected the lookup not to extend the TTL while extension is skipped")
+
+	cache.SkipTtlExtensionOnHit(false)
+	_, _, extended = cache.GetEx("key")
+	assert.Equal(t, true, extended)
+
+	_, found, extended = cache.GetEx("missing")
+	assert.Equal(t, false, found)
+	assert.Equal(t, false, extended)
+}
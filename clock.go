@@ -0,0 +1,62 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// coarseClock caches the current time, refreshed by a background ticker, to avoid calling time.Now on every lookup.
+type coarseClock struct {
+	now        atomic.Value
+	resolution time.Duration
+	stop       chan struct{}
+}
+
+func newCoarseClock(resolution time.Duration) *coarseClock {
+	clock := &coarseClock{resolution: resolution, stop: make(chan struct{})}
+	clock.now.Store(time.Now())
+	return clock
+}
+
+func (clock *coarseClock) load() time.Time {
+	return clock.now.Load().(time.Time)
+}
+
+func (clock *coarseClock) run(done func()) {
+	defer done()
+	ticker := time.NewTicker(clock.resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-clock.stop:
+			return
+		case now := <-ticker.C:
+			clock.now.Store(now)
+		}
+	}
+}
+
+// SetCoarseClock makes lookups use a cached current time that is refreshed at the given resolution by a
+// background ticker, instead of calling time.Now every time. This trades expiration precision for throughput:
+// lookups may see an item up to the resolution after it expired. A value of 0 disables the coarse clock.
+func (cache *Cache) SetCoarseClock(resolution time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.clock != nil {
+		close(cache.clock.stop)
+		cache.clock = nil
+	}
+	if resolution > 0 && !cache.isShutDown {
+		cache.clock = newCoarseClock(resolution)
+		cache.backgroundWorkers.Add(1)
+		go cache.clock.run(cache.backgroundWorkers.Done)
+	}
+}
+
+// now returns the current time, from the coarse clock when one is configured. Must be called with the lock held.
+func (cache *Cache) now() time.Time {
+	if cache.clock != nil {
+		return cache.clock.load()
+	}
+	return time.Now()
+}
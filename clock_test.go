@@ -0,0 +1,47 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSetCoarseClock(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetCoarseClock(10 * time.Millisecond)
+	cache.SkipTtlExtensionOnHit(true)
+	cache.SetWithTTL("key", "value", time.Minute)
+
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected item to exist")
+	assert.Equal(t, "value", data, "Expected item to have 'value' in value")
+
+	ageItem(cache, "key", time.Minute)
+	<-time.After(30 * time.Millisecond)
+	_, exists = cache.Get("key")
+	assert.Equal(t, false, exists, "Expected item to expire within the resolution of the clock")
+}
+
+func TestCacheCoarseClockDecidesExpiration(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	// the clock does not tick during the test, so the item stays live for the cache however much time passes
+	cache.SetCoarseClock(time.Hour)
+	cache.SkipTtlExtensionOnHit(true)
+	cache.SetWithTTL("key", "value", 10*time.Millisecond)
+	<-time.After(30 * time.Millisecond)
+
+	assert.Equal(t, Live, cache.ExpiryStatus("key"))
+	assert.Equal(t, true, cache.Update("key", func(current interface{}, exists bool) (interface{}, bool) {
+		assert.Equal(t, true, exists, "Expected Update to see the item as live")
+		return current, true
+	}, false))
+	_, fresh, found := cache.GetWithStale("key")
+	assert.Equal(t, true, fresh && found, "Expected GetWithStale to see the item as fresh")
+	records := cache.DrainAll()
+	assert.Equal(t, 1, len(records), "Expected the item to be drained as live")
+}
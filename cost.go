@@ -0,0 +1,62 @@
+package ttlcache
+
+// SetCostFunc sets the function that tells the cost of values, such as their weight in a budget, see TotalCost.
+// It is called while the cache is locked and must not use the cache. Setting it counts the cost of the items
+// already stored. A nil function counts no costs.
+func (cache *Cache) SetCostFunc(costFunc func(value interface{}) int64) {
+	cache.mutex.Lock()
+	cache.costFunc = costFunc
+	cache.accountAll()
+	cache.mutex.Unlock()
+}
+
+// TotalCost returns the sum of the costs of the items in the cache, as told by the cost function, see SetCostFunc.
+// The sum is kept up to date as items are stored and removed, so reading it is cheap. Expired items count until
+// they are removed.
+func (cache *Cache) TotalCost() int64 {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.totalCost
+}
+
+// EstimatedBytes returns the sum of the sizes of the items in the cache, as measured by the size function,
+// see SetSizeFunc, or 0 without a size function. It is kept up to date like TotalCost.
+func (cache *Cache) EstimatedBytes() int64 {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.totalSize
+}
+
+// account counts the cost and size of the current value of the item in the totals, replacing those of its
+// previous value. Must be called with the lock held.
+func (cache *Cache) account(item *item) {
+	cache.unaccount(item)
+	if cache.costFunc == nil && cache.sizeFunc == nil {
+		return
+	}
+	cache.mutex.guard(func() {
+		if cache.costFunc != nil {
+			item.cost = cache.costFunc(item.data)
+		}
+		if cache.sizeFunc != nil {
+			item.size = cache.sizeFunc(item.data)
+		}
+	})
+	cache.totalCost += item.cost
+	cache.totalSize += item.size
+}
+
+// unaccount removes the cost and size of the item from the totals. Must be called with the lock held.
+func (cache *Cache) unaccount(item *item) {
+	cache.totalCost -= item.cost
+	cache.totalSize -= item.size
+	item.cost, item.size = 0, 0
+}
+
+// accountAll counts all items again, after the cost or size function changed. Must be called with the lock held.
+func (cache *Cache) accountAll() {
+	cache.items.each(func(item *item) bool {
+		cache.account(item)
+		return true
+	})
+}
@@ -0,0 +1,42 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheTotalCost(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("before", "abc")
+	cache.SetCostFunc(func(value interface{}) int64 { return int64(len(value.(string))) })
+	assert.Equal(t, int64(3), cache.TotalCost(), "Expected the stored items to be counted")
+
+	cache.Set("key", "abcde")
+	assert.Equal(t, int64(8), cache.TotalCost(), "Expected the insertion to be counted")
+	cache.Set("key", "a")
+	assert.Equal(t, int64(4), cache.TotalCost(), "Expected the replacement to be counted")
+	cache.Remove("before")
+	assert.Equal(t, int64(1), cache.TotalCost(), "Expected the removal to be counted")
+
+	cache.SetWithTTL("expiring", "abcdefgh", 10*time.Millisecond)
+	assert.Equal(t, int64(9), cache.TotalCost())
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, int64(1), cache.TotalCost(), "Expected the expiration to be counted")
+
+	cache.SetMaxItems(2)
+	cache.Set("second", "ab")
+	cache.Set("third", "abc")
+	assert.Equal(t, 2, cache.Count())
+	assert.Equal(t, int64(5), cache.TotalCost(), "Expected the eviction to be counted")
+	assert.Equal(t, int64(0), cache.EstimatedBytes(), "Expected no size without a size function")
+
+	cache.SetSizeFunc(func(value interface{}) int64 { return 2 * int64(len(value.(string))) })
+	assert.Equal(t, int64(10), cache.EstimatedBytes(), "Expected the sizes of the stored items")
+	cache.Purge()
+	assert.Equal(t, int64(0), cache.TotalCost())
+	assert.Equal(t, int64(0), cache.EstimatedBytes())
+}
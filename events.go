@@ -0,0 +1,140 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+)
+
+// EventType describes what happened to an item in the cache.
+type EventType int
+
+const (
+	// EventAdded is published when a new item is stored.
+	EventAdded EventType = iota
+	// EventAccessed is published when an item is looked up.
+	EventAccessed
+	// EventExpired is published when an item expired.
+	EventExpired
+	// EventRemoved is published when an item is removed or its value is replaced, it carries the old value.
+	EventRemoved
+	// EventEvicted is published when an item is evicted to free memory.
+	EventEvicted
+	// EventExpiredBatch is published instead of EventExpired when expired events are batched, it carries the
+	// items that expired together in its Batch, see SetBatchExpiredEvents.
+	EventExpiredBatch
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case EventAdded:
+		return "Added"
+	case EventAccessed:
+		return "Accessed"
+	case EventExpired:
+		return "Expired"
+	case EventRemoved:
+		return "Removed"
+	case EventEvicted:
+		return "Evicted"
+	case EventExpiredBatch:
+		return "ExpiredBatch"
+	}
+	return "Unknown"
+}
+
+// Event is delivered to subscribers of the cache for every change in the lifecycle of an item.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value interface{}
+	// Batch holds the expired items of an EventExpiredBatch, the subscriber may keep it.
+	Batch []ExpiredItem
+}
+
+type subscription struct {
+	events chan Event
+}
+
+// Subscribe returns a channel that receives all lifecycle events of the cache, and a function to unsubscribe.
+// Events are sent without blocking the cache: when the buffer of the channel is full the event is dropped
+// and counted, see DroppedEvents. The channel is closed when unsubscribing or when the cache is closed.
+func (cache *Cache) Subscribe(buffer int) (<-chan Event, func()) {
+	sub := &subscription{events: make(chan Event, buffer)}
+
+	cache.mutex.Lock()
+	if cache.isShutDown {
+		cache.mutex.Unlock()
+		close(sub.events)
+		return sub.events, func() {}
+	}
+	cache.subscriptions[sub] = struct{}{}
+	cache.mutex.Unlock()
+
+	return sub.events, func() {
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		if _, subscribed := cache.subscriptions[sub]; subscribed {
+			delete(cache.subscriptions, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// SetBatchExpiredEvents makes the cache publish the items that expire together, such as the items of one sweep,
+// as a single EventExpiredBatch rather than an EventExpired each, so a mass expiration does not flood the
+// channels of the subscribers. Items expiring on their own, for example on a lookup, are published as a batch
+// of one.
+func (cache *Cache) SetBatchExpiredEvents(value bool) {
+	cache.mutex.Lock()
+	cache.batchExpiredEvents = value
+	cache.mutex.Unlock()
+}
+
+// DroppedEvents returns how many events were dropped because the channel of a subscriber was full.
+func (cache *Cache) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&cache.droppedEvents)
+}
+
+// publish sends the event to all subscribers without blocking. Must be called with the lock held.
+func (cache *Cache) publish(eventType EventType, key string, value interface{}) {
+	for sub := range cache.subscriptions {
+		select {
+		case sub.events <- Event{Type: eventType, Key: key, Value: value}:
+		default:
+			atomic.AddUint64(&cache.droppedEvents, 1)
+		}
+	}
+}
+
+// publishExpired publishes the expired items to all subscribers, as a single batch when expired events are batched.
+// Must be called with the lock held.
+func (cache *Cache) publishExpired(items []*item) {
+	if len(items) == 0 || len(cache.subscriptions) == 0 {
+		return
+	}
+	if !cache.batchExpiredEvents {
+		for _, item := range items {
+			cache.publish(EventExpired, item.key, item.data)
+		}
+		return
+	}
+	for sub := range cache.subscriptions {
+		// every subscriber gets its own batch, so it may keep it
+		batch := make([]ExpiredItem, len(items))
+		for i, item := range items {
+			batch[i] = ExpiredItem{Key: item.key, Value: item.data, ExpiredAt: item.dueAt()}
+		}
+		select {
+		case sub.events <- Event{Type: EventExpiredBatch, Batch: batch}:
+		default:
+			atomic.AddUint64(&cache.droppedEvents, 1)
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of all subscribers. Must be called with the lock held.
+func (cache *Cache) closeSubscriptions() {
+	for sub := range cache.subscriptions {
+		delete(cache.subscriptions, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,88 @@
+package ttlcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectEvents(events <-chan Event) []Event {
+	var collected []Event
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestCacheSubscribe(t *testing.T) {
+	cache := NewCache()
+
+	first, _ := cache.Subscribe(10)
+	second, _ := cache.Subscribe(10)
+
+	cache.Set("key", "value")
+	cache.Get("key")
+	cache.Set("key", "value2")
+	cache.Remove("key")
+	cache.SetWithTTL("expiring", "value", 10*time.Millisecond)
+	<-time.After(50 * time.Millisecond)
+	cache.Close()
+
+	expected := []Event{
+		{Type: EventAdded, Key: "key", Value: "value"},
+		{Type: EventAccessed, Key: "key", Value: "value"},
+		{Type: EventRemoved, Key: "key", Value: "value"},
+		{Type: EventRemoved, Key: "key", Value: "value2"},
+		{Type: EventAdded, Key: "expiring", Value: "value"},
+		{Type: EventExpired, Key: "expiring", Value: "value"},
+	}
+	assert.Equal(t, expected, collectEvents(first), "Expected the first subscriber to observe all events")
+	assert.Equal(t, expected, collectEvents(second), "Expected the second subscriber to observe all events")
+}
+
+func TestCacheSubscribeDropsOnFullBuffer(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	events, unsubscribe := cache.Subscribe(1)
+	cache.Set("key1", "value")
+	cache.Set("key2", "value")
+	cache.Set("key3", "value")
+	unsubscribe()
+	unsubscribe()
+
+	assert.Equal(t, []Event{{Type: EventAdded, Key: "key1", Value: "value"}}, collectEvents(events), "Expected only the buffered event")
+	assert.Equal(t, uint64(2), cache.DroppedEvents(), "Expected the overflowing events to be counted")
+}
+
+func TestCacheSetBatchExpiredEvents(t *testing.T) {
+	cache := NewCacheManualSweep()
+
+	cache.SetBatchExpiredEvents(true)
+	events, _ := cache.Subscribe(200)
+	for i := 0; i < 100; i++ {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), i, time.Millisecond)
+	}
+	<-time.After(10 * time.Millisecond)
+	assert.Equal(t, 100, cache.RunCleanup())
+	cache.Close()
+
+	var expired []Event
+	for _, event := range collectEvents(events) {
+		if event.Type != EventAdded {
+			expired = append(expired, event)
+		}
+	}
+	if assert.Equal(t, 1, len(expired), "Expected a single event for the sweep") {
+		assert.Equal(t, EventExpiredBatch, expired[0].Type)
+		assert.Equal(t, 100, len(expired[0].Batch), "Expected all expired items in the batch")
+		keys := make(map[string]bool)
+		for _, item := range expired[0].Batch {
+			keys[item.Key] = true
+			assert.Equal(t, fmt.Sprintf("key_%d", item.Value), item.Key)
+		}
+		assert.Equal(t, 100, len(keys), "Expected every item once")
+	}
+}
@@ -0,0 +1,261 @@
+package ttlcache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy decides which items are evicted first when the cache exceeds its size or memory limit.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the items that were not used for the longest time, it is the default.
+	LRU EvictionPolicy = iota
+	// LFU evicts the items that were used least often, see SetLFUDecay to forget old uses.
+	LFU
+	// SampledLRU approximates LRU: it evicts the least recently used of a few items sampled at random, see
+	// SetEvictionSampleSize. Unlike LRU lookups do not reorder the items, which makes them cheaper, at the
+	// price of sometimes evicting an item that was used more recently than others.
+	SampledLRU
+)
+
+// FullPolicy decides what happens to a new item stored in a cache that holds the maximum number of items.
+type FullPolicy int
+
+const (
+	// EvictLRU evicts other items to make room in the order of the eviction policy, least recently used first
+	// by default. It is the default.
+	EvictLRU FullPolicy = iota
+	// Reject keeps the items in the cache and does not store the new item.
+	Reject
+)
+
+// defaultEvictionSampleSize is the number of items SampledLRU samples by default.
+const defaultEvictionSampleSize = 5
+
+// SetEvictionPolicy sets the order in which items are evicted. Finding the items to evict under LFU
+// takes time linear in the number of items, under LRU it takes constant time per evicted item, and under
+// SampledLRU time linear in the sample size.
+func (cache *Cache) SetEvictionPolicy(policy EvictionPolicy) {
+	cache.mutex.Lock()
+	cache.evictionPolicy = policy
+	cache.mutex.Unlock()
+}
+
+// SetEvictionSampleSize sets the number of items SampledLRU samples for every eviction, 5 by default. Larger
+// samples approximate LRU more closely, and take longer. Among the sampled items the ones of the lowest priority
+// are evicted first.
+func (cache *Cache) SetEvictionSampleSize(size int) {
+	cache.mutex.Lock()
+	if size < 1 {
+		size = 1
+	}
+	cache.evictionSampleSize = size
+	cache.mutex.Unlock()
+}
+
+// SetFullPolicy sets what happens to new items stored in a cache that holds the maximum number of items, see
+// SetMaxItems. Under Reject the Set functions return ErrCacheFull for them, the other functions inserting items
+// skip them, and all rejections are counted in the metrics. Existing items can still be replaced.
+func (cache *Cache) SetFullPolicy(policy FullPolicy) {
+	cache.mutex.Lock()
+	cache.fullPolicy = policy
+	cache.mutex.Unlock()
+}
+
+// rejectInsert returns the error a new item of the key is rejected with, because of memory pressure or because
+// the cache is full, or nil when it can be inserted. Must be called with the lock held.
+func (cache *Cache) rejectInsert(key string) error {
+	if cache.underPressure() {
+		return ErrMemoryPressure
+	}
+	if cache.fullPolicy == Reject && cache.maxItems > 0 && cache.items.Len() >= cache.maxItems {
+		// an expired item of the key that was not removed yet makes room for the new one
+		if _, stale := cache.items.get(key); !stale {
+			atomic.AddUint64(&cache.fullRejections, 1)
+			return ErrCacheFull
+		}
+	}
+	return nil
+}
+
+// SetLFUDecay makes the use counts of the LFU policy decay exponentially, halving every halfLife, so items that
+// were popular long ago become evictable. The decay is applied when counts are compared. A value of 0 disables it.
+func (cache *Cache) SetLFUDecay(halfLife time.Duration) {
+	cache.mutex.Lock()
+	cache.lfuHalfLife = halfLife
+	cache.mutex.Unlock()
+}
+
+// SetMaxItems limits the number of items in the cache. Adding an item beyond the limit evicts other items
+// in the order of the eviction policy, lowering the limit evicts right away. A value of 0 means unlimited.
+// Evicted items are passed to the remove and eviction callbacks and published as EventEvicted.
+func (cache *Cache) SetMaxItems(max int) {
+	cache.mutex.Lock()
+	cache.maxItems = max
+	var evicted []*item
+	if max > 0 {
+		evicted = cache.evict(cache.items.Len() - max)
+	}
+	cache.mutex.Unlock()
+	cache.notifyEvicted(evicted)
+}
+
+// TrimToSize evicts items in the order of the eviction policy until the cache holds at most targetCount items,
+// and returns how many were evicted. Evicted items are passed to the remove and eviction callbacks and
+// published as EventEvicted.
+func (cache *Cache) TrimToSize(targetCount int) int {
+	cache.mutex.Lock()
+	evicted := cache.evict(cache.items.Len() - targetCount)
+	cache.checkSize()
+	cache.mutex.Unlock()
+	cache.notifyEvicted(evicted)
+	return len(evicted)
+}
+
+// Pin excludes the item from eviction by the size and memory limits, until it is unpinned. Pinned items still
+// count towards the limits, so a cache holding only pinned items can exceed them, and they still expire.
+// Replacing the value keeps the item pinned, an item stored after it was removed or expired is not pinned.
+// It returns false when the item is not in the cache.
+func (cache *Cache) Pin(key string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		return false
+	}
+	if !item.pinned {
+		cache.usageOrder.remove(item)
+		item.pinned = true
+	}
+	return true
+}
+
+// Unpin makes a pinned item evictable again, as the most recently used item of its priority.
+// It returns false when the item is not in the cache.
+func (cache *Cache) Unpin(key string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) {
+		return false
+	}
+	if item.pinned {
+		item.pinned = false
+		cache.usageOrder.pushFront(item)
+	}
+	return true
+}
+
+// evict removes up to count items in the order of the eviction policy and returns them.
+func (cache *Cache) evict(count int) []*item {
+	switch cache.evictionPolicy {
+	case LFU:
+		return cache.evictLeastFrequentlyUsed(count)
+	case SampledLRU:
+		return cache.evictSampled(count)
+	}
+	return cache.evictLeastRecentlyUsed(count)
+}
+
+// evictSampled removes up to count items, each the least recently used of the lowest priority among a random
+// sample of the items, and returns them. Pinned items are skipped, when a sample holds only pinned items
+// the least recently used item of the usage order is evicted instead.
+func (cache *Cache) evictSampled(count int) []*item {
+	if count <= 0 {
+		return nil
+	}
+	if count > cache.usageOrder.Len() {
+		count = cache.usageOrder.Len()
+	}
+	evicted := make([]*item, 0, count)
+	for len(evicted) < count {
+		var victim *item
+		items := cache.priorityQueue.items
+		for i := 0; i < cache.evictionSampleSize; i++ {
+			candidate := items[cache.random.int63n(int64(len(items)))]
+			if candidate.pinned {
+				continue
+			}
+			if victim == nil || candidate.priority < victim.priority ||
+				(candidate.priority == victim.priority && candidate.lastAccessAt.Before(victim.lastAccessAt)) {
+				victim = candidate
+			}
+		}
+		if victim == nil {
+			victim = cache.usageOrder.leastRecentlyUsed()
+		}
+		cache.deleteItem(victim)
+		cache.publish(EventEvicted, victim.key, victim.data)
+		atomic.AddUint64(&cache.evictions, 1)
+		evicted = append(evicted, victim)
+	}
+	return evicted
+}
+
+// evictLeastRecentlyUsed removes up to count items of the lowest priority that were not used for the longest
+// time and returns them.
+func (cache *Cache) evictLeastRecentlyUsed(count int) []*item {
+	if count <= 0 {
+		return nil
+	}
+	if count > cache.usageOrder.Len() {
+		count = cache.usageOrder.Len()
+	}
+	evicted := make([]*item, 0, count)
+	for len(evicted) < count {
+		item := cache.usageOrder.leastRecentlyUsed()
+		cache.deleteItem(item)
+		cache.publish(EventEvicted, item.key, item.data)
+		atomic.AddUint64(&cache.evictions, 1)
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
+
+// evictLeastFrequentlyUsed removes up to count items of the lowest priority with the lowest decayed use count
+// and returns them. Items with the same count are evicted in least recently used order.
+func (cache *Cache) evictLeastFrequentlyUsed(count int) []*item {
+	if count <= 0 {
+		return nil
+	}
+	type candidate struct {
+		item      *item
+		frequency float64
+	}
+	now := time.Now()
+	candidates := make([]candidate, 0, cache.usageOrder.Len())
+	cache.usageOrder.each(func(item *item) {
+		candidates = append(candidates, candidate{item, item.decayedFrequency(now, cache.lfuHalfLife)})
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].item.priority != candidates[j].item.priority {
+			return candidates[i].item.priority < candidates[j].item.priority
+		}
+		return candidates[i].frequency < candidates[j].frequency
+	})
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+	evicted := make([]*item, count)
+	for i, candidate := range candidates[:count] {
+		evicted[i] = candidate.item
+		cache.deleteItem(candidate.item)
+		cache.publish(EventEvicted, candidate.item.key, candidate.item.data)
+		atomic.AddUint64(&cache.evictions, 1)
+	}
+	return evicted
+}
+
+// notifyEvicted calls the remove and eviction callbacks for evicted items. Must be called without holding the lock.
+func (cache *Cache) notifyEvicted(evicted []*item) {
+	for _, item := range evicted {
+		if cache.removeCallback != nil {
+			cache.removeCallback(item.key, item.data)
+		}
+		if cache.evictionCallback != nil {
+			cache.evictionCallback(item.key, item.data)
+		}
+	}
+}
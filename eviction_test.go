@@ -0,0 +1,263 @@
+package ttlcache
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestCacheTrimToSize(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	events, _ := cache.Subscribe(20)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+		<-time.After(time.Millisecond)
+	}
+	cache.Get("key_0")
+
+	assert.Equal(t, 0, cache.TrimToSize(20), "Expected no eviction below the target")
+	assert.Equal(t, 6, cache.TrimToSize(4), "Expected 6 items to be evicted")
+	assert.Equal(t, 4, cache.Count(), "Expected the target size")
+
+	for _, key := range []string{"key_0", "key_7", "key_8", "key_9"} {
+		_, exists := cache.Get(key)
+		assert.Equal(t, true, exists, "Expected recently used item %s to remain", key)
+	}
+
+	evicted := 0
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventEvicted {
+			evicted++
+		}
+	}
+	assert.Equal(t, 6, evicted, "Expected an eviction event per evicted item")
+}
+
+func TestCacheEvictionCallbackOnMaxItems(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var lock sync.Mutex
+	var evictedKeys []string
+	expired := 0
+	cache.SetMaxItems(3)
+	cache.SetEvictionCallback(func(key string, value interface{}) {
+		lock.Lock()
+		evictedKeys = append(evictedKeys, key)
+		lock.Unlock()
+	})
+	cache.SetExpirationCallback(func(key string, value interface{}) {
+		lock.Lock()
+		expired++
+		lock.Unlock()
+	})
+
+	for i := 0; i < 3; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+	cache.Get("key_0")
+	cache.Set("key_3", "value")
+	cache.Set("key_4", "value")
+
+	assert.Equal(t, 3, cache.Count(), "Expected the cache to stay at its maximum size")
+	_, exists := cache.Get("key_0")
+	assert.Equal(t, true, exists, "Expected recently used item to remain")
+	assert.Nil(t, cache.Verify())
+
+	<-time.After(10 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"key_1", "key_2"}, evictedKeys, "Expected the least recently used items to be evicted")
+	assert.Equal(t, 0, expired, "Expected no expiration callback for evicted items")
+}
+
+func TestCacheLFUDecay(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetEvictionPolicy(LFU)
+	cache.SetLFUDecay(10 * time.Millisecond)
+	cache.SetMaxItems(2)
+
+	cache.Set("hot", "value")
+	for i := 0; i < 50; i++ {
+		cache.Get("hot")
+	}
+	<-time.After(50 * time.Millisecond)
+	cache.Set("warm", "value")
+	for i := 0; i < 3; i++ {
+		cache.Get("warm")
+	}
+
+	cache.Set("new", "value")
+	_, exists := cache.Get("hot")
+	assert.Equal(t, false, exists, "Expected the decayed key to be evicted")
+	_, exists = cache.Get("warm")
+	assert.Equal(t, true, exists, "Expected the warm key to remain")
+}
+
+func TestCacheLFUWithoutDecay(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetEvictionPolicy(LFU)
+	cache.SetMaxItems(2)
+
+	cache.Set("hot", "value")
+	for i := 0; i < 50; i++ {
+		cache.Get("hot")
+	}
+	cache.Set("warm", "value")
+	for i := 0; i < 3; i++ {
+		cache.Get("warm")
+	}
+
+	cache.Set("new", "value")
+	_, exists := cache.Get("hot")
+	assert.Equal(t, true, exists, "Expected the most used key to remain")
+	_, exists = cache.Get("warm")
+	assert.Equal(t, false, exists, "Expected the less used key to be evicted")
+}
+
+func TestCacheSetWithPriority(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetMaxItems(4)
+	cache.SetWithPriority("expensive_1", "value", ItemNotExpire, 10)
+	cache.SetWithPriority("cheap_1", "value", ItemNotExpire, 1)
+	cache.SetWithPriority("expensive_2", "value", ItemNotExpire, 10)
+	cache.SetWithPriority("cheap_2", "value", ItemNotExpire, 1)
+	cache.Get("cheap_1")
+
+	cache.SetWithPriority("new_1", "value", ItemNotExpire, 5)
+	cache.SetWithPriority("new_2", "value", ItemNotExpire, 5)
+	for _, key := range []string{"cheap_1", "cheap_2"} {
+		_, exists := cache.Get(key)
+		assert.Equal(t, false, exists, "Expected low priority item %s to be evicted first", key)
+	}
+
+	cache.SetWithPriority("new_3", "value", ItemNotExpire, 5)
+	_, exists := cache.Get("new_1")
+	assert.Equal(t, false, exists, "Expected the least recently used item of the lowest priority to be evicted")
+	for _, key := range []string{"expensive_1", "expensive_2", "new_2", "new_3"} {
+		_, exists := cache.Get(key)
+		assert.Equal(t, true, exists, "Expected item %s to remain", key)
+	}
+	assert.Nil(t, cache.Verify())
+}
+
+func TestCachePinExcludesFromEviction(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU} {
+		cache := NewCache()
+		cache.SetEvictionPolicy(policy)
+		cache.SetMaxItems(5)
+
+		cache.Set("flags", "value")
+		assert.Equal(t, true, cache.Pin("flags"))
+		assert.Equal(t, false, cache.Pin("missing"), "Expected a missing item to not be pinned")
+		for i := 0; i < 20; i++ {
+			cache.Set(fmt.Sprintf("key_%d", i), "value")
+		}
+		cache.Set("flags", "new value")
+
+		value, exists := cache.Get("flags")
+		assert.Equal(t, true, exists, "Expected the pinned item to survive the eviction under policy %d", policy)
+		assert.Equal(t, "new value", value)
+		assert.Equal(t, 5, cache.Count())
+		_, exists = cache.Get("key_0")
+		assert.Equal(t, false, exists, "Expected unpinned items to be evicted")
+		assert.Nil(t, cache.Verify())
+
+		assert.Equal(t, true, cache.Unpin("flags"))
+		assert.Equal(t, 5, cache.TrimToSize(0), "Expected the unpinned item to be evictable again")
+		assert.Nil(t, cache.Verify())
+		cache.Close()
+	}
+}
+
+func TestCacheSampledLRUEvictsOldItems(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetRandSource(rand.NewSource(42))
+	cache.SetEvictionPolicy(SampledLRU)
+	cache.SetMaxItems(100)
+	var evicted []string
+	cache.SetEvictionCallback(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), i)
+	}
+	<-time.After(time.Millisecond)
+	// the even keys are in use, the odd ones went cold
+	for i := 0; i < 100; i += 2 {
+		cache.Get(fmt.Sprintf("key_%d", i))
+	}
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("new_%d", i), i)
+	}
+
+	assert.Equal(t, 20, len(evicted))
+	hot := 0
+	for _, key := range evicted {
+		var i int
+		fmt.Sscanf(key, "key_%d", &i)
+		if !strings.HasPrefix(key, "key_") || i%2 == 0 {
+			hot++
+		}
+	}
+	assert.True(t, hot <= 4, "Expected mostly cold items to be evicted, %d of %v were in use", hot, evicted)
+	assert.Nil(t, cache.Verify())
+}
+
+func TestNewLRUCacheRunsWithoutGoroutines(t *testing.T) {
+	cache := NewLRUCache(3)
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), i)
+		if i == 2 {
+			cache.Get("key_0")
+		}
+	}
+	goleak.VerifyNoLeaks(t)
+
+	assert.Equal(t, 3, cache.Count(), "Expected the cache to stay at its capacity")
+	assert.ElementsMatch(t, []string{"key_0", "key_3", "key_4"}, cache.Keys(),
+		"Expected the least recently used items to be evicted")
+}
+
+func TestCacheSetFullPolicy(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	evicted := make(chan string, 10)
+	cache.SetEvictionCallback(func(key string, value interface{}) { evicted <- key })
+	cache.SetMaxItems(3)
+	cache.Set("pinned", 0)
+	cache.Pin("pinned")
+	cache.Set("first", 1)
+	cache.Set("second", 2)
+
+	cache.SetFullPolicy(Reject)
+	assert.Equal(t, ErrCacheFull, cache.Set("third", 3), "Expected the new item to be rejected")
+	assert.ElementsMatch(t, []string{"pinned", "first", "second"}, cache.Keys(), "Expected no eviction")
+	assert.Nil(t, cache.Set("first", 10), "Expected existing items to be replaced")
+	assert.Equal(t, uint64(1), cache.GetMetrics().FullRejections, "Expected the rejection to be counted")
+
+	cache.SetFullPolicy(EvictLRU)
+	assert.Nil(t, cache.Set("third", 3), "Expected the new item to be stored")
+	assert.ElementsMatch(t, []string{"pinned", "first", "third"}, cache.Keys(),
+		"Expected the least recently used item to be evicted, and the pinned item to be kept")
+	assert.Equal(t, "second", <-evicted)
+}
@@ -0,0 +1,25 @@
+package ttlcache
+
+// Fallback is a second cache level consulted when an item is missing, see SetFallback.
+type Fallback interface {
+	Get(key string) (interface{}, bool)
+}
+
+// SetFallback makes Get consult the fallback when an item is missing or expired. A value found by the fallback is
+// stored with the global TTL and returned as a hit. The fallback is called without holding the lock, concurrent
+// misses for a key may consult it more than once. A nil fallback disables it.
+func (cache *Cache) SetFallback(fallback Fallback) {
+	cache.mutex.Lock()
+	cache.fallback = fallback
+	cache.mutex.Unlock()
+}
+
+// getFromFallback looks the key up in the fallback, and promotes a value it finds into the cache.
+func (cache *Cache) getFromFallback(fallback Fallback, key string) (interface{}, bool) {
+	value, found := fallback.Get(key)
+	if !found {
+		return nil, false
+	}
+	cache.storeLoaded(key, value)
+	return value, true
+}
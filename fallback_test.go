@@ -0,0 +1,41 @@
+package ttlcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapFallback is a Fallback serving the values of a map, counting the lookups.
+type mapFallback struct {
+	values  map[string]interface{}
+	lookups int
+}
+
+func (fallback *mapFallback) Get(key string) (interface{}, bool) {
+	fallback.lookups++
+	value, found := fallback.values[key]
+	return value, found
+}
+
+func TestCacheSetFallbackPromotesHits(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	fallback := &mapFallback{values: map[string]interface{}{"shared": "remote value"}}
+	cache.SetFallback(fallback)
+
+	value, exists := cache.Get("shared")
+	assert.Equal(t, true, exists, "Expected the miss to be served by the fallback")
+	assert.Equal(t, "remote value", value)
+	assert.Equal(t, 1, fallback.lookups)
+
+	value, exists = cache.Get("shared")
+	assert.Equal(t, true, exists)
+	assert.Equal(t, "remote value", value)
+	assert.Equal(t, 1, fallback.lookups, "Expected the value to be promoted into the cache")
+
+	_, exists = cache.Get("missing")
+	assert.Equal(t, false, exists, "Expected a miss in both levels to miss")
+	assert.Equal(t, 1, cache.Count())
+}
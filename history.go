@@ -0,0 +1,38 @@
+package ttlcache
+
+// EnableValueHistory keeps up to depth previous values of every item, see GetHistory. Storing a new value for
+// a live item pushes its previous value, the history is dropped together with the item when it expires or is
+// removed. A depth of 0 disables the history, histories already kept are dropped on the next replace.
+func (cache *Cache) EnableValueHistory(depth int) {
+	cache.mutex.Lock()
+	cache.historyDepth = depth
+	cache.mutex.Unlock()
+}
+
+// GetHistory returns the previous values of the item, newest first, without touching it.
+// It returns nil when the item is missing or has no history, see EnableValueHistory.
+func (cache *Cache) GetHistory(key string) []interface{} {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	item, exists := cache.items.get(key)
+	if !exists || item.expiredAt(cache.now()) || len(item.history) == 0 {
+		return nil
+	}
+	return append([]interface{}(nil), item.history...)
+}
+
+// recordHistory pushes the current value of the item to its history before it is replaced.
+// Must be called with the lock held.
+func (cache *Cache) recordHistory(item *item) {
+	if cache.historyDepth <= 0 {
+		item.history = nil
+		return
+	}
+	if len(item.history) < cache.historyDepth {
+		item.history = append(item.history, nil)
+	} else {
+		item.history = item.history[:cache.historyDepth]
+	}
+	copy(item.history[1:], item.history)
+	item.history[0] = item.data
+}
@@ -0,0 +1,29 @@
+package ttlcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheValueHistory(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("key", 1)
+	cache.Set("key", 2)
+	assert.Nil(t, cache.GetHistory("key"), "Expected no history unless enabled")
+
+	cache.EnableValueHistory(3)
+	for value := 3; value <= 7; value++ {
+		cache.Set("key", value)
+	}
+	assert.Equal(t, []interface{}{6, 5, 4}, cache.GetHistory("key"), "Expected the last values, newest first")
+	value, _ := cache.Get("key")
+	assert.Equal(t, 7, value)
+
+	cache.Remove("key")
+	cache.Set("key", 8)
+	assert.Nil(t, cache.GetHistory("key"), "Expected the history to be dropped with the item")
+	assert.Nil(t, cache.GetHistory("missing"))
+}
@@ -0,0 +1,58 @@
+package ttlcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExpirationReason tells why an item expired, see SetExpirationReasonCallback.
+type ExpirationReason int
+
+const (
+	// ExpiredTTL means the TTL or the expiration time of the item elapsed.
+	ExpiredTTL ExpirationReason = iota
+	// ExpiredIdle means the item was not used for the idle timeout, see SetIdleTimeout.
+	ExpiredIdle
+	// ExpiredOnAccess means a lookup found the item expired before the sweeper evicted it, see SetLazyExpireOnGet.
+	ExpiredOnAccess
+)
+
+func (reason ExpirationReason) String() string {
+	switch reason {
+	case ExpiredTTL:
+		return "ExpiredTTL"
+	case ExpiredIdle:
+		return "ExpiredIdle"
+	case ExpiredOnAccess:
+		return "ExpiredOnAccess"
+	}
+	return fmt.Sprintf("ExpirationReason(%d)", int(reason))
+}
+
+// SetIdleTimeout makes items expire when they are not looked up for the given time, also when their TTL did not
+// elapse yet. Storing a new value counts as use. Items expire by whichever of their TTL and the idle timeout comes
+// first, see SetExpirationReasonCallback to tell them apart. It applies to items as they are stored or used.
+// A value of 0 disables the idle timeout.
+func (cache *Cache) SetIdleTimeout(timeout time.Duration) {
+	cache.mutex.Lock()
+	cache.idleTimeout = timeout
+	cache.mutex.Unlock()
+}
+
+// SetExpirationReasonCallback sets a callback that will be called when an item expires, like the expiration
+// callback, with the reason it expired.
+func (cache *Cache) SetExpirationReasonCallback(callback func(key string, value interface{}, reason ExpirationReason)) {
+	cache.mutex.Lock()
+	cache.expireReasonCallback = callback
+	cache.mutex.Unlock()
+}
+
+// resetIdle schedules the item to go idle the idle timeout after the given time of its use.
+func (cache *Cache) resetIdle(item *item, now time.Time) {
+	if cache.idleTimeout <= 0 {
+		item.idleAt = time.Time{}
+		return
+	}
+	item.idleAt = addClamped(now, cache.idleTimeout)
+	cache.startSweeper()
+}
@@ -0,0 +1,44 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheIdleTimeoutReportsReason(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	type expiration struct {
+		key    string
+		reason ExpirationReason
+	}
+	expirations := make(chan expiration, 2)
+	cache.SetExpirationReasonCallback(func(key string, value interface{}, reason ExpirationReason) {
+		expirations <- expiration{key, reason}
+	})
+
+	// the used item does not reach its idle timeout and is expired explicitly, the idle item goes idle right away,
+	// long before its TTL elapses
+	cache.SetIdleTimeout(time.Hour)
+	cache.SetWithTTL("used", "value", time.Hour)
+	cache.SetIdleTimeout(time.Nanosecond)
+	cache.SetWithTTL("idle", "value", time.Hour)
+	assert.Equal(t, true, cache.Expire("used"))
+	assert.Equal(t, 2, cache.RunCleanup())
+
+	reasons := make(map[string]ExpirationReason)
+	for len(reasons) < 2 {
+		select {
+		case expired := <-expirations:
+			reasons[expired.key] = expired.reason
+		case <-time.After(time.Second):
+			t.Fatal("Expected both items to be passed to the expiration reason callback")
+		}
+	}
+	assert.Equal(t, map[string]ExpirationReason{"idle": ExpiredIdle, "used": ExpiredTTL}, reasons,
+		"Expected the reasons to tell idle items from items whose TTL elapsed")
+	assert.Equal(t, 0, cache.Count())
+}
@@ -1,6 +1,8 @@
 package ttlcache
 
 import (
+	"container/list"
+	"math"
 	"time"
 )
 
@@ -9,13 +11,29 @@ const (
 	ItemNotExpire time.Duration = -1
 	// ItemExpireWithGlobalTTL will use the global TTL when set.
 	ItemExpireWithGlobalTTL time.Duration = 0
+
+	// maxExpirationHorizon bounds how far ahead an expiration is scheduled, longer durations saturate at it.
+	maxExpirationHorizon = 100 * 365 * 24 * time.Hour
 )
 
+// addClamped adds the duration to the time, saturating at the expiration horizon instead of overflowing.
+func addClamped(t time.Time, d time.Duration) time.Time {
+	if d > maxExpirationHorizon {
+		d = maxExpirationHorizon
+	}
+	return t.Add(d)
+}
+
 func newItem(key string, data interface{}, ttl time.Duration) *item {
+	now := time.Now()
 	item := &item{
-		data: data,
-		ttl:  ttl,
-		key:  key,
+		data:         data,
+		ttl:          ttl,
+		key:          key,
+		createdAt:    now,
+		lastAccessAt: now,
+		frequency:    1,
+		frequencyAt:  now,
 	}
 	// since nobody is aware yet of this item, it's safe to touch without lock here
 	item.touch()
@@ -23,24 +41,157 @@ func newItem(key string, data interface{}, ttl time.Duration) *item {
 }
 
 type item struct {
-	key        string
-	data       interface{}
-	ttl        time.Duration
-	expireAt   time.Time
-	queueIndex int
+	// hits comes first to keep it aligned for atomic access on 32-bit platforms
+	hits     int64
+	key      string
+	data     interface{}
+	ttl      time.Duration
+	expireAt time.Time
+	// fixedExpiry is set when expireAt is an absolute time, that is not reset from the ttl
+	fixedExpiry bool
+	queueIndex  int
+	// sequence numbers the insertions, it orders items expiring at the same time
+	sequence     uint64
+	createdAt    time.Time
+	lastAccessAt time.Time
+	accessCount  int64
+	// frequency counts the uses of the item for least frequently used eviction, as of frequencyAt
+	frequency   float64
+	frequencyAt time.Time
+	// priority orders the item for eviction before the usage, lower priorities are evicted first
+	priority int
+	// usageElement is the position of the item in the least recently used order of its priority,
+	// pinned items are left out of the order so they are never evicted
+	usageElement *list.Element
+	pinned       bool
+	// insertionElement is the position of the item in the insertion order, when it is tracked
+	insertionElement *list.Element
+	// history holds the previous values, newest first, when the value history is enabled
+	history []interface{}
+	// appended is set when data is a slice created by Append, which may grow it in place
+	appended bool
+	// idleAt is the time the item expires when it is not used until then, zero without an idle timeout
+	idleAt time.Time
+	// expiredBy is the reason the item expired, set when it is removed as expired
+	expiredBy ExpirationReason
+	// hidden is set while the new item callback runs before the item is visible, see CallbackFirst
+	hidden bool
+	// cost and size are the cost and the size of the value, as counted in the totals of the cache, see TotalCost
+	cost int64
+	size int64
+	// checkDenials counts how often the check expiration callback kept the item since its value was stored
+	checkDenials int
+}
+
+// ItemInfo describes the lifecycle of an item in the cache.
+type ItemInfo struct {
+	// CreatedAt is the time the item was stored.
+	CreatedAt time.Time
+	// LastAccessedAt is the time of the last lookup, or the creation time if it was never looked up.
+	LastAccessedAt time.Time
+	// ExpiresAt is the time the item expires, or the zero time if it does not expire.
+	ExpiresAt time.Time
+	// AccessCount is the number of lookups of the item.
+	AccessCount int64
+}
+
+// Record an access of the item
+func (item *item) access(now time.Time) {
+	item.lastAccessAt = now
+	item.accessCount++
+}
+
+// Count a use of the item, decaying the previous uses by the half-life
+func (item *item) countUse(now time.Time, halfLife time.Duration) {
+	item.frequency = item.decayedFrequency(now, halfLife) + 1
+	item.frequencyAt = now
+}
+
+// Frequency of use at the given time, halving every half-life. A half-life of 0 disables the decay
+func (item *item) decayedFrequency(now time.Time, halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return item.frequency
+	}
+	return item.frequency * math.Exp2(-float64(now.Sub(item.frequencyAt))/float64(halfLife))
+}
+
+// Describe the lifecycle of the item
+func (item *item) info() ItemInfo {
+	return ItemInfo{
+		CreatedAt:      item.createdAt,
+		LastAccessedAt: item.lastAccessAt,
+		ExpiresAt:      item.expireAt,
+		AccessCount:    item.accessCount,
+	}
 }
 
 // Reset the item expiration time
 func (item *item) touch() {
+	item.touchAt(time.Now())
+}
+
+// Reset the item expiration time relative to the given time
+func (item *item) touchAt(now time.Time) {
 	if item.ttl > 0 {
-		item.expireAt = time.Now().Add(item.ttl)
+		item.expireAt = addClamped(now, item.ttl)
 	}
 }
 
 // Verify if the item is expired
 func (item *item) expired() bool {
-	if item.ttl <= 0 {
+	return item.expiredAt(time.Now())
+}
+
+// Verify if the item is expired at the given time, hidden items count as expired so lookups miss them
+func (item *item) expiredAt(now time.Time) bool {
+	if item.hidden {
+		return true
+	}
+	if !item.idleAt.IsZero() && item.idleAt.Before(now) {
+		return true
+	}
+	if item.ttl <= 0 && !item.fixedExpiry {
+		return false
+	}
+	return item.expireAt.Before(now)
+}
+
+// Verify if the item is expired for longer than the grace period at the given time
+func (item *item) expiredFor(gracePeriod time.Duration, now time.Time) bool {
+	if !item.idleAt.IsZero() && addClamped(item.idleAt, gracePeriod).Before(now) {
+		return true
+	}
+	if item.ttl <= 0 && !item.fixedExpiry {
 		return false
 	}
-	return item.expireAt.Before(time.Now())
+	return addClamped(item.expireAt, gracePeriod).Before(now)
+}
+
+// dueAt returns the time the item expires by its TTL or by going idle, whichever comes first,
+// and the zero time when it does not expire.
+func (item *item) dueAt() time.Time {
+	if item.idleAt.IsZero() || (!item.expireAt.IsZero() && !item.idleAt.Before(item.expireAt)) {
+		return item.expireAt
+	}
+	return item.idleAt
+}
+
+// expirationReason tells whether the item expired by going idle or by its TTL.
+func (item *item) expirationReason() ExpirationReason {
+	if !item.idleAt.IsZero() && item.dueAt().Equal(item.idleAt) {
+		return ExpiredIdle
+	}
+	return ExpiredTTL
+}
+
+// Remaining TTL of the item at the given time, ItemNotExpire when the item does not expire
+// and ItemExpireWithGlobalTTL when it uses a global TTL that was not set
+func (item *item) remainingTTL(now time.Time) time.Duration {
+	if item.expireAt.IsZero() {
+		if item.ttl < 0 {
+			return ItemNotExpire
+		}
+		return ItemExpireWithGlobalTTL
+	}
+	return item.expireAt.Sub(now)
 }
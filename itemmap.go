@@ -0,0 +1,89 @@
+package ttlcache
+
+// ItemMap is the mapping from keys to items the cache uses, see NewCacheWithItemMap. The values are the
+// items of the cache and are opaque to the implementation. The cache calls it while locked, so implementations
+// need no synchronization of their own, and Range must not modify the map while iterating.
+type ItemMap interface {
+	Get(key string) (value interface{}, found bool)
+	Set(key string, value interface{})
+	Delete(key string)
+	Len() int
+	// Range calls f for every key and value until f returns false.
+	Range(f func(key string, value interface{}) bool)
+}
+
+// NewCacheWithItemMap creates a cache that keeps its items in the given map instead of a builtin map,
+// for example to try a different map implementation. The map must be empty.
+func NewCacheWithItemMap(itemMap ItemMap) *Cache {
+	cache := NewCache()
+	cache.items = itemIndex{ItemMap: itemMap}
+	return cache
+}
+
+// builtinItemMap is the default ItemMap.
+type builtinItemMap map[string]*item
+
+func (m builtinItemMap) Get(key string) (interface{}, bool) {
+	item, found := m[key]
+	return item, found
+}
+
+func (m builtinItemMap) Set(key string, value interface{}) {
+	m[key] = value.(*item)
+}
+
+func (m builtinItemMap) Delete(key string) {
+	delete(m, key)
+}
+
+func (m builtinItemMap) Len() int {
+	return len(m)
+}
+
+func (m builtinItemMap) Range(f func(key string, value interface{}) bool) {
+	for key, item := range m {
+		if !f(key, item) {
+			return
+		}
+	}
+}
+
+// itemIndex gives typed access to the items of an ItemMap.
+type itemIndex struct {
+	ItemMap
+	// keepCapacity keeps the memory of a builtin map when it is cleared, see NewCacheWithCapacity.
+	keepCapacity bool
+}
+
+func (index itemIndex) get(key string) (*item, bool) {
+	value, found := index.Get(key)
+	if !found {
+		return nil, false
+	}
+	return value.(*item), true
+}
+
+// each calls f for every item until f returns false.
+func (index itemIndex) each(f func(item *item) bool) {
+	index.Range(func(_ string, value interface{}) bool {
+		return f(value.(*item))
+	})
+}
+
+// clear removes all items and returns them.
+func (index *itemIndex) clear() []*item {
+	cleared := make([]*item, 0, index.Len())
+	index.each(func(item *item) bool {
+		cleared = append(cleared, item)
+		return true
+	})
+	if _, builtin := index.ItemMap.(builtinItemMap); builtin && !index.keepCapacity {
+		// a new map releases the memory of the old one
+		index.ItemMap = make(builtinItemMap)
+		return cleared
+	}
+	for _, item := range cleared {
+		index.Delete(item.key)
+	}
+	return cleared
+}
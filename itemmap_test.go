@@ -0,0 +1,102 @@
+package ttlcache
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncItemMap is an ItemMap backed by a sync.Map.
+type syncItemMap struct {
+	items sync.Map
+	len   int
+}
+
+func (m *syncItemMap) Get(key string) (interface{}, bool) {
+	return m.items.Load(key)
+}
+
+func (m *syncItemMap) Set(key string, value interface{}) {
+	if _, loaded := m.items.LoadOrStore(key, value); loaded {
+		m.items.Store(key, value)
+		return
+	}
+	m.len++
+}
+
+func (m *syncItemMap) Delete(key string) {
+	if _, loaded := m.items.Load(key); loaded {
+		m.items.Delete(key)
+		m.len--
+	}
+}
+
+func (m *syncItemMap) Len() int {
+	return m.len
+}
+
+func (m *syncItemMap) Range(f func(key string, value interface{}) bool) {
+	m.items.Range(func(key, value interface{}) bool {
+		return f(key.(string), value)
+	})
+}
+
+// storedItem returns the item stored under the key, expired or not. The caller must hold the lock.
+func storedItem(cache *Cache, key string) *item {
+	item, _ := cache.items.get(key)
+	return item
+}
+
+// ageItem moves the expiration of the item stored under the key back by d, as if d passed, so tests do not
+// have to sleep through TTLs. The sweeper is not woken up, lookups see the new expiration right away.
+func ageItem(cache *Cache, key string, d time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	item, _ := cache.items.get(key)
+	if !item.expireAt.IsZero() {
+		item.expireAt = item.expireAt.Add(-d)
+	}
+	if !item.idleAt.IsZero() {
+		item.idleAt = item.idleAt.Add(-d)
+	}
+	cache.priorityQueue.update(item)
+}
+
+func TestCacheWithItemMapBehavesLikeBuiltinMap(t *testing.T) {
+	itemMap := &syncItemMap{}
+	caches := []*Cache{NewCache(), NewCacheWithItemMap(itemMap)}
+	for _, cache := range caches {
+		defer cache.Close()
+	}
+
+	for _, cache := range caches {
+		cache.SetMaxItems(4)
+		cache.Set("a", 1)
+		cache.Set("b", 2)
+		cache.SetWithTTL("short", 3, 10*time.Millisecond)
+		cache.Set("c", 4)
+		cache.Set("a", 5)
+		cache.Set("d", 6)
+		cache.Remove("c")
+	}
+	<-time.After(50 * time.Millisecond)
+
+	results := make([][]interface{}, len(caches))
+	for i, cache := range caches {
+		keys := cache.Keys()
+		sort.Strings(keys)
+		a, _ := cache.Get("a")
+		_, short := cache.Get("short")
+		results[i] = []interface{}{keys, cache.Count(), a, short}
+		assert.Nil(t, cache.Verify())
+	}
+	assert.Equal(t, []interface{}{[]string{"a", "d"}, 2, 5, false}, results[0])
+	assert.Equal(t, results[0], results[1], "Expected the item map to behave like the builtin map")
+	assert.Equal(t, 2, itemMap.Len(), "Expected the items to be kept in the item map")
+
+	assert.Equal(t, 2, caches[1].Purge())
+	assert.Equal(t, 0, itemMap.Len(), "Expected Purge to empty the item map")
+}
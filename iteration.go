@@ -0,0 +1,141 @@
+package ttlcache
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"time"
+)
+
+// SetOrderedIteration allows the user to change the order of Keys, Values and Range. When this flag is set
+// to true they follow the order in which items were first added, replacing the value of an item keeps its
+// position. Otherwise the order is unspecified. Tracking the order costs memory and time on every insertion.
+func (cache *Cache) SetOrderedIteration(value bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if !value {
+		cache.items.each(func(item *item) bool {
+			item.insertionElement = nil
+			return true
+		})
+		cache.insertionOrder = nil
+		return
+	}
+	if cache.insertionOrder != nil {
+		return
+	}
+
+	items := make([]*item, 0, cache.items.Len())
+	cache.items.each(func(item *item) bool {
+		items = append(items, item)
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].createdAt.Before(items[j].createdAt)
+	})
+	cache.insertionOrder = list.New()
+	for _, item := range items {
+		item.insertionElement = cache.insertionOrder.PushBack(item)
+	}
+}
+
+// Keys returns the keys of all live items, without touching them.
+func (cache *Cache) Keys() []string {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	keys := make([]string, 0, cache.items.Len())
+	cache.forEachLive(func(item *item) bool {
+		keys = append(keys, item.key)
+		return true
+	})
+	return keys
+}
+
+// Values returns the values of all live items, without touching them.
+func (cache *Cache) Values() []interface{} {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	values := make([]interface{}, 0, cache.items.Len())
+	cache.forEachLive(func(item *item) bool {
+		values = append(values, item.data)
+		return true
+	})
+	return values
+}
+
+// Range calls f for every live item without touching it, until f returns false.
+// The cache is locked while iterating, so f must not use the cache.
+func (cache *Cache) Range(f func(key string, value interface{}) bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	cache.mutex.guard(func() {
+		cache.forEachLive(func(item *item) bool {
+			return f(item.key, item.data)
+		})
+	})
+}
+
+// RangeByExpiry calls f for every live item in the order they expire, soonest first, with its remaining life,
+// until f returns false. Items that do not expire come last, with a remaining life of ItemNotExpire. The items are
+// not touched, and listing the next items to expire is cheap as the order is walked without sorting all items.
+// The cache is locked while iterating, so f must not use the cache.
+func (cache *Cache) RangeByExpiry(f func(key string, value interface{}, remaining time.Duration) bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	now := cache.now()
+	cache.mutex.guard(func() {
+		cache.priorityQueue.ascending(func(item *item) bool {
+			if item.expiredAt(now) {
+				return true
+			}
+			remaining := ItemNotExpire
+			if due := item.dueAt(); !due.IsZero() {
+				remaining = due.Sub(now)
+			}
+			return f(item.key, item.data, remaining)
+		})
+	})
+}
+
+// RangeContext calls f for every live item without touching it, until f returns false or the context is done,
+// in which case it returns the error of the context. Unlike Range the items are captured first and f is called
+// without holding the lock, so a slow f does not block writers and may use the cache. The trade-off is that f
+// sees the items as they were when RangeContext was called: they may have been changed or removed since.
+func (cache *Cache) RangeContext(ctx context.Context, f func(key string, value interface{}) bool) error {
+	cache.mutex.RLock()
+	var keys []string
+	var values []interface{}
+	cache.forEachLive(func(item *item) bool {
+		keys = append(keys, item.key)
+		values = append(values, item.data)
+		return true
+	})
+	cache.mutex.RUnlock()
+
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !f(key, values[i]) {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// forEachLive calls f for every item that is not expired, in insertion order when it is tracked.
+func (cache *Cache) forEachLive(f func(item *item) bool) {
+	now := cache.now()
+	if cache.insertionOrder != nil {
+		for element := cache.insertionOrder.Front(); element != nil; element = element.Next() {
+			item := element.Value.(*item)
+			if !item.expiredAt(now) && !f(item) {
+				return
+			}
+		}
+		return
+	}
+	cache.items.each(func(item *item) bool {
+		return item.expiredAt(now) || f(item)
+	})
+}
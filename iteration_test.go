@@ -0,0 +1,117 @@
+package ttlcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheOrderedIteration(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.SetOrderedIteration(true)
+	cache.Set("c", 3)
+	cache.Set("d", 4)
+	cache.Set("e", 5)
+	cache.Set("b", 20)
+	cache.Remove("c")
+	cache.SetWithTTL("expired", 6, time.Nanosecond)
+	cache.Set("f", 7)
+	<-time.After(time.Millisecond)
+
+	assert.Equal(t, []string{"a", "b", "d", "e", "f"}, cache.Keys(), "Expected keys in insertion order")
+	assert.Equal(t, []interface{}{1, 20, 4, 5, 7}, cache.Values(), "Expected values in insertion order")
+
+	var visited []string
+	cache.Range(func(key string, value interface{}) bool {
+		visited = append(visited, key)
+		return len(visited) < 3
+	})
+	assert.Equal(t, []string{"a", "b", "d"}, visited, "Expected Range to stop when f returns false")
+
+	cache.Purge()
+	cache.Set("g", 8)
+	assert.Equal(t, []string{"g"}, cache.Keys(), "Expected the order to restart after Purge")
+	assert.Nil(t, cache.Verify(), "Expected invariants to hold")
+}
+
+func TestCacheKeys(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	assert.ElementsMatch(t, []string{"a", "b"}, cache.Keys(), "Expected all keys")
+	assert.ElementsMatch(t, []interface{}{1, 2}, cache.Values(), "Expected all values")
+}
+
+func TestCacheRangeContextStopsWhenCancelled(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited := 0
+	err := cache.RangeContext(ctx, func(key string, value interface{}) bool {
+		visited++
+		// f runs without the lock, so it may write to the cache
+		cache.Set("written_during_range", true)
+		if visited == 3 {
+			cancel()
+		}
+		return true
+	})
+	assert.Equal(t, context.Canceled, err, "Expected the error of the context")
+	assert.Equal(t, 3, visited, "Expected the iteration to stop right after the cancellation")
+
+	visited = 0
+	err = cache.RangeContext(context.Background(), func(key string, value interface{}) bool {
+		visited++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 101, visited)
+}
+
+func TestCacheRangeByExpiry(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("permanent", 0, ItemNotExpire)
+	for _, i := range []int{5, 2, 8, 1, 9, 3, 7, 4, 6} {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), i, time.Duration(i)*time.Minute)
+	}
+
+	var keys []string
+	previous := time.Duration(0)
+	cache.RangeByExpiry(func(key string, value interface{}, remaining time.Duration) bool {
+		if key != "permanent" {
+			assert.True(t, remaining > previous, "Expected ascending remaining TTLs")
+			assert.InDelta(t, float64(time.Duration(value.(int))*time.Minute), float64(remaining), float64(time.Second))
+			previous = remaining
+		} else {
+			assert.Equal(t, ItemNotExpire, remaining, "Expected no remaining TTL for the permanent item")
+		}
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"key_1", "key_2", "key_3", "key_4", "key_5", "key_6", "key_7", "key_8", "key_9", "permanent"},
+		keys, "Expected the items soonest to expire first, and the permanent item last")
+
+	keys = nil
+	cache.RangeByExpiry(func(key string, value interface{}, remaining time.Duration) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	assert.Equal(t, []string{"key_1", "key_2", "key_3"}, keys, "Expected the iteration to stop")
+}
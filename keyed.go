@@ -0,0 +1,31 @@
+package ttlcache
+
+import (
+	"time"
+)
+
+// Key is implemented by composite keys that are used directly with the keyed methods of the cache.
+// String must return the same canonical string for equal keys, the cache indexes items by it.
+type Key interface {
+	String() string
+}
+
+// SetKeyed is a thread-safe way to add new items to the map under a composite key
+func (cache *Cache) SetKeyed(key Key, data interface{}) error {
+	return cache.Set(key.String(), data)
+}
+
+// SetKeyedWithTTL is a thread-safe way to add new items to the map under a composite key with individual ttl
+func (cache *Cache) SetKeyedWithTTL(key Key, data interface{}, ttl time.Duration) error {
+	return cache.SetWithTTL(key.String(), data, ttl)
+}
+
+// GetKeyed is a thread-safe way to lookup items by a composite key
+func (cache *Cache) GetKeyed(key Key) (interface{}, bool) {
+	return cache.Get(key.String())
+}
+
+// RemoveKeyed removes the item stored under a composite key
+func (cache *Cache) RemoveKeyed(key Key) bool {
+	return cache.Remove(key.String())
+}
@@ -0,0 +1,34 @@
+package ttlcache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type compositeKey struct {
+	tenant string
+	id     int
+}
+
+func (key compositeKey) String() string {
+	return fmt.Sprintf("%s/%d", key.tenant, key.id)
+}
+
+func TestCacheKeyed(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetKeyed(compositeKey{tenant: "acme", id: 1}, "value")
+
+	data, exists := cache.GetKeyed(compositeKey{tenant: "acme", id: 1})
+	assert.Equal(t, true, exists, "Expected an equal key to hit the same item")
+	assert.Equal(t, "value", data, "Expected the stored value")
+
+	_, exists = cache.GetKeyed(compositeKey{tenant: "acme", id: 2})
+	assert.Equal(t, false, exists, "Expected a different key to miss")
+
+	assert.Equal(t, true, cache.RemoveKeyed(compositeKey{tenant: "acme", id: 1}), "Expected an equal key to remove the item")
+	assert.Equal(t, 0, cache.Count(), "Expected the cache to be empty")
+}
@@ -0,0 +1,36 @@
+package ttlcache
+
+import (
+	"sync"
+)
+
+// keyLockStripes is the number of mutexes the key locks are spread over.
+const keyLockStripes = 256
+
+// LockKey acquires a lock for the key that callers can hold around their own critical sections, for example
+// to make sure a value is computed only once. It returns the function that releases the lock.
+// The locks are independent of the items and of the internal lock of the cache, so the cache can be used
+// while holding one and removing the item is safe. Keys are spread over a fixed number of striped locks,
+// so unrelated keys can share a lock: never hold the lock of one key while acquiring the lock of another.
+func (cache *Cache) LockKey(key string) (unlock func()) {
+	mutex := &cache.keyLocks[hashKey(key)%keyLockStripes]
+	mutex.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(mutex.Unlock)
+	}
+}
+
+// hashKey hashes the key with 64-bit FNV-1a.
+func hashKey(key string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	hash := uint64(offset)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime
+	}
+	return hash
+}
@@ -0,0 +1,40 @@
+package ttlcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheLockKey(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("key", "value")
+
+	var inside, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			unlock := cache.LockKey("key")
+			defer unlock()
+			if atomic.AddInt32(&inside, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			if i == 10 {
+				cache.Remove("key")
+			}
+			cache.Get("key")
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&inside, -1)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&overlaps), "Expected critical sections for the same key to not overlap")
+}
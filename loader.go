@@ -0,0 +1,500 @@
+package ttlcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLoaderTimeout is returned to callers that waited longer than the single flight timeout for the loader
+// of another caller, see SetSingleFlightTimeout.
+var ErrLoaderTimeout = errors.New("ttlcache: timed out waiting for loader")
+
+// ErrNotLoaded is returned, wrapped in a LoaderError, to callers of GetOrSet waiting for a key that the bulk
+// loader of GetOrSetMany returned no value for.
+var ErrNotLoaded = errors.New("ttlcache: key not returned by bulk loader")
+
+// ErrLoaderPanic is returned, wrapped in a LoaderError, to callers of GetOrSet whose loader panicked, and as is
+// by GetOrSetMany when the bulk loader panicked, see SetLoaderPanicHandler.
+var ErrLoaderPanic = errors.New("ttlcache: loader panicked")
+
+// ErrExpired is returned by GetOrSetIfFresh together with the value of an item that expired, but was not
+// evicted yet.
+var ErrExpired = errors.New("ttlcache: item expired")
+
+// LoaderError is returned when the loader of GetOrSet or GetOrDefault fails, it carries the key that was loaded.
+type LoaderError struct {
+	Key string
+	Err error
+}
+
+func (e *LoaderError) Error() string {
+	return fmt.Sprintf("ttlcache: loading key %q: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the error of the loader.
+func (e *LoaderError) Unwrap() error {
+	return e.Err
+}
+
+// CachedError is a cached value that stands for an error, such as a key known to be permanently invalid, see
+// SetError. Unlike an error of the loader, which is never cached, it is stored like any other value with its own
+// TTL: GetOrSet and its variants return its error on a hit without calling the loader. A loader can cache an error
+// this way by returning a CachedError as the value. Get returns the CachedError itself.
+type CachedError struct {
+	Err error
+}
+
+func (e *CachedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cached error.
+func (e *CachedError) Unwrap() error {
+	return e.Err
+}
+
+// SetError caches the error as a value with its own TTL, see CachedError.
+func (cache *Cache) SetError(key string, err error, ttl time.Duration) error {
+	return cache.SetWithTTL(key, &CachedError{Err: err}, ttl)
+}
+
+// cachedResult returns the error of a CachedError value instead of the value.
+func cachedResult(value interface{}, err error) (interface{}, error) {
+	if cached, ok := value.(*CachedError); ok && err == nil {
+		return nil, cached.Err
+	}
+	return value, err
+}
+
+// CloseLoaderPolicy decides what happens to the loads of GetOrSet and GetOrSetMany that are in flight when the
+// cache is closed, see SetCloseLoaderPolicy. Their results are never cached after Close.
+type CloseLoaderPolicy int
+
+const (
+	// FinishPending lets the loads finish and returns their results to the callers, Close does not wait for them.
+	// It is the default.
+	FinishPending CloseLoaderPolicy = iota
+	// CancelPending returns ErrClosed to the callers waiting for a load right away, and discards the results of
+	// the loads, so their callers receive ErrClosed as well once the loaders return.
+	CancelPending
+	// DrainPending makes Close wait for the loads to finish, and returns their results to the callers.
+	DrainPending
+)
+
+// SetCloseLoaderPolicy sets what happens to the loads in flight when the cache is closed, FinishPending by default.
+func (cache *Cache) SetCloseLoaderPolicy(policy CloseLoaderPolicy) {
+	cache.mutex.Lock()
+	cache.closeLoaderPolicy = policy
+	cache.mutex.Unlock()
+}
+
+// cancelledOnClose returns the channel waiters for a load select on to give up when the cache is closed, which is
+// nil unless the close loader policy is CancelPending. Must be called with the lock held.
+func (cache *Cache) cancelledOnClose() <-chan struct{} {
+	if cache.closeLoaderPolicy != CancelPending {
+		return nil
+	}
+	return cache.shutdownSignal
+}
+
+// discardedOnClose tells whether the results of loads are discarded because the cache was closed while they were
+// in flight, see CancelPending. Must be called with the lock held.
+func (cache *Cache) discardedOnClose() bool {
+	return cache.isShutDown && cache.closeLoaderPolicy == CancelPending
+}
+
+// loaderCall tracks a loader invocation that is in flight for a key, so concurrent
+// callers for the same key can wait for its result instead of loading again.
+type loaderCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// GetOrSet is a thread-safe way to lookup items and load missing ones with the given loader.
+// Contrary to GetOrDefault the cache is not locked while the loader runs, concurrent calls for the
+// same key wait for the first loader and share its result. A successful result is stored with the
+// global TTL, errors are returned to all waiting callers and never cached, see also SetLoaderRetry.
+// A panic of the loader is recovered and treated as an error, see SetLoaderPanicHandler.
+// A cached nil value is found like any other value, the loader is not called for it, and so is a cached error,
+// which is returned as the error, see CachedError. After Close it returns ErrClosed without calling the loader.
+func (cache *Cache) GetOrSet(key string, loader func(string) (interface{}, error)) (interface{}, error) {
+	return cachedResult(cache.getOrSet(context.Background(), key, func(_ context.Context, key string) (interface{}, error) {
+		return loader(key)
+	}))
+}
+
+// GetOrSetCtx works like GetOrSet, passing the values of the context to the loader. A caller returns the error
+// of its context once it is done, also the caller that started the load, the load keeps running for the others
+// and is not cancelled along with the context.
+func (cache *Cache) GetOrSetCtx(ctx context.Context, key string, loader func(ctx context.Context, key string) (interface{}, error)) (interface{}, error) {
+	return cachedResult(cache.getOrSet(ctx, key, loader))
+}
+
+func (cache *Cache) getOrSet(ctx context.Context, key string, loader func(context.Context, string) (interface{}, error)) (interface{}, error) {
+	cache.mutex.Lock()
+	if cache.isShutDown {
+		cache.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	item, exists, triggerExpirationNotification := cache.getItemAt(key, cache.now(), !cache.skipTTLExtensionOnLoaderHit)
+	if exists {
+		dataToReturn := item.data
+		cache.mutex.Unlock()
+		if triggerExpirationNotification {
+			cache.notifyExpiration()
+		}
+		return dataToReturn, nil
+	}
+
+	if err := cache.validateKey(key); err != nil {
+		cache.mutex.Unlock()
+		return nil, err
+	}
+
+	closed := cache.cancelledOnClose()
+	if call, loading := cache.loaderCalls[key]; loading {
+		timeout := cache.singleFlightTimeout
+		cache.mutex.Unlock()
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		return call.await(ctx, expired, closed)
+	}
+
+	call := &loaderCall{done: make(chan struct{})}
+	cache.loaderCalls[key] = call
+	cache.pendingLoads.Add(1)
+	semaphore := cache.loaderSemaphore
+	attempts, backoff := cache.loaderAttempts, cache.loaderBackoff
+	panicHandler := cache.loaderPanicHandler
+	cache.mutex.Unlock()
+
+	// the load is shared with the callers waiting for it, so it is not cancelled along with the context of this
+	// caller, which only stops waiting for it
+	go cache.load(detachedContext{ctx}, key, call, loader, semaphore, attempts, backoff, panicHandler)
+	return call.await(ctx, nil, closed)
+}
+
+// load calls the loader of GetOrSet for the key, retrying it as configured, stores a loaded value and hands the
+// result to the callers waiting for the call.
+func (cache *Cache) load(ctx context.Context, key string, call *loaderCall, loader func(context.Context, string) (interface{}, error), semaphore chan struct{}, attempts int, backoff time.Duration, panicHandler func(string, interface{})) {
+	defer cache.pendingLoads.Done()
+	defer func() {
+		cache.mutex.Lock()
+		delete(cache.loaderCalls, key)
+		if loaderPanicked(call.err) {
+			if stale, found := cache.staleValue(key); found {
+				call.value, call.err = stale, nil
+			}
+		}
+		if cache.discardedOnClose() {
+			call.value, call.err = nil, ErrClosed
+		}
+		cache.mutex.Unlock()
+		close(call.done)
+	}()
+
+	load := func(key string) (value interface{}, err error) {
+		defer recoverLoader(panicHandler, []string{key}, &err)
+		return loader(ctx, key)
+	}
+	call.value, call.err = cache.invokeLoader(semaphore, key, load)
+	for attempt := 1; call.err != nil && attempt < attempts; attempt++ {
+		time.Sleep(backoff)
+		call.value, call.err = cache.invokeLoader(semaphore, key, load)
+	}
+	if call.err == nil {
+		cache.storeLoaded(key, call.value)
+	}
+}
+
+// await waits for the result of the call, until the context is done, the timeout expired or the cache is closed.
+func (call *loaderCall) await(ctx context.Context, expired <-chan time.Time, closed <-chan struct{}) (interface{}, error) {
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-expired:
+		return nil, ErrLoaderTimeout
+	case <-closed:
+		return nil, ErrClosed
+	}
+}
+
+// detachedContext passes the values of its parent context on, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+// GetOrSetIfFresh works like GetOrSet, except for items that expired but were not evicted yet: their stale value is
+// returned together with ErrExpired and the loader is not called, so the caller can decide whether to reload it,
+// for example with GetOrSet, which treats expired items as missing.
+func (cache *Cache) GetOrSetIfFresh(key string, loader func(string) (interface{}, error)) (interface{}, error) {
+	cache.mutex.RLock()
+	item, exists := cache.items.get(key)
+	if exists && item.expiredAt(cache.now()) && !item.hidden {
+		stale := item.data
+		cache.mutex.RUnlock()
+		return stale, ErrExpired
+	}
+	cache.mutex.RUnlock()
+	return cache.GetOrSet(key, loader)
+}
+
+// GetOrCompute is a thread-safe way to lookup items and compute missing ones, meant for workloads that mostly hit.
+// Hits are served under the read lock, so unlike Get they do not extend the life of the item or count as use for
+// eviction. Misses are computed like GetOrSet does, concurrent callers for the same key share a single computation.
+func (cache *Cache) GetOrCompute(key string, compute func() (interface{}, error)) (interface{}, error) {
+	cache.mutex.RLock()
+	item, exists := cache.items.get(key)
+	if exists && !item.expiredAt(cache.now()) {
+		cache.countHit(item)
+		dataToReturn := item.data
+		cache.mutex.RUnlock()
+		return cachedResult(dataToReturn, nil)
+	}
+	cache.mutex.RUnlock()
+
+	return cache.GetOrSet(key, func(string) (interface{}, error) {
+		return compute()
+	})
+}
+
+// GetOrSetMany is a thread-safe way to lookup many items and load all missing ones with a single call of the
+// bulk loader. The values returned by the loader are stored with the global TTL, and returned together with the
+// hits. Keys the loader returns no value for are left out. Keys another caller is loading already are not passed
+// to the loader, their result is awaited instead, and callers of GetOrSet waiting for a key of the bulk load
+// share its result, receiving ErrNotLoaded for keys without a value. An error of the loader is returned as is.
+// After Close it returns ErrClosed without calling the loader.
+func (cache *Cache) GetOrSetMany(keys []string, bulkLoader func(missingKeys []string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	owned := make(map[string]*loaderCall)
+	awaited := make(map[string]*loaderCall)
+	var missing []string
+
+	cache.mutex.Lock()
+	if cache.isShutDown {
+		cache.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	for _, key := range keys {
+		if err := cache.validateKey(key); err != nil {
+			cache.mutex.Unlock()
+			return nil, err
+		}
+	}
+	now := cache.now()
+	triggerExpirationNotification := false
+	for _, key := range keys {
+		if _, found := values[key]; found || owned[key] != nil || awaited[key] != nil {
+			continue
+		}
+		item, exists, trigger := cache.getItemAt(key, now, !cache.skipTTLExtensionOnLoaderHit)
+		triggerExpirationNotification = triggerExpirationNotification || trigger
+		if exists {
+			values[key] = item.data
+			continue
+		}
+		if call, loading := cache.loaderCalls[key]; loading {
+			awaited[key] = call
+			continue
+		}
+		call := &loaderCall{done: make(chan struct{})}
+		cache.loaderCalls[key] = call
+		owned[key] = call
+		missing = append(missing, key)
+	}
+	semaphore := cache.loaderSemaphore
+	panicHandler := cache.loaderPanicHandler
+	closed := cache.cancelledOnClose()
+	if len(missing) > 0 {
+		cache.pendingLoads.Add(1)
+		defer cache.pendingLoads.Done()
+	}
+	cache.mutex.Unlock()
+	if triggerExpirationNotification {
+		cache.notifyExpiration()
+	}
+
+	var err error
+	if len(missing) > 0 {
+		err = cache.loadMissing(semaphore, panicHandler, missing, owned, values, bulkLoader)
+	}
+
+	for key, call := range awaited {
+		select {
+		case <-call.done:
+		case <-closed:
+			return nil, ErrClosed
+		}
+		if call.err == nil {
+			values[key] = call.value
+		} else if le, ok := call.err.(*LoaderError); err == nil && !(ok && le.Err == ErrNotLoaded) {
+			err = call.err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// loadMissing calls the bulk loader for the missing keys of GetOrSetMany, stores the loaded values and adds them to
+// values. It hands the results to the calls it owns for the keys, and releases them also when the loader panics.
+func (cache *Cache) loadMissing(semaphore chan struct{}, panicHandler func(string, interface{}), missing []string, owned map[string]*loaderCall, values map[string]interface{}, bulkLoader func([]string) (map[string]interface{}, error)) (err error) {
+	defer func() {
+		cache.mutex.Lock()
+		for _, key := range missing {
+			delete(cache.loaderCalls, key)
+		}
+		if cache.discardedOnClose() {
+			err = ErrClosed
+			for _, key := range missing {
+				owned[key].value, owned[key].err = nil, ErrClosed
+			}
+		}
+		cache.mutex.Unlock()
+		for _, key := range missing {
+			close(owned[key].done)
+		}
+	}()
+
+	loaded, err := cache.invokeBulkLoader(semaphore, missing, func(keys []string) (loaded map[string]interface{}, err error) {
+		defer recoverLoader(panicHandler, keys, &err)
+		return bulkLoader(keys)
+	})
+	for _, key := range missing {
+		call := owned[key]
+		value, found := loaded[key]
+		switch {
+		case err != nil:
+			call.err = &LoaderError{Key: key, Err: err}
+		case !found:
+			call.err = &LoaderError{Key: key, Err: ErrNotLoaded}
+		default:
+			call.value = value
+			values[key] = value
+			cache.storeLoaded(key, value)
+		}
+	}
+	return err
+}
+
+// SetLoaderConcurrency caps the number of loaders that run simultaneously across all keys,
+// callers exceeding the limit block until a running loader finishes. A value of 0 means unlimited.
+// The limit applies to GetOrSet and GetOrDefault, and composes with the per key deduplication of GetOrSet.
+// Callers wait for a slot without holding the lock of the cache, so running loaders can use the cache.
+func (cache *Cache) SetLoaderConcurrency(max int) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if max > 0 {
+		cache.loaderSemaphore = make(chan struct{}, max)
+	} else {
+		cache.loaderSemaphore = nil
+	}
+}
+
+// SetSingleFlightTimeout bounds how long GetOrSet and GetOrSetCtx wait for the loader of another caller for the
+// same key, after which they return ErrLoaderTimeout. The loader keeps running for the caller that started it.
+// A value of 0 waits for the loader to finish.
+func (cache *Cache) SetSingleFlightTimeout(timeout time.Duration) {
+	cache.mutex.Lock()
+	cache.singleFlightTimeout = timeout
+	cache.mutex.Unlock()
+}
+
+// SetLoaderRetry makes GetOrSet call a failing loader up to attempts times in total, sleeping backoff between
+// the attempts. Concurrent callers for the same key wait for all attempts, and receive the last error if all
+// of them fail. A value of 1 or less disables retries.
+func (cache *Cache) SetLoaderRetry(attempts int, backoff time.Duration) {
+	cache.mutex.Lock()
+	cache.loaderAttempts = attempts
+	cache.loaderBackoff = backoff
+	cache.mutex.Unlock()
+}
+
+// SetLoaderPanicHandler sets the handler that receives the values recovered from loaders that panicked.
+// A panicking loader is treated as a failed load: nothing is cached and the callers receive ErrLoaderPanic, unless
+// the key holds an item that expired less than the grace period ago, see SetGracePeriod, whose stale value is
+// returned instead. A panicking bulk loader of GetOrSetMany fails the load of all its keys, and the handler is called
+// for each of them. The handler is called without holding the lock, in the goroutine of the loader.
+func (cache *Cache) SetLoaderPanicHandler(handler func(key string, recovered interface{})) {
+	cache.mutex.Lock()
+	cache.loaderPanicHandler = handler
+	cache.mutex.Unlock()
+}
+
+// recoverLoader turns a panic of the loader of the keys into ErrLoaderPanic, and passes the recovered value to
+// the panic handler for each of the keys. It must be deferred by the function calling the loader.
+func recoverLoader(panicHandler func(string, interface{}), keys []string, err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	*err = ErrLoaderPanic
+	if panicHandler != nil {
+		for _, key := range keys {
+			panicHandler(key, recovered)
+		}
+	}
+}
+
+// loaderPanicked tells whether the error of a load stems from a panic of the loader.
+func loaderPanicked(err error) bool {
+	loaderErr, ok := err.(*LoaderError)
+	return ok && loaderErr.Err == ErrLoaderPanic
+}
+
+// staleValue returns the value of the item for the key, including items that expired less than the grace period
+// ago. Must be called with the lock held.
+func (cache *Cache) staleValue(key string) (interface{}, bool) {
+	item, exists := cache.items.get(key)
+	if !exists || item.hidden || item.expiredFor(cache.gracePeriod, cache.now()) {
+		return nil, false
+	}
+	return item.data, true
+}
+
+// invokeLoader calls the loader, holding a slot of the semaphore when one is configured.
+// Errors of the loader are wrapped in a LoaderError. The call is recorded in the loader metrics.
+func (cache *Cache) invokeLoader(semaphore chan struct{}, key string, loader func(string) (interface{}, error)) (interface{}, error) {
+	if semaphore != nil {
+		semaphore <- struct{}{}
+		defer func() { <-semaphore }()
+	}
+	start := time.Now()
+	value, err := loader(key)
+	cache.recordLoad(time.Since(start), err)
+	if err != nil {
+		return nil, &LoaderError{Key: key, Err: err}
+	}
+	return value, nil
+}
+
+// invokeBulkLoader calls the bulk loader like invokeLoader, holding a single slot of the semaphore and
+// recording a single loader call. Errors of the loader are returned as is.
+func (cache *Cache) invokeBulkLoader(semaphore chan struct{}, keys []string, bulkLoader func([]string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if semaphore != nil {
+		semaphore <- struct{}{}
+		defer func() { <-semaphore }()
+	}
+	start := time.Now()
+	values, err := bulkLoader(keys)
+	cache.recordLoad(time.Since(start), err)
+	return values, err
+}
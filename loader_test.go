@@ -0,0 +1,658 @@
+package ttlcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheGetOrSetLoadsOnce(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := cache.GetOrSet("key", func(key string) (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return "value", nil
+			})
+			assert.Nil(t, err, "Expected load to succeed")
+			assert.Equal(t, "value", data, "Expected loaded value")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Expected the loader to run once for concurrent callers")
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected loaded value to be cached")
+	assert.Equal(t, "value", data, "Expected loaded value to be cached")
+}
+
+func TestCacheGetOrSetCtxCancelledWaiter(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		close(started)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		data, err := cache.GetOrSetCtx(context.Background(), "key", loader)
+		assert.Nil(t, err, "Expected the sibling to get the loaded value")
+		assert.Equal(t, "value", data, "Expected the loaded value")
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := cache.GetOrSetCtx(ctx, "key", loader)
+		cancelled <- err
+	}()
+	<-time.After(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-cancelled:
+		assert.Equal(t, context.Canceled, err, "Expected the context error")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cancelled waiter to return promptly")
+	}
+
+	close(release)
+	wg.Wait()
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected the load to complete for the sibling")
+	assert.Equal(t, "value", data, "Expected the loaded value to be cached")
+}
+
+func TestCacheSetSingleFlightTimeout(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetSingleFlightTimeout(20 * time.Millisecond)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	leader := make(chan error)
+	go func() {
+		_, err := cache.GetOrSet("key", func(string) (interface{}, error) {
+			close(started)
+			<-release
+			return "value", nil
+		})
+		leader <- err
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.GetOrSet("key", func(string) (interface{}, error) {
+				t.Error("Expected waiters to not load")
+				return nil, nil
+			})
+			assert.Equal(t, ErrLoaderTimeout, err, "Expected the waiter to time out")
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	assert.Nil(t, <-leader, "Expected the leader to finish loading")
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected the leader to cache the value")
+	assert.Equal(t, "value", data, "Expected the loaded value")
+}
+
+func TestCacheGetOrCompute(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var calls int32
+	compute := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := cache.GetOrCompute("key", compute)
+			assert.Nil(t, err, "Expected compute to succeed")
+			assert.Equal(t, "value", data, "Expected computed value")
+		}()
+	}
+	wg.Wait()
+
+	data, err := cache.GetOrCompute("key", compute)
+	assert.Nil(t, err, "Expected hit to succeed")
+	assert.Equal(t, "value", data, "Expected cached value")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Expected a single computation")
+
+	_, err = cache.GetOrCompute("failing", func() (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	assert.NotNil(t, err, "Expected compute error to be returned")
+	assert.Equal(t, 1, cache.Count(), "Expected errors to not be cached")
+}
+
+func TestCacheSetLoaderConcurrency(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetLoaderConcurrency(3)
+
+	var running, peak int32
+	loader := func(key string) (interface{}, error) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&peak)
+			if current <= max || atomic.CompareAndSwapInt32(&peak, max, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return key, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cache.GetOrSet(fmt.Sprintf("key_%d", i), loader)
+			assert.Nil(t, err, "Expected load to succeed")
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, atomic.LoadInt32(&peak) <= 3, "Expected at most 3 concurrent loaders")
+	assert.Equal(t, 30, cache.Count(), "Expected all loaded values to be cached")
+}
+
+func TestCacheLoaderConcurrencyDoesNotHoldLock(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetLoaderConcurrency(1)
+	cache.Set("other", "value")
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	loaded := make(chan interface{}, 2)
+	go func() {
+		value, _ := cache.GetOrSet("key", func(key string) (interface{}, error) {
+			close(started)
+			<-proceed
+			// the loader uses the cache while GetOrDefault waits for its slot
+			value, _ := cache.Get("other")
+			return value, nil
+		})
+		loaded <- value
+	}()
+	<-started
+	go func() {
+		value, _ := cache.GetOrDefault("default", func(key string) (interface{}, error) {
+			return "generated", nil
+		})
+		loaded <- value
+	}()
+	<-time.After(10 * time.Millisecond)
+	close(proceed)
+
+	values := make(map[interface{}]bool)
+	for len(values) < 2 {
+		select {
+		case value := <-loaded:
+			values[value] = true
+		case <-time.After(time.Second):
+			t.Fatal("Expected GetOrDefault to wait for a loader slot without holding the lock")
+		}
+	}
+	assert.Equal(t, map[interface{}]bool{"value": true, "generated": true}, values)
+}
+
+func TestCacheLoaderError(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	loaderErr := errors.New("database unavailable")
+	_, err := cache.GetOrSet("key", func(key string) (interface{}, error) {
+		return nil, loaderErr
+	})
+
+	assert.True(t, errors.Is(err, loaderErr), "Expected the error to unwrap to the loader error")
+	var keyErr *LoaderError
+	if assert.True(t, errors.As(err, &keyErr), "Expected a LoaderError") {
+		assert.Equal(t, "key", keyErr.Key, "Expected the error to carry the key")
+	}
+	_, exists := cache.Get("key")
+	assert.Equal(t, false, exists, "Expected nothing to be cached on loader error")
+}
+
+func TestCacheSetLoaderRetry(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetLoaderRetry(3, 10*time.Millisecond)
+
+	var calls int32
+	loader := func(key string) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return nil, errors.New("transient")
+		}
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := cache.GetOrSet("key", loader)
+			assert.Nil(t, err, "Expected the retried load to succeed")
+			assert.Equal(t, "value", data, "Expected the loaded value")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Expected the loader to be called exactly three times")
+	data, exists := cache.Get("key")
+	assert.Equal(t, true, exists, "Expected the loaded value to be cached")
+	assert.Equal(t, "value", data, "Expected the loaded value to be cached")
+
+	transient := errors.New("still failing")
+	_, err := cache.GetOrSet("failing", func(key string) (interface{}, error) {
+		return nil, transient
+	})
+	assert.True(t, errors.Is(err, transient), "Expected the last error when all attempts fail")
+}
+
+func TestCacheGetOrSetManyLoadsMissingKeysOnce(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("a", "cached a")
+	cache.Set("b", "cached b")
+
+	var calls [][]string
+	bulkLoader := func(missingKeys []string) (map[string]interface{}, error) {
+		calls = append(calls, missingKeys)
+		values := make(map[string]interface{})
+		for _, key := range missingKeys {
+			if key != "unknown" {
+				values[key] = "loaded " + key
+			}
+		}
+		return values, nil
+	}
+
+	values, err := cache.GetOrSetMany([]string{"a", "c", "b", "d", "c", "unknown"}, bulkLoader)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"c", "d", "unknown"}}, calls, "Expected a single call with exactly the missing keys")
+	assert.Equal(t, map[string]interface{}{"a": "cached a", "b": "cached b", "c": "loaded c", "d": "loaded d"}, values)
+
+	values, err = cache.GetOrSetMany([]string{"c", "d"}, bulkLoader)
+	assert.Nil(t, err)
+	assert.Len(t, calls, 1, "Expected the loaded values to be cached")
+	assert.Equal(t, map[string]interface{}{"c": "loaded c", "d": "loaded d"}, values)
+}
+
+func TestCacheGetOrSetManySharesInFlightLoads(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go cache.GetOrSet("a", func(key string) (interface{}, error) {
+		close(started)
+		<-release
+		return "single a", nil
+	})
+	<-started
+
+	var missing []string
+	done := make(chan map[string]interface{})
+	go func() {
+		values, _ := cache.GetOrSetMany([]string{"a", "b"}, func(missingKeys []string) (map[string]interface{}, error) {
+			missing = missingKeys
+			return map[string]interface{}{"b": "bulk b"}, nil
+		})
+		done <- values
+	}()
+	<-time.After(10 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, map[string]interface{}{"a": "single a", "b": "bulk b"}, <-done)
+	assert.Equal(t, []string{"b"}, missing, "Expected the key loaded elsewhere not to be loaded again")
+}
+
+func TestCacheGetOrSetIfFresh(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	calls := 0
+	loader := func(key string) (interface{}, error) {
+		calls++
+		return "loaded", nil
+	}
+
+	cache.SetWithTTL("fresh", "cached", time.Minute)
+	value, err := cache.GetOrSetIfFresh("fresh", loader)
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", value, "Expected a fresh hit to be returned")
+	assert.Equal(t, 0, calls)
+
+	value, err = cache.GetOrSetIfFresh("missing", loader)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", value, "Expected a miss to be loaded")
+	assert.Equal(t, 1, calls)
+
+	cache.SetWithTTL("expired", "stale", time.Millisecond)
+	<-time.After(5 * time.Millisecond)
+	value, err = cache.GetOrSetIfFresh("expired", loader)
+	assert.Equal(t, ErrExpired, err, "Expected an expired item to be reported")
+	assert.Equal(t, "stale", value, "Expected the stale value with the error")
+	assert.Equal(t, 1, calls, "Expected the loader to not run for an expired item")
+}
+
+func TestCacheLoadersFailAfterClose(t *testing.T) {
+	cache := NewCache()
+	cache.Close()
+
+	calls := 0
+	loader := func(key string) (interface{}, error) {
+		calls++
+		return "value", nil
+	}
+	_, err := cache.GetOrDefault("key", loader)
+	assert.Equal(t, ErrClosed, err, "Expected GetOrDefault to fail after Close")
+	_, err = cache.GetOrSet("key", loader)
+	assert.Equal(t, ErrClosed, err, "Expected GetOrSet to fail after Close")
+	_, err = cache.GetOrSetMany([]string{"key"}, func(keys []string) (map[string]interface{}, error) {
+		calls++
+		return nil, nil
+	})
+	assert.Equal(t, ErrClosed, err, "Expected GetOrSetMany to fail after Close")
+	assert.Equal(t, 0, calls, "Expected no loader to be called")
+}
+
+func TestCacheSetCloseLoaderPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy       CloseLoaderPolicy
+		closeWaits   bool
+		waiterResult interface{}
+		waiterErr    error
+		ownerErr     error
+	}{
+		{policy: FinishPending, waiterResult: "value"},
+		{policy: CancelPending, waiterErr: ErrClosed, ownerErr: ErrClosed},
+		{policy: DrainPending, closeWaits: true, waiterResult: "value"},
+	} {
+		cache := NewCache()
+		cache.SetCloseLoaderPolicy(test.policy)
+
+		loading := make(chan struct{})
+		release := make(chan struct{})
+		loader := func(key string) (interface{}, error) {
+			close(loading)
+			<-release
+			return "value", nil
+		}
+		type result struct {
+			value interface{}
+			err   error
+		}
+		owner := make(chan result, 1)
+		go func() {
+			value, err := cache.GetOrSet("key", loader)
+			owner <- result{value, err}
+		}()
+		<-loading
+		waiter := make(chan result, 1)
+		go func() {
+			value, err := cache.GetOrSet("key", loader)
+			waiter <- result{value, err}
+		}()
+		<-time.After(10 * time.Millisecond)
+
+		closed := make(chan struct{})
+		go func() {
+			cache.Close()
+			close(closed)
+		}()
+		if test.policy == CancelPending {
+			select {
+			case waited := <-waiter:
+				assert.Equal(t, ErrClosed, waited.err, "Expected the waiter to give up on Close")
+			case <-time.After(time.Second):
+				t.Fatal("Expected the waiter to return on Close")
+			}
+		}
+		select {
+		case <-closed:
+			assert.Equal(t, false, test.closeWaits, "Expected Close to wait for the load under %v", test.policy)
+		case <-time.After(20 * time.Millisecond):
+			assert.Equal(t, true, test.closeWaits, "Expected Close to not wait for the load under %v", test.policy)
+		}
+
+		close(release)
+		<-closed
+		owned := <-owner
+		assert.Equal(t, test.ownerErr, owned.err)
+		if test.policy != CancelPending {
+			waited := <-waiter
+			assert.Equal(t, test.waiterResult, waited.value, "Expected the waiter to receive the loaded value")
+			assert.Equal(t, test.waiterErr, waited.err)
+		}
+		assert.Equal(t, 0, cache.Count(), "Expected the loaded value to not be cached after Close")
+	}
+}
+
+func TestCacheGetOrSetReturnsCachedError(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	errInvalid := errors.New("invalid id")
+	var calls int32
+	loader := func(key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "loaded", nil
+	}
+
+	cache.SetError("id", errInvalid, time.Minute)
+	for i := 0; i < 3; i++ {
+		value, err := cache.GetOrSet("id", loader)
+		assert.Nil(t, value)
+		assert.Equal(t, errInvalid, err, "Expected the cached error to be returned")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "Expected the loader not to run while the error is cached")
+
+	cached, found := cache.Get("id")
+	assert.True(t, found)
+	assert.True(t, errors.Is(cached.(error), errInvalid), "Expected Get to return the CachedError")
+
+	ageItem(cache, "id", time.Minute)
+	value, err := cache.GetOrSet("id", loader)
+	assert.NoError(t, err)
+	assert.Equal(t, "loaded", value, "Expected the loader to run once the cached error expired")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// a loader caches an error by returning it as the value
+	value, err = cache.GetOrSet("other", func(key string) (interface{}, error) {
+		return &CachedError{Err: errInvalid}, nil
+	})
+	assert.Nil(t, value)
+	assert.Equal(t, errInvalid, err)
+	_, err = cache.GetOrSet("other", loader)
+	assert.Equal(t, errInvalid, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCacheGetOrSetRecoversLoaderPanic(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var mutex sync.Mutex
+	recovered := make(map[string]interface{})
+	cache.SetLoaderPanicHandler(func(key string, value interface{}) {
+		mutex.Lock()
+		recovered[key] = value
+		mutex.Unlock()
+	})
+	cache.SetGracePeriod(time.Minute)
+	cache.SetWithTTL("stale", "old", 20*time.Millisecond)
+	<-time.After(40 * time.Millisecond)
+
+	panicking := func(key string) (interface{}, error) {
+		panic("bug in " + key)
+	}
+	value, err := cache.GetOrSet("stale", panicking)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", value, "Expected the stale value to be served when the loader panics")
+
+	value, err = cache.GetOrSet("missing", panicking)
+	assert.Nil(t, value)
+	assert.True(t, errors.Is(err, ErrLoaderPanic), "Expected ErrLoaderPanic without a stale value")
+
+	mutex.Lock()
+	assert.Equal(t, map[string]interface{}{"stale": "bug in stale", "missing": "bug in missing"}, recovered)
+	mutex.Unlock()
+	_, found := cache.Get("missing")
+	assert.False(t, found, "Expected nothing to be cached for a panicking loader")
+
+	loader := func(key string) (interface{}, error) {
+		return "loaded", nil
+	}
+	for _, key := range []string{"stale", "missing"} {
+		value, err = cache.GetOrSet(key, loader)
+		assert.NoError(t, err)
+		assert.Equal(t, "loaded", value, "Expected the single flight entry to be released after the panic")
+	}
+}
+
+func TestCacheGetOrSetManyRecoversLoaderPanic(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var mutex sync.Mutex
+	var recovered []string
+	cache.SetLoaderPanicHandler(func(key string, value interface{}) {
+		mutex.Lock()
+		recovered = append(recovered, key)
+		mutex.Unlock()
+	})
+	cache.SetSingleFlightTimeout(time.Second)
+
+	values, err := cache.GetOrSetMany([]string{"a", "b"}, func(keys []string) (map[string]interface{}, error) {
+		panic("bug")
+	})
+	assert.Nil(t, values)
+	assert.Equal(t, ErrLoaderPanic, err, "Expected the panic of the bulk loader to be returned as an error")
+	mutex.Lock()
+	assert.ElementsMatch(t, []string{"a", "b"}, recovered)
+	mutex.Unlock()
+
+	value, err := cache.GetOrSet("a", func(key string) (interface{}, error) {
+		return "loaded", nil
+	})
+	assert.NoError(t, err, "Expected the single flight entry to be released after the panic")
+	assert.Equal(t, "loaded", value)
+}
+
+func TestCacheGetOrSetFinishingAfterCloseInStrictMode(t *testing.T) {
+	cache := NewCache()
+	cache.SetStrictMode(true)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	type result struct {
+		value interface{}
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		value, err := cache.GetOrSet("key", func(key string) (interface{}, error) {
+			close(started)
+			<-proceed
+			return "loaded", nil
+		})
+		results <- result{value, err}
+	}()
+	<-started
+	cache.Close()
+	close(proceed)
+
+	loaded := <-results
+	assert.NoError(t, loaded.err, "Expected the finished load to be returned without panicking")
+	assert.Equal(t, "loaded", loaded.value)
+	assert.Equal(t, 0, cache.RawCount(), "Expected the value not to be cached after Close")
+	cache.mutex.RLock()
+	assert.Empty(t, cache.loaderCalls, "Expected the single flight entry to be released")
+	cache.mutex.RUnlock()
+}
+
+func TestCacheGetOrSetCtxCancelledLeader(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	type ctxKey struct{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		close(started)
+		<-release
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return ctx.Value(ctxKey{}), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	leader := make(chan error)
+	go func() {
+		_, err := cache.GetOrSetCtx(ctx, "key", loader)
+		leader <- err
+	}()
+	<-started
+
+	sibling := make(chan interface{})
+	go func() {
+		data, err := cache.GetOrSetCtx(context.Background(), "key", loader)
+		assert.Nil(t, err, "Expected the sibling not to fail with the context of the leader")
+		sibling <- data
+	}()
+	cancel()
+	select {
+	case err := <-leader:
+		assert.Equal(t, context.Canceled, err, "Expected the leader to return its context error")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cancelled leader to return promptly")
+	}
+
+	close(release)
+	assert.Equal(t, "value", <-sibling, "Expected the load to continue for the sibling with the values of the context")
+}
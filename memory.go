@@ -0,0 +1,154 @@
+package ttlcache
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMemoryCheckInterval is how often the heap size is checked against the memory limit by default.
+	defaultMemoryCheckInterval = time.Second
+	// memoryLowWaterRatio is the fraction of the memory limit the cache evicts down to once it is exceeded.
+	memoryLowWaterRatio = 0.9
+)
+
+// heapAlloc reports the bytes of allocated heap objects of the process.
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// SetMemoryLimit makes the cache evict items in the order of the eviction policy once the heap of the process
+// exceeds the given number of bytes, until it is estimated to be below 90% of the limit again.
+// This is a heuristic: the heap is measured for the whole process rather than the cache, and the number of
+// evicted items assumes the heap is proportional to the number of items. Reading the heap size is expensive,
+// so it is checked periodically in the background, see SetMemoryCheckInterval. A value of 0 disables the limit.
+func (cache *Cache) SetMemoryLimit(bytes uint64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.memoryLimit = bytes
+	if bytes > 0 && cache.memoryMonitorStop == nil && !cache.isShutDown {
+		cache.memoryMonitorStop = make(chan struct{})
+		cache.backgroundWorkers.Add(1)
+		go cache.monitorMemory(cache.memoryMonitorStop)
+	} else if bytes == 0 && cache.memoryMonitorStop != nil {
+		close(cache.memoryMonitorStop)
+		cache.memoryMonitorStop = nil
+	}
+}
+
+// SetMemoryCheckInterval sets how often the heap size is checked against the memory limit, one second by default.
+func (cache *Cache) SetMemoryCheckInterval(interval time.Duration) {
+	cache.mutex.Lock()
+	cache.memoryCheckInterval = interval
+	cache.mutex.Unlock()
+}
+
+func (cache *Cache) monitorMemory(stop chan struct{}) {
+	defer cache.backgroundWorkers.Done()
+	for {
+		cache.mutex.RLock()
+		interval := cache.memoryCheckInterval
+		cache.mutex.RUnlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			cache.enforceMemoryLimit()
+		}
+	}
+}
+
+// enforceMemoryLimit evicts the share of the items that is estimated to bring
+// the heap back below the low water mark.
+func (cache *Cache) enforceMemoryLimit() {
+	cache.mutex.Lock()
+	limit := cache.memoryLimit
+	measure := cache.memoryStats
+	cache.mutex.Unlock()
+	if limit == 0 {
+		return
+	}
+
+	heap := measure()
+	if heap <= limit {
+		return
+	}
+	lowWater := uint64(float64(limit) * memoryLowWaterRatio)
+
+	cache.mutex.Lock()
+	count := int(float64(cache.items.Len())*float64(heap-lowWater)/float64(heap) + 0.5)
+	evicted := cache.evict(count)
+	cache.checkSize()
+	cache.mutex.Unlock()
+	cache.notifyEvicted(evicted)
+}
+
+// SetMaxValueSize rejects values larger than the given size, as measured by the size function, see SetSizeFunc.
+// Set returns ErrValueTooLarge for them, other functions that store values skip them, and all rejected values
+// are counted in the metrics. Without a size function or with a size of 0 values of any size are stored.
+func (cache *Cache) SetMaxValueSize(size int64) {
+	cache.mutex.Lock()
+	cache.maxValueSize = size
+	cache.mutex.Unlock()
+}
+
+// SetSizeFunc sets the function that measures the size of values for SetMaxValueSize and EstimatedBytes,
+// typically in bytes. It is called while the cache is locked and must not use the cache. Setting it measures
+// the items already stored.
+func (cache *Cache) SetSizeFunc(sizeFunc func(value interface{}) int64) {
+	cache.mutex.Lock()
+	cache.sizeFunc = sizeFunc
+	cache.accountAll()
+	cache.mutex.Unlock()
+}
+
+// SetRejectOnPressure sheds load under memory pressure: new items are not stored while checkPressure returns true,
+// so the cache does not push the process over its memory budget. It could consult runtime.MemStats, which is
+// expensive to read, or an external signal. The Set functions return ErrMemoryPressure for rejected items, the
+// other functions inserting items skip them, all rejections are counted in the metrics. Existing items can
+// still be replaced and looked up. The check is called for every new item while the cache is locked, it must
+// be fast and must not use the cache. A nil check disables it.
+func (cache *Cache) SetRejectOnPressure(checkPressure func() bool) {
+	cache.mutex.Lock()
+	cache.pressureCheck = checkPressure
+	cache.mutex.Unlock()
+}
+
+// underPressure tells whether a new item must be rejected because of memory pressure, and counts the rejection.
+// Must be called with the lock held.
+func (cache *Cache) underPressure() bool {
+	if cache.pressureCheck == nil {
+		return false
+	}
+	pressure := false
+	if !cache.mutex.guard(func() { pressure = cache.pressureCheck() }) {
+		return true
+	}
+	if pressure {
+		atomic.AddUint64(&cache.pressureRejections, 1)
+	}
+	return pressure
+}
+
+// oversized reports whether the value exceeds the maximum value size, counting it if it does.
+// Must be called with the lock held.
+func (cache *Cache) oversized(value interface{}) bool {
+	if cache.maxValueSize <= 0 || cache.sizeFunc == nil {
+		return false
+	}
+	var size int64
+	if !cache.mutex.guard(func() { size = cache.sizeFunc(value) }) {
+		return true
+	}
+	if size <= cache.maxValueSize {
+		return false
+	}
+	atomic.AddUint64(&cache.oversizedValues, 1)
+	return true
+}
@@ -0,0 +1,107 @@
+package ttlcache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSetMemoryLimitEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var heap uint64 = 500
+	cache.memoryStats = func() uint64 {
+		return atomic.LoadUint64(&heap)
+	}
+	cache.SetMemoryCheckInterval(10 * time.Millisecond)
+	cache.SetMemoryLimit(1000)
+
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), "value")
+		<-time.After(time.Millisecond)
+	}
+	cache.Get("key_0")
+	cache.Get("key_1")
+
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, 10, cache.Count(), "Expected no eviction below the memory limit")
+
+	atomic.StoreUint64(&heap, 2000)
+	for start := time.Now(); cache.Count() == 10 && time.Since(start) < time.Second; {
+		<-time.After(time.Millisecond)
+	}
+	atomic.StoreUint64(&heap, 500)
+
+	count := cache.Count()
+	assert.True(t, count > 0 && count < 10, "Expected part of the items to be evicted")
+	_, exists := cache.Get("key_0")
+	assert.Equal(t, true, exists, "Expected recently used item to survive")
+	_, exists = cache.Get("key_1")
+	assert.Equal(t, true, exists, "Expected recently used item to survive")
+	_, exists = cache.Get("key_2")
+	assert.Equal(t, false, exists, "Expected least recently used item to be evicted")
+}
+
+func TestCacheSetMaxValueSize(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetMaxValueSize(4)
+	assert.Nil(t, cache.Set("large", "without size func"), "Expected the guard to be inactive without a size func")
+
+	cache.SetSizeFunc(func(value interface{}) int64 {
+		return int64(len(value.(string)))
+	})
+	assert.Equal(t, ErrValueTooLarge, cache.Set("oversized", "too large"), "Expected the oversized value to be rejected")
+	_, exists := cache.Get("oversized")
+	assert.Equal(t, false, exists, "Expected the oversized value to not be stored")
+	assert.Equal(t, false, cache.SetIf("oversized", "too large", func(interface{}, bool) bool { return true }))
+
+	assert.Nil(t, cache.Set("small", "tiny"), "Expected a value within the limit to be accepted")
+	value, exists := cache.Get("small")
+	assert.Equal(t, true, exists)
+	assert.Equal(t, "tiny", value)
+	assert.Equal(t, uint64(2), cache.GetMetrics().OversizedValues, "Expected the rejected values to be counted")
+}
+
+func TestCacheSetMaxValueSizeSkipsAppend(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetMaxValueSize(2)
+	cache.SetSizeFunc(func(value interface{}) int64 {
+		return int64(len(value.([]interface{})))
+	})
+	cache.Append("events", 1)
+	cache.Append("events", 2)
+	cache.Append("events", 3)
+	values, _ := cache.GetSlice("events")
+	assert.Equal(t, []interface{}{1, 2}, values, "Expected the append exceeding the limit to be skipped")
+	assert.Equal(t, uint64(1), cache.GetMetrics().OversizedValues, "Expected the skipped append to be counted")
+}
+
+func TestCacheSetRejectOnPressure(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var pressure int32
+	cache.SetRejectOnPressure(func() bool { return atomic.LoadInt32(&pressure) == 1 })
+	assert.Nil(t, cache.Set("existing", "value"))
+
+	atomic.StoreInt32(&pressure, 1)
+	assert.Equal(t, ErrMemoryPressure, cache.Set("new", "value"), "Expected the new item to be rejected under pressure")
+	_, exists := cache.Get("new")
+	assert.Equal(t, false, exists, "Expected the rejected item to not be stored")
+	assert.Nil(t, cache.Set("existing", "replaced"), "Expected existing items to be replaced under pressure")
+	value, _ := cache.Get("existing")
+	assert.Equal(t, "replaced", value, "Expected reads to be unaffected")
+	assert.Equal(t, uint64(1), cache.GetMetrics().PressureRejections, "Expected the rejection to be counted")
+
+	atomic.StoreInt32(&pressure, 0)
+	assert.Nil(t, cache.Set("new", "value"), "Expected the new item to be stored without pressure")
+	assert.Equal(t, 2, cache.Count())
+}
@@ -0,0 +1,170 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are counters describing the operation of the cache since it was created.
+type Metrics struct {
+	// Hits and Misses are the number of lookups that found an item and that did not.
+	Hits   uint64
+	Misses uint64
+	// Items is the number of items in the cache, including expired items that were not removed yet.
+	Items int
+	// RejectedKeys is the number of items that were not stored because the key validator rejected their key.
+	RejectedKeys uint64
+	// OversizedValues is the number of values that were not stored because they exceeded the maximum value size.
+	OversizedValues uint64
+	// PressureRejections is the number of new items that were not stored because of memory pressure,
+	// see SetRejectOnPressure.
+	PressureRejections uint64
+	// FullRejections is the number of new items that were not stored because the cache was full, see SetFullPolicy.
+	FullRejections uint64
+	// Evictions is the number of items that were evicted to make room, see SetMaxItems and SetMemoryLimit.
+	Evictions uint64
+	// SlowCallbacks is the number of callback calls that exceeded the callback timeout, see SetCallbackTimeout.
+	SlowCallbacks uint64
+	// ExpiredBacklog is the number of items that are due for expiration, but were not expired by the sweeper yet.
+	ExpiredBacklog int
+	// OldestExpiredAge is how long ago the oldest item of the expired backlog expired.
+	OldestExpiredAge time.Duration
+	// LoaderCalls is the number of loader calls by GetOrSet, GetOrDefault and their variants.
+	LoaderCalls uint64
+	// LoaderErrors is the number of loader calls that returned an error.
+	LoaderErrors uint64
+	// LoaderLatency is the total duration of all loader calls, divide it by LoaderCalls for the average.
+	LoaderLatency time.Duration
+	// LoaderLatencyMin is the duration of the fastest loader call.
+	LoaderLatencyMin time.Duration
+	// LoaderLatencyMax is the duration of the slowest loader call.
+	LoaderLatencyMax time.Duration
+	// GetTimings, SetTimings and RemoveTimings are the lock timings of the operations, see EnableOpTimings.
+	GetTimings    OpTimings
+	SetTimings    OpTimings
+	RemoveTimings OpTimings
+}
+
+// GetMetrics returns a snapshot of the metrics of the cache.
+func (cache *Cache) GetMetrics() Metrics {
+	cache.mutex.RLock()
+	backlog, oldest := cache.expiredBacklog()
+	items := cache.items.Len()
+	cache.mutex.RUnlock()
+
+	metrics := Metrics{
+		Hits:               atomic.LoadUint64(&cache.hits),
+		Misses:             atomic.LoadUint64(&cache.misses),
+		Items:              items,
+		RejectedKeys:       atomic.LoadUint64(&cache.rejectedKeys),
+		OversizedValues:    atomic.LoadUint64(&cache.oversizedValues),
+		PressureRejections: atomic.LoadUint64(&cache.pressureRejections),
+		FullRejections:     atomic.LoadUint64(&cache.fullRejections),
+		Evictions:          atomic.LoadUint64(&cache.evictions),
+		SlowCallbacks:      atomic.LoadUint64(&cache.slowCallbacks),
+		ExpiredBacklog:     backlog,
+		LoaderCalls:        atomic.LoadUint64(&cache.loaderInvocations),
+		LoaderErrors:       atomic.LoadUint64(&cache.loaderErrors),
+		LoaderLatency:      time.Duration(atomic.LoadInt64(&cache.loaderLatency)),
+		LoaderLatencyMin:   time.Duration(atomic.LoadInt64(&cache.loaderLatencyMin)),
+		LoaderLatencyMax:   time.Duration(atomic.LoadInt64(&cache.loaderLatencyMax)),
+		GetTimings:         cache.opTimings(opGet),
+		SetTimings:         cache.opTimings(opSet),
+		RemoveTimings:      cache.opTimings(opRemove),
+	}
+	if backlog > 0 {
+		metrics.OldestExpiredAge = time.Since(oldest)
+	}
+	return metrics
+}
+
+// SetMetricsCallback makes a background goroutine call the callback with the metrics every interval, to push them
+// to a monitoring system. The goroutine stops on Close. An interval of 0 stops reporting.
+func (cache *Cache) SetMetricsCallback(interval time.Duration, callback func(metrics Metrics)) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.metricsReportStop != nil {
+		close(cache.metricsReportStop)
+		cache.metricsReportStop = nil
+	}
+	if interval > 0 && callback != nil && !cache.isShutDown {
+		cache.metricsReportStop = make(chan struct{})
+		cache.backgroundWorkers.Add(1)
+		go cache.reportMetrics(cache.metricsReportStop, interval, callback)
+	}
+}
+
+func (cache *Cache) reportMetrics(stop chan struct{}, interval time.Duration, callback func(metrics Metrics)) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			callback(cache.GetMetrics())
+		}
+	}
+}
+
+// SetBacklogCallback sets a callback that will be called with the expired backlog when it grows above the
+// threshold, which signals the sweeper falls behind. It is called once per crossing, the backlog has to drop to
+// the threshold before it can be called again. The backlog is checked every time the sweeper wakes up, and before
+// and after items are expired, also by RunCleanup, see also GetMetrics. The callback is called while the cache is
+// locked and must not use the cache.
+func (cache *Cache) SetBacklogCallback(threshold int, callback func(backlog int)) {
+	cache.mutex.Lock()
+	cache.backlogThreshold = threshold
+	cache.backlogCallback = callback
+	cache.aboveBacklogThreshold = false
+	cache.mutex.Unlock()
+}
+
+// checkBacklog calls the backlog callback when the backlog crossed the threshold since the last check.
+// Must be called with the lock held.
+func (cache *Cache) checkBacklog() {
+	if cache.backlogCallback == nil {
+		return
+	}
+	backlog, _ := cache.expiredBacklog()
+	if !cache.aboveBacklogThreshold && backlog > cache.backlogThreshold {
+		cache.aboveBacklogThreshold = true
+		cache.mutex.guard(func() { cache.backlogCallback(backlog) })
+	} else if cache.aboveBacklogThreshold && backlog <= cache.backlogThreshold {
+		cache.aboveBacklogThreshold = false
+	}
+}
+
+// expiredBacklog counts the items that are due for expiration, and returns the time the oldest of them expired at.
+// Must be called with at least the read lock held.
+func (cache *Cache) expiredBacklog() (int, time.Time) {
+	count := cache.priorityQueue.countDue(func(item *item) bool {
+		return item.expiredFor(cache.gracePeriod, cache.now())
+	})
+	if count == 0 {
+		return 0, time.Time{}
+	}
+	return count, cache.priorityQueue.items[0].dueAt()
+}
+
+// recordLoad records a loader call in the metrics.
+func (cache *Cache) recordLoad(latency time.Duration, err error) {
+	atomic.AddUint64(&cache.loaderInvocations, 1)
+	if err != nil {
+		atomic.AddUint64(&cache.loaderErrors, 1)
+	}
+	atomic.AddInt64(&cache.loaderLatency, int64(latency))
+	for {
+		current := atomic.LoadInt64(&cache.loaderLatencyMin)
+		if (current != 0 && current <= int64(latency)) || atomic.CompareAndSwapInt64(&cache.loaderLatencyMin, current, int64(latency)) {
+			break
+		}
+	}
+	for {
+		current := atomic.LoadInt64(&cache.loaderLatencyMax)
+		if current >= int64(latency) || atomic.CompareAndSwapInt64(&cache.loaderLatencyMax, current, int64(latency)) {
+			break
+		}
+	}
+}
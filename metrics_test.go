@@ -0,0 +1,212 @@
+package ttlcache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheExpiredBacklog(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	backlogs := make(chan int, 10)
+	cache.SetBacklogCallback(5, func(backlog int) {
+		backlogs <- backlog
+	})
+	// the slow check stalls the sweeper, so the expired items pile up
+	cache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		time.Sleep(5 * time.Millisecond)
+		return true
+	})
+	for i := 0; i < 10; i++ {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), "value", time.Hour)
+	}
+	cache.Set("permanent", "value")
+	// the items expire at the same time, so they are all due once the sweeper wakes
+	expireAt := time.Now().Add(10 * time.Millisecond)
+	cache.mutex.Lock()
+	for i := 0; i < 10; i++ {
+		item, _ := cache.items.get(fmt.Sprintf("key_%d", i))
+		item.expireAt = expireAt
+		cache.priorityQueue.update(item)
+	}
+	cache.mutex.Unlock()
+	cache.notifyExpiration()
+
+	select {
+	case backlog := <-backlogs:
+		assert.Equal(t, 10, backlog, "Expected all expired items in the backlog")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the backlog callback to fire")
+	}
+
+	<-time.After(100 * time.Millisecond)
+	metrics := cache.GetMetrics()
+	assert.Equal(t, 0, metrics.ExpiredBacklog, "Expected the sweeper to catch up")
+	assert.Equal(t, time.Duration(0), metrics.OldestExpiredAge, "Expected no age without backlog")
+}
+
+func TestCacheBacklogCallbackOncePerCrossing(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	var backlogs []int
+	cache.SetBacklogCallback(5, func(backlog int) {
+		backlogs = append(backlogs, backlog)
+	})
+	fill := func() {
+		for i := 0; i < 10; i++ {
+			cache.SetWithTTL(fmt.Sprintf("key_%d", i), "value", time.Nanosecond)
+		}
+		<-time.After(time.Millisecond)
+	}
+
+	fill()
+	cache.mutex.Lock()
+	cache.checkBacklog()
+	cache.checkBacklog()
+	cache.mutex.Unlock()
+	assert.Equal(t, []int{10}, backlogs, "Expected one call while the backlog stays above the threshold")
+
+	assert.Equal(t, 10, cache.RunCleanup())
+	assert.Equal(t, []int{10}, backlogs, "Expected no call for the backlog that was reported already")
+
+	fill()
+	assert.Equal(t, 10, cache.RunCleanup())
+	assert.Equal(t, []int{10, 10}, backlogs, "Expected a call once the backlog crossed the threshold again")
+}
+
+func TestCacheExpiredBacklogMetrics(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetGracePeriod(time.Hour)
+	for i := 0; i < 10; i++ {
+		cache.SetWithTTL(fmt.Sprintf("key_%d", i), "value", time.Millisecond)
+	}
+	cache.SetWithTTL("live", "value", time.Hour)
+
+	<-time.After(20 * time.Millisecond)
+	cache.mutex.Lock()
+	cache.gracePeriod = 0
+	cache.mutex.Unlock()
+
+	metrics := cache.GetMetrics()
+	assert.Equal(t, 10, metrics.ExpiredBacklog, "Expected the unswept expired items")
+	assert.True(t, metrics.OldestExpiredAge >= 10*time.Millisecond, "Expected the age of the oldest expired item")
+}
+
+func TestCacheExpiredBacklogMetricsCountsIdleItems(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	cache.SetIdleTimeout(time.Millisecond)
+	cache.SetWithTTL("idle", "value", time.Hour)
+
+	<-time.After(20 * time.Millisecond)
+	metrics := cache.GetMetrics()
+	assert.Equal(t, 1, metrics.ExpiredBacklog, "Expected the idle item")
+	assert.True(t, metrics.OldestExpiredAge >= 10*time.Millisecond, "Expected the age since the item went idle")
+}
+
+func TestCacheLoaderMetrics(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	for i := 0; i < 4; i++ {
+		cache.GetOrSet(fmt.Sprintf("key_%d", i), func(key string) (interface{}, error) {
+			time.Sleep(10 * time.Millisecond)
+			if key == "key_1" || key == "key_3" {
+				return nil, errors.New("failed")
+			}
+			return "value", nil
+		})
+	}
+	cache.GetOrDefault("key_0", func(string) (interface{}, error) {
+		t.Error("Expected no loader call for a cached item")
+		return nil, nil
+	})
+
+	metrics := cache.GetMetrics()
+	assert.Equal(t, uint64(4), metrics.LoaderCalls, "Expected every loader call to be counted")
+	assert.Equal(t, uint64(2), metrics.LoaderErrors, "Expected the failed loader calls to be counted")
+	average := metrics.LoaderLatency / time.Duration(metrics.LoaderCalls)
+	assert.True(t, average >= 10*time.Millisecond && average < 50*time.Millisecond, "Expected an average latency around the loader latency")
+	assert.True(t, metrics.LoaderLatencyMin >= 10*time.Millisecond, "Expected the minimum latency to be recorded")
+	assert.True(t, metrics.LoaderLatencyMax >= metrics.LoaderLatencyMin, "Expected the maximum latency to be recorded")
+}
+
+func TestCacheSetCallbackTimeoutKeepsSweeping(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetCallbackTimeout(10 * time.Millisecond)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	cache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		if key == "slow" {
+			<-release
+			close(finished)
+		}
+		return true
+	})
+	expireAt := time.Now().Add(10 * time.Millisecond)
+	cache.SetWithExpiryTime("slow", "value", expireAt)
+	for i := 0; i < 10; i++ {
+		cache.SetWithExpiryTime(fmt.Sprintf("key_%d", i), "value", expireAt)
+	}
+
+	start := time.Now()
+	for cache.RawCount() > 0 && time.Since(start) < time.Second {
+		<-time.After(time.Millisecond)
+	}
+	assert.Equal(t, 0, cache.RawCount(), "Expected the sweep to progress past the slow callback")
+	assert.Equal(t, uint64(1), cache.GetMetrics().SlowCallbacks, "Expected the slow callback to be counted")
+
+	// let the slow callback finish before the cache is closed
+	close(release)
+	<-finished
+}
+
+func TestCacheSetMetricsCallback(t *testing.T) {
+	cache := NewCache()
+
+	type report struct {
+		at      time.Time
+		metrics Metrics
+	}
+	reports := make(chan report, 10)
+	start := time.Now()
+	cache.SetMetricsCallback(20*time.Millisecond, func(metrics Metrics) {
+		select {
+		case reports <- report{time.Now(), metrics}:
+		default:
+		}
+	})
+
+	var previous report
+	for i := 0; i < 3; i++ {
+		cache.GetOrSet(fmt.Sprintf("key_%d", i), func(key string) (interface{}, error) {
+			return key, nil
+		})
+		select {
+		case current := <-reports:
+			assert.InDelta(t, float64(time.Duration(i+1)*20*time.Millisecond), float64(current.at.Sub(start)),
+				float64(15*time.Millisecond), "Expected the reports on schedule")
+			assert.True(t, current.metrics.LoaderCalls > previous.metrics.LoaderCalls, "Expected the counters to advance")
+			previous = current
+		case <-time.After(time.Second):
+			t.Fatal("Expected a report")
+		}
+	}
+
+	cache.SetMetricsCallback(0, nil)
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, 0, len(reports), "Expected no reports once disabled")
+	cache.SetMetricsCallback(time.Millisecond, func(Metrics) {})
+	cache.Close()
+}
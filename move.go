@@ -0,0 +1,66 @@
+package ttlcache
+
+// cacheIDs numbers the caches, see Cache.id
+var cacheIDs uint64
+
+// Move is a thread-safe way to move a live item from one cache to another, for example to demote it from a hot
+// to a cold tier. The item is removed from the source and stored in the destination with its remaining TTL in
+// one step: both caches are locked while it moves, so no lookup sees it in both or in neither. A live item of
+// the key in the destination is replaced. The remove callback of the source and the new item callback of the
+// destination are called. Moves are not written through to the stores. It returns false, leaving both caches
+// unchanged, when the item is missing or expired, the destination rejects it, or either cache is closed.
+func Move(from, to *Cache, key string) bool {
+	if from == to {
+		return false
+	}
+	first, second := from, to
+	if second.id < first.id {
+		first, second = second, first
+	}
+	first.mutex.Lock()
+	second.mutex.Lock()
+
+	source, exists := from.items.get(key)
+	moved, replaced := to.items.get(key)
+	replaced = replaced && !moved.expiredAt(to.now())
+	if from.isShutDown || to.isShutDown || !exists || source.expiredAt(from.now()) || to.validateKey(key) != nil ||
+		!to.acceptSet(key, source.data) || to.oversized(source.data) || (!replaced && to.rejectInsert(key) != nil) {
+		unlockBoth(first, second)
+		return false
+	}
+	from.deleteItem(source)
+	from.checkSize()
+	from.publish(EventRemoved, key, source.data)
+
+	var evicted []*item
+	if replaced {
+		to.replaceValue(moved, source.data)
+	} else {
+		moved, evicted = to.insertItem(key, source.data, source.ttl)
+	}
+	moved.ttl = source.ttl
+	moved.fixedExpiry = source.fixedExpiry
+	moved.expireAt = source.expireAt
+	to.priorityQueue.update(moved)
+	if !moved.expireAt.IsZero() {
+		to.startSweeper()
+	}
+	unlockBoth(first, second)
+
+	if from.removeCallback != nil {
+		from.removeCallback(key, source.data)
+	}
+	to.notifyEvicted(evicted)
+	if !replaced && to.newItemCallback != nil {
+		to.newItemCallback(key, source.data)
+	}
+	to.notifyExpiration()
+	return true
+}
+
+// unlockBoth releases the locks of both caches, the first one also when releasing the second raises the panic of
+// a callback.
+func unlockBoth(first, second *Cache) {
+	defer first.mutex.Unlock()
+	second.mutex.Unlock()
+}
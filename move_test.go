@@ -0,0 +1,79 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMove(t *testing.T) {
+	hot := NewCache()
+	defer hot.Close()
+	cold := NewCache()
+	defer cold.Close()
+
+	removed := make(chan string, 1)
+	hot.SetRemoveCallback(func(key string, value interface{}) { removed <- key })
+	added := make(chan string, 1)
+	cold.SetNewItemCallback(func(key string, value interface{}) { added <- key })
+
+	hot.SetWithTTL("key", "value", time.Minute)
+	before, _ := hot.GetItemInfo("key")
+	<-time.After(5 * time.Millisecond)
+
+	assert.Equal(t, true, Move(hot, cold, "key"), "Expected the item to be moved")
+	_, found := hot.Get("key")
+	assert.Equal(t, false, found, "Expected the item to leave the source")
+	after, found := cold.GetItemInfo("key")
+	assert.Equal(t, true, found, "Expected the item to enter the destination")
+	assert.Equal(t, before.ExpiresAt, after.ExpiresAt, "Expected the item to keep its remaining TTL")
+	value, _ := cold.Get("key")
+	assert.Equal(t, "value", value)
+	assert.Equal(t, "key", <-removed, "Expected the remove callback of the source")
+	assert.Equal(t, "key", <-added, "Expected the new item callback of the destination")
+
+	assert.Equal(t, false, Move(hot, cold, "key"), "Expected no move of a missing item")
+	assert.Equal(t, true, Move(cold, hot, "key"), "Expected the item to move back")
+	_, found = hot.Get("key")
+	assert.Equal(t, true, found, "Expected the item back in the source")
+}
+
+func TestMoveUnderPressureKeepsSource(t *testing.T) {
+	hot := NewCache()
+	defer hot.Close()
+	cold := NewCache()
+	defer cold.Close()
+
+	cold.SetRejectOnPressure(func() bool { return true })
+	hot.Set("key", "value")
+
+	assert.Equal(t, false, Move(hot, cold, "key"), "Expected the destination to reject the item")
+	value, found := hot.Get("key")
+	assert.Equal(t, true, found, "Expected the item to stay in the source")
+	assert.Equal(t, "value", value)
+	assert.Equal(t, 0, cold.Count())
+}
+
+func TestMoveIntoFullCacheKeepsSource(t *testing.T) {
+	hot := NewCache()
+	defer hot.Close()
+	cold := NewCache()
+	defer cold.Close()
+
+	cold.SetMaxItems(1)
+	cold.SetFullPolicy(Reject)
+	cold.Set("other", "value")
+	hot.Set("key", "value")
+
+	assert.Equal(t, false, Move(hot, cold, "key"), "Expected the full destination to reject the item")
+	_, found := hot.Get("key")
+	assert.Equal(t, true, found, "Expected the item to stay in the source")
+	_, found = cold.Get("key")
+	assert.Equal(t, false, found, "Expected the item not to enter the destination")
+
+	hot.Set("other", "new")
+	assert.Equal(t, true, Move(hot, cold, "other"), "Expected a full destination to accept a replacement")
+	value, _ := cold.Get("other")
+	assert.Equal(t, "new", value)
+}
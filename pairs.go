@@ -0,0 +1,55 @@
+package ttlcache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDuplicateKey is returned by SetPairs when a key occurs twice and the duplicate policy is DuplicateError.
+var ErrDuplicateKey = errors.New("ttlcache: duplicate key")
+
+// KV is a key and its value, see SetPairs.
+type KV struct {
+	Key   string
+	Value interface{}
+}
+
+// DuplicatePolicy decides which value SetPairs stores for a key that occurs more than once.
+type DuplicatePolicy int
+
+const (
+	// LastWins stores the last value of a key, it is the default.
+	LastWins DuplicatePolicy = iota
+	// FirstWins stores the first value of a key and ignores the later ones.
+	FirstWins
+	// DuplicateError stores nothing and returns ErrDuplicateKey.
+	DuplicateError
+)
+
+// SetPairs is a thread-safe way to store the pairs in order with the given ttl, like SetWithTTL does for each.
+// Unlike a map the pairs can hold a key more than once, the duplicate policy decides which of its values is
+// stored. The duplicates are resolved before anything is stored. It stops at the first pair that cannot be
+// stored and returns its error, keeping the pairs stored so far.
+func (cache *Cache) SetPairs(pairs []KV, ttl time.Duration, onDuplicate DuplicatePolicy) error {
+	index := make(map[string]int, len(pairs))
+	resolved := make([]KV, 0, len(pairs))
+	for _, pair := range pairs {
+		i, duplicate := index[pair.Key]
+		switch {
+		case !duplicate:
+			index[pair.Key] = len(resolved)
+			resolved = append(resolved, pair)
+		case onDuplicate == DuplicateError:
+			return ErrDuplicateKey
+		case onDuplicate == LastWins:
+			resolved[i].Value = pair.Value
+		}
+	}
+
+	for _, pair := range resolved {
+		if err := cache.SetWithTTL(pair.Key, pair.Value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSetPairs(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	pairs := []KV{{"key", "first"}, {"other", 1}, {"key", "last"}}
+
+	assert.Nil(t, cache.SetPairs(pairs, time.Minute, LastWins))
+	value, _ := cache.Get("key")
+	assert.Equal(t, "last", value, "Expected the last value to win")
+	value, _ = cache.Get("other")
+	assert.Equal(t, 1, value)
+
+	assert.Nil(t, cache.SetPairs(pairs, time.Minute, FirstWins))
+	value, _ = cache.Get("key")
+	assert.Equal(t, "first", value, "Expected the first value to win")
+
+	cache.Purge()
+	assert.Equal(t, ErrDuplicateKey, cache.SetPairs(pairs, time.Minute, DuplicateError))
+	assert.Equal(t, 0, cache.Count(), "Expected nothing to be stored for duplicate keys")
+	assert.Nil(t, cache.SetPairs(pairs[:2], time.Minute, DuplicateError), "Expected unique keys to be stored")
+	assert.Equal(t, 2, cache.Count())
+}
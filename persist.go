@@ -0,0 +1,296 @@
+package ttlcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Record is an item as written by Save and read by Load.
+type Record struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	// TTL is the remaining TTL of the item, ItemNotExpire for items that do not expire
+	// and ItemExpireWithGlobalTTL for items using a global TTL that was not set.
+	TTL time.Duration `json:"ttl"`
+}
+
+// Codec encodes and decodes the records of Save and Load.
+type Codec interface {
+	Encode(w io.Writer, records []Record) error
+	Decode(r io.Reader) ([]Record, error)
+}
+
+// GobCodec encodes records with encoding/gob, it is the default codec of Save and Load.
+// Values of types other than the predeclared ones must be registered with gob.Register.
+type GobCodec struct{}
+
+// Encode writes the records as gob.
+func (GobCodec) Encode(w io.Writer, records []Record) error {
+	return gob.NewEncoder(w).Encode(records)
+}
+
+// Decode reads gob encoded records.
+func (GobCodec) Decode(r io.Reader) ([]Record, error) {
+	var records []Record
+	err := gob.NewDecoder(r).Decode(&records)
+	return records, err
+}
+
+// JSONCodec encodes records as JSON, so they can be read by other tools.
+// Values must be serializable with encoding/json, and are decoded as the JSON native types:
+// numbers as float64, objects as map[string]interface{} and arrays as []interface{}.
+type JSONCodec struct{}
+
+// Encode writes the records as JSON.
+func (JSONCodec) Encode(w io.Writer, records []Record) error {
+	return json.NewEncoder(w).Encode(records)
+}
+
+// Decode reads JSON encoded records.
+func (JSONCodec) Decode(r io.Reader) ([]Record, error) {
+	var records []Record
+	err := json.NewDecoder(r).Decode(&records)
+	return records, err
+}
+
+// BinaryType is the type of all values of a cache encoded with the BinaryCodec.
+type BinaryType byte
+
+const (
+	// BinaryInt64 encodes int64 values as varints.
+	BinaryInt64 BinaryType = iota + 1
+	// BinaryUint64 encodes uint64 values as varints.
+	BinaryUint64
+	// BinaryFloat64 encodes float64 values in 8 bytes.
+	BinaryFloat64
+	// BinaryString encodes string values prefixed with their length.
+	BinaryString
+)
+
+func (t BinaryType) String() string {
+	switch t {
+	case BinaryInt64:
+		return "int64"
+	case BinaryUint64:
+		return "uint64"
+	case BinaryFloat64:
+		return "float64"
+	case BinaryString:
+		return "string"
+	}
+	return fmt.Sprintf("BinaryType(%d)", byte(t))
+}
+
+const (
+	// binaryVersion is written after the type of the values, so the format can evolve.
+	binaryVersion = 1
+	// maxBinaryStringLength bounds the length of keys and values read, so corrupt data does not allocate the heap.
+	maxBinaryStringLength = 1 << 30
+)
+
+// errBinaryFormat is returned when decoding data that was not written by the BinaryCodec.
+var errBinaryFormat = errors.New("ttlcache: invalid binary codec data")
+
+// BinaryCodec encodes records in a compact binary format for caches whose values all have the same primitive
+// type, declared by Type: every record is the length prefixed key, the value and the remaining TTL as varints.
+// It is much smaller and faster than the GobCodec for such caches. Encoding fails on the first value of another
+// type, and decoding fails on data encoded with another type.
+type BinaryCodec struct {
+	Type BinaryType
+}
+
+// Encode writes the records in the binary format.
+func (codec BinaryCodec) Encode(w io.Writer, records []Record) error {
+	writer := bufio.NewWriter(w)
+	if _, err := writer.Write([]byte{byte(codec.Type), binaryVersion}); err != nil {
+		return err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		writer.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	writeVarint := func(v int64) {
+		writer.Write(buf[:binary.PutVarint(buf[:], v)])
+	}
+	writeUvarint(uint64(len(records)))
+	for _, record := range records {
+		writeUvarint(uint64(len(record.Key)))
+		writer.WriteString(record.Key)
+		valid := false
+		switch value := record.Value.(type) {
+		case int64:
+			if valid = codec.Type == BinaryInt64; valid {
+				writeVarint(value)
+			}
+		case uint64:
+			if valid = codec.Type == BinaryUint64; valid {
+				writeUvarint(value)
+			}
+		case float64:
+			if valid = codec.Type == BinaryFloat64; valid {
+				binary.LittleEndian.PutUint64(buf[:8], math.Float64bits(value))
+				writer.Write(buf[:8])
+			}
+		case string:
+			if valid = codec.Type == BinaryString; valid {
+				writeUvarint(uint64(len(value)))
+				writer.WriteString(value)
+			}
+		}
+		if !valid {
+			return fmt.Errorf("ttlcache: value of key %q is a %T, the binary codec expects %v", record.Key, record.Value, codec.Type)
+		}
+		writeVarint(int64(record.TTL))
+	}
+	// the writer keeps the first error of the writes
+	return writer.Flush()
+}
+
+// Decode reads records written in the binary format.
+func (codec BinaryCodec) Decode(r io.Reader) ([]Record, error) {
+	reader := bufio.NewReader(r)
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	if BinaryType(header[0]) != codec.Type {
+		return nil, fmt.Errorf("ttlcache: binary codec data holds %v values, expected %v", BinaryType(header[0]), codec.Type)
+	}
+	if header[1] != binaryVersion {
+		return nil, errBinaryFormat
+	}
+	readString := func() (string, error) {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return "", err
+		}
+		if length > maxBinaryStringLength {
+			return "", errBinaryFormat
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		return string(data), err
+	}
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for i := uint64(0); i < count; i++ {
+		var record Record
+		if record.Key, err = readString(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch codec.Type {
+		case BinaryInt64:
+			record.Value, err = binary.ReadVarint(reader)
+		case BinaryUint64:
+			record.Value, err = binary.ReadUvarint(reader)
+		case BinaryFloat64:
+			var bits [8]byte
+			_, err = io.ReadFull(reader, bits[:])
+			record.Value = math.Float64frombits(binary.LittleEndian.Uint64(bits[:]))
+		case BinaryString:
+			record.Value, err = readString()
+		default:
+			return nil, errBinaryFormat
+		}
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		ttl, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		record.TTL = time.Duration(ttl)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// unexpectedEOF reports data that ends within a record as truncated.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Save writes all live items with their remaining TTL to w, using the GobCodec.
+func (cache *Cache) Save(w io.Writer) error {
+	return cache.SaveWithCodec(w, GobCodec{})
+}
+
+// SaveWithCodec writes all live items with their remaining TTL to w, using the given codec.
+func (cache *Cache) SaveWithCodec(w io.Writer, codec Codec) error {
+	return codec.Encode(w, cache.records())
+}
+
+// Load reads items written by Save from r and stores them with their remaining TTL, using the GobCodec.
+func (cache *Cache) Load(r io.Reader) error {
+	return cache.LoadWithCodec(r, GobCodec{})
+}
+
+// LoadWithCodec reads items written by SaveWithCodec from r and stores them with their remaining TTL,
+// using the given codec. Like SetWithRemainingTTL, lookups do not extend the remaining life of loaded items.
+// Items rejected by the before set callback are skipped.
+func (cache *Cache) LoadWithCodec(r io.Reader, codec Codec) error {
+	records, err := codec.Decode(r)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		var err error
+		if record.TTL > 0 {
+			err = cache.SetWithRemainingTTL(record.Key, record.Value, record.TTL)
+		} else {
+			err = cache.SetWithTTL(record.Key, record.Value, record.TTL)
+		}
+		if err != nil && err != ErrRejected {
+			return err
+		}
+	}
+	return nil
+}
+
+// DrainAll is a thread-safe way to hand the items over to another cache, for example one built to replace this
+// one: it removes all items and returns the live ones with their remaining TTL in one step, so no change happens
+// in between. The records can be stored in the other cache with SetWithTTL, or written by a codec and read by
+// Load. As the items are handed over rather than deleted, no callbacks are called and no events are published.
+func (cache *Cache) DrainAll() []Record {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	records := cache.recordsLocked()
+	cache.clearItems()
+	cache.checkSize()
+	return records
+}
+
+// records captures all live items with their remaining TTL.
+func (cache *Cache) records() []Record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.recordsLocked()
+}
+
+// recordsLocked captures all live items with their remaining TTL, must be called with the lock held.
+func (cache *Cache) recordsLocked() []Record {
+	now := cache.now()
+	records := make([]Record, 0, cache.items.Len())
+	cache.items.each(func(item *item) bool {
+		if !item.expiredAt(now) {
+			records = append(records, Record{Key: item.key, Value: item.data, TTL: item.remainingTTL(now)})
+		}
+		return true
+	})
+	return records
+}
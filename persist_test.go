@@ -0,0 +1,154 @@
+package ttlcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSaveLoadWithJSONCodec(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("string", "value", time.Minute)
+	cache.SetWithTTL("map", map[string]interface{}{"name": "value", "count": 2}, ItemNotExpire)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, cache.SaveWithCodec(&buffer, JSONCodec{}), "Expected save to succeed")
+
+	restored := NewCache()
+	defer restored.Close()
+	assert.Nil(t, restored.LoadWithCodec(&buffer, JSONCodec{}), "Expected load to succeed")
+
+	data, exists := restored.Get("string")
+	assert.Equal(t, true, exists, "Expected string value to be restored")
+	assert.Equal(t, "value", data, "Expected string value to be restored")
+	data, exists = restored.Get("map")
+	assert.Equal(t, true, exists, "Expected map value to be restored")
+	assert.Equal(t, map[string]interface{}{"name": "value", "count": float64(2)}, data, "Expected map value to be restored as JSON types")
+
+	info, _ := restored.GetItemInfo("string")
+	assert.InDelta(t, float64(time.Minute), float64(time.Until(info.ExpiresAt)), float64(time.Second), "Expected remaining TTL to be restored")
+	info, _ = restored.GetItemInfo("map")
+	assert.True(t, info.ExpiresAt.IsZero(), "Expected item without expiration to be restored")
+}
+
+func TestCacheDrainAll(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	removed := 0
+	cache.SetRemoveCallback(func(key string, value interface{}) { removed++ })
+	cache.SetWithTTL("minute", 1, time.Minute)
+	cache.SetWithTTL("hour", 2, time.Hour)
+	cache.SetWithTTL("permanent", 3, ItemNotExpire)
+
+	records := cache.DrainAll()
+	assert.Equal(t, 0, cache.Count(), "Expected the cache to be empty")
+	assert.Equal(t, 0, removed, "Expected no remove callbacks for a handoff")
+
+	drained := make(map[string]Record, len(records))
+	for _, record := range records {
+		drained[record.Key] = record
+	}
+	assert.Equal(t, 3, len(drained), "Expected all live items")
+	assert.Equal(t, 1, drained["minute"].Value)
+	assert.InDelta(t, float64(time.Minute), float64(drained["minute"].TTL), float64(time.Second), "Expected the remaining TTL")
+	assert.InDelta(t, float64(time.Hour), float64(drained["hour"].TTL), float64(time.Second), "Expected the remaining TTL")
+	assert.Equal(t, ItemNotExpire, drained["permanent"].TTL, "Expected the permanent item to stay permanent")
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("key", "value", time.Minute)
+	cache.SetWithTTL("expired", "value", time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, cache.Save(&buffer), "Expected save to succeed")
+
+	restored := NewCache()
+	defer restored.Close()
+	assert.Nil(t, restored.Load(&buffer), "Expected load to succeed")
+	data, exists := restored.Get("key")
+	assert.Equal(t, true, exists, "Expected value to be restored")
+	assert.Equal(t, "value", data, "Expected value to be restored")
+	_, exists = restored.Get("expired")
+	assert.Equal(t, false, exists, "Expected expired item to not be saved")
+}
+
+func TestCacheSaveLoadWithBinaryCodec(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	for i := 0; i < 1000; i++ {
+		cache.SetWithTTL(fmt.Sprintf("counter_%d", i), int64(i*i-5000), time.Hour)
+	}
+	cache.SetWithTTL("permanent", int64(math.MaxInt64), ItemNotExpire)
+
+	var binaryBuffer, gobBuffer bytes.Buffer
+	start := time.Now()
+	assert.Nil(t, cache.SaveWithCodec(&binaryBuffer, BinaryCodec{Type: BinaryInt64}), "Expected save to succeed")
+	binaryDuration := time.Since(start)
+	start = time.Now()
+	assert.Nil(t, cache.Save(&gobBuffer), "Expected save to succeed")
+	gobDuration := time.Since(start)
+	t.Logf("binary: %d bytes in %v, gob: %d bytes in %v", binaryBuffer.Len(), binaryDuration, gobBuffer.Len(), gobDuration)
+	assert.True(t, binaryBuffer.Len() < gobBuffer.Len()*2/3, "Expected the binary format to be smaller than gob")
+
+	restored := NewCache()
+	defer restored.Close()
+	assert.Nil(t, restored.LoadWithCodec(&binaryBuffer, BinaryCodec{Type: BinaryInt64}), "Expected load to succeed")
+	assert.Equal(t, cache.Count(), restored.Count())
+	for i := 0; i < 1000; i += 99 {
+		value, found := restored.Get(fmt.Sprintf("counter_%d", i))
+		assert.Equal(t, true, found)
+		assert.Equal(t, int64(i*i-5000), value, "Expected the value to be restored")
+	}
+	info, _ := restored.GetItemInfo("counter_1")
+	assert.InDelta(t, float64(time.Hour), float64(time.Until(info.ExpiresAt)), float64(time.Second), "Expected remaining TTL to be restored")
+	info, _ = restored.GetItemInfo("permanent")
+	assert.True(t, info.ExpiresAt.IsZero(), "Expected item without expiration to be restored")
+	value, _ := restored.Get("permanent")
+	assert.Equal(t, int64(math.MaxInt64), value)
+
+	cache.Set("name", "value")
+	err := cache.SaveWithCodec(&bytes.Buffer{}, BinaryCodec{Type: BinaryInt64})
+	assert.EqualError(t, err, `ttlcache: value of key "name" is a string, the binary codec expects int64`)
+
+	var strings bytes.Buffer
+	assert.Nil(t, BinaryCodec{Type: BinaryString}.Encode(&strings, []Record{{Key: "name", Value: "value", TTL: time.Minute}}))
+	_, err = BinaryCodec{Type: BinaryInt64}.Decode(bytes.NewReader(strings.Bytes()))
+	assert.EqualError(t, err, "ttlcache: binary codec data holds string values, expected int64")
+	records, err := BinaryCodec{Type: BinaryString}.Decode(bytes.NewReader(strings.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{Key: "name", Value: "value", TTL: time.Minute}}, records)
+	_, err = BinaryCodec{Type: BinaryString}.Decode(bytes.NewReader(strings.Bytes()[:strings.Len()-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err, "Expected truncated data to fail")
+}
+
+func TestCacheLoadKeepsRemainingTTL(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("key", "value", time.Minute)
+	var buffer bytes.Buffer
+	assert.Nil(t, cache.Save(&buffer), "Expected save to succeed")
+
+	restored := NewCache()
+	defer restored.Close()
+	assert.Nil(t, restored.Load(&buffer), "Expected load to succeed")
+	loaded, _ := restored.GetItemInfo("key")
+	<-time.After(5 * time.Millisecond)
+	_, exists := restored.Get("key")
+	assert.Equal(t, true, exists, "Expected value to be restored")
+	info, _ := restored.GetItemInfo("key")
+	assert.Equal(t, loaded.ExpiresAt, info.ExpiresAt, "Expected lookups not to extend the remaining TTL of loaded items")
+}
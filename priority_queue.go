@@ -4,8 +4,10 @@ import (
 	"container/heap"
 )
 
-func newPriorityQueue() *priorityQueue {
-	queue := &priorityQueue{}
+// newPriorityQueue creates a queue with room for the given number of items. The queue keeps its capacity
+// as items are removed, so it only grows.
+func newPriorityQueue(capacity int) *priorityQueue {
+	queue := &priorityQueue{items: make([]*item, 0, capacity)}
 	heap.Init(queue)
 	return queue
 }
@@ -33,20 +35,101 @@ func (pq *priorityQueue) remove(item *item) {
 	heap.Remove(pq, item.queueIndex)
 }
 
+// reset removes all items, keeping the capacity.
+func (pq *priorityQueue) reset() {
+	for i := range pq.items {
+		pq.items[i] = nil
+	}
+	pq.items = pq.items[:0]
+}
+
+// countDue counts the items the check finds due. Only the due items and their children are visited, as the heap
+// orders them before all other items.
+func (pq *priorityQueue) countDue(due func(item *item) bool) int {
+	count := 0
+	pending := []int{0}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if i >= pq.Len() || !due(pq.items[i]) {
+			continue
+		}
+		count++
+		pending = append(pending, 2*i+1, 2*i+2)
+	}
+	return count
+}
+
+// ascending calls f for the items in the order they are due, until f returns false, without changing the queue.
+// A second heap holds the positions of the items whose parents were visited, so visiting the first k items
+// costs O(k log k) rather than sorting the whole queue.
+func (pq *priorityQueue) ascending(f func(item *item) bool) {
+	if pq.Len() == 0 {
+		return
+	}
+	frontier := &queueFrontier{queue: pq, positions: []int{0}}
+	for frontier.Len() > 0 {
+		i := heap.Pop(frontier).(int)
+		if !f(pq.items[i]) {
+			return
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < pq.Len() {
+				heap.Push(frontier, child)
+			}
+		}
+	}
+}
+
+// queueFrontier is a heap of positions in the queue, ordered like the items at these positions.
+type queueFrontier struct {
+	queue     *priorityQueue
+	positions []int
+}
+
+func (frontier queueFrontier) Len() int {
+	return len(frontier.positions)
+}
+
+func (frontier queueFrontier) Less(i, j int) bool {
+	return frontier.queue.Less(frontier.positions[i], frontier.positions[j])
+}
+
+func (frontier queueFrontier) Swap(i, j int) {
+	frontier.positions[i], frontier.positions[j] = frontier.positions[j], frontier.positions[i]
+}
+
+func (frontier *queueFrontier) Push(x interface{}) {
+	frontier.positions = append(frontier.positions, x.(int))
+}
+
+func (frontier *queueFrontier) Pop() interface{} {
+	last := len(frontier.positions) - 1
+	position := frontier.positions[last]
+	frontier.positions = frontier.positions[:last]
+	return position
+}
+
 func (pq priorityQueue) Len() int {
 	length := len(pq.items)
 	return length
 }
 
 // Less will consider items with time.Time default value (epoch start) as more than set items.
+// Items expiring at the same time are ordered by insertion, so they expire in a stable order.
+// Items are ordered by the time they are due, by their TTL or by going idle.
 func (pq priorityQueue) Less(i, j int) bool {
-	if pq.items[i].expireAt.IsZero() {
+	dueI, dueJ := pq.items[i].dueAt(), pq.items[j].dueAt()
+	if dueI.IsZero() {
 		return false
 	}
-	if pq.items[j].expireAt.IsZero() {
+	if dueJ.IsZero() {
 		return true
 	}
-	return pq.items[i].expireAt.Before(pq.items[j].expireAt)
+	if dueI.Equal(dueJ) {
+		return pq.items[i].sequence < pq.items[j].sequence
+	}
+	return dueI.Before(dueJ)
 }
 
 func (pq priorityQueue) Swap(i, j int) {
@@ -66,6 +149,8 @@ func (pq *priorityQueue) Pop() interface{} {
 	n := len(old)
 	item := old[n-1]
 	item.queueIndex = -1
+	// the slot is cleared so the item can be collected, the capacity is kept for the next push
+	old[n-1] = nil
 	pq.items = old[0 : n-1]
 	return item
 }
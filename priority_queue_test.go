@@ -9,7 +9,7 @@ import (
 )
 
 func TestPriorityQueuePush(t *testing.T) {
-	queue := newPriorityQueue()
+	queue := newPriorityQueue(0)
 	for i := 0; i < 10; i++ {
 		queue.push(newItem(fmt.Sprintf("key_%d", i), "data", -1))
 	}
@@ -17,7 +17,7 @@ func TestPriorityQueuePush(t *testing.T) {
 }
 
 func TestPriorityQueuePop(t *testing.T) {
-	queue := newPriorityQueue()
+	queue := newPriorityQueue(0)
 	for i := 0; i < 10; i++ {
 		queue.push(newItem(fmt.Sprintf("key_%d", i), "data", -1))
 	}
@@ -37,7 +37,7 @@ func TestPriorityQueuePop(t *testing.T) {
 }
 
 func TestPriorityQueueCheckOrder(t *testing.T) {
-	queue := newPriorityQueue()
+	queue := newPriorityQueue(0)
 	for i := 10; i > 0; i-- {
 		queue.push(newItem(fmt.Sprintf("key_%d", i), "data", time.Duration(i)*time.Second))
 	}
@@ -48,7 +48,7 @@ func TestPriorityQueueCheckOrder(t *testing.T) {
 }
 
 func TestPriorityQueueRemove(t *testing.T) {
-	queue := newPriorityQueue()
+	queue := newPriorityQueue(0)
 	items := make(map[string]*item)
 	var itemRemove *item
 	for i := 0; i < 5; i++ {
@@ -76,7 +76,7 @@ func TestPriorityQueueRemove(t *testing.T) {
 }
 
 func TestPriorityQueueUpdate(t *testing.T) {
-	queue := newPriorityQueue()
+	queue := newPriorityQueue(0)
 	item := newItem("key", "data", 1*time.Second)
 	queue.push(item)
 	assert.Equal(t, queue.Len(), 1, "The queue is supose to be with 1 item")
@@ -87,3 +87,15 @@ func TestPriorityQueueUpdate(t *testing.T) {
 	assert.Equal(t, newItem.key, "newKey", "The item key didn't change")
 	assert.Equal(t, queue.Len(), 0, "The queue is supose to be with 0 items")
 }
+
+func TestPriorityQueueKeepsCapacity(t *testing.T) {
+	queue := newPriorityQueue(8)
+	for i := 0; i < 8; i++ {
+		queue.push(newItem(fmt.Sprintf("key_%d", i), "data", time.Second))
+	}
+	queue.pop()
+	queue.reset()
+	assert.Equal(t, 0, queue.Len(), "Expected the queue to be empty")
+	assert.Equal(t, 8, cap(queue.items), "Expected the queue to keep its capacity")
+	assert.Nil(t, queue.items[:8][0], "Expected the removed items to be released")
+}
@@ -0,0 +1,69 @@
+package ttlcache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// randomDrawAttempts bounds how often GetRandom draws again after hitting an expired item, before it falls
+// back to choosing among the live items only.
+const randomDrawAttempts = 8
+
+// GetRandom returns a live item chosen uniformly at random, without extending its TTL or counting it as use
+// for eviction. It draws from the source set by SetRandSource. It returns false when the cache has no live item.
+func (cache *Cache) GetRandom() (key string, value interface{}, ok bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	items := cache.priorityQueue.items
+	now := cache.now()
+	for attempt := 0; attempt < randomDrawAttempts && len(items) > 0; attempt++ {
+		item := items[cache.random.int63n(int64(len(items)))]
+		if !item.expiredAt(now) {
+			return item.key, item.data, true
+		}
+	}
+	// mostly expired items that are not swept yet, reservoir sample the live ones
+	var chosen *item
+	live := int64(0)
+	for _, item := range items {
+		if item.expiredAt(now) {
+			continue
+		}
+		live++
+		if cache.random.int63n(live) == 0 {
+			chosen = item
+		}
+	}
+	if chosen == nil {
+		return "", nil, false
+	}
+	return chosen.key, chosen.data, true
+}
+
+// lockedRand makes a rand.Source safe for concurrent use, every randomized decision of the cache draws from it.
+type lockedRand struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &lockedRand{random: rand.New(src)}
+}
+
+// int63n returns a pseudo-random number in [0,n), n must be positive.
+func (r *lockedRand) int63n(n int64) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.random.Int63n(n)
+}
+
+// float64 returns a pseudo-random number in [0.0,1.0).
+func (r *lockedRand) float64() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.random.Float64()
+}
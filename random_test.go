@@ -0,0 +1,94 @@
+package ttlcache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSetRandSourceMakesJitterReproducible(t *testing.T) {
+	cacheA := NewCache()
+	defer cacheA.Close()
+	cacheB := NewCache()
+	defer cacheB.Close()
+
+	for _, cache := range []*Cache{cacheA, cacheB} {
+		cache.SetRandSource(rand.NewSource(42))
+		cache.SetTTLJitter(time.Minute)
+	}
+
+	for i := 0; i < 10; i++ {
+		jitterA := cacheA.random.int63n(int64(time.Minute))
+		jitterB := cacheB.random.int63n(int64(time.Minute))
+		assert.Equal(t, jitterA, jitterB, "Expected identically seeded caches to draw identical jitter")
+	}
+
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		cacheA.SetWithTTL(fmt.Sprintf("key_%d", i), "value", time.Hour)
+		cacheB.SetWithTTL(fmt.Sprintf("key_%d", i), "value", time.Hour)
+		end := time.Now()
+
+		expireA := storedItem(cacheA, fmt.Sprintf("key_%d", i)).expireAt
+		expireB := storedItem(cacheB, fmt.Sprintf("key_%d", i)).expireAt
+		assert.True(t, expireB.Sub(expireA) >= 0 && expireB.Sub(expireA) <= end.Sub(start), "Expected identical jittered schedules")
+		assert.False(t, expireA.Before(start.Add(time.Hour)), "Expected jitter to only extend the TTL")
+		assert.True(t, expireA.Before(end.Add(time.Hour+time.Minute)), "Expected jitter to stay within bounds")
+	}
+}
+
+func TestCacheSetWithTTLAtReturnsJitteredExpiration(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetRandSource(rand.NewSource(1))
+	cache.SetTTLJitter(time.Minute)
+
+	start := time.Now()
+	expireAt, err := cache.SetWithTTLAt("key", "value", time.Hour)
+	assert.Nil(t, err, "Expected item to be stored")
+	info, _ := cache.GetItemInfo("key")
+	assert.Equal(t, info.ExpiresAt, expireAt, "Expected the scheduled expiration to be returned")
+	assert.True(t, expireAt.Sub(start) > time.Hour, "Expected the jittered expiration rather than the nominal one")
+
+	expireAt, err = cache.SetWithTTLAt("permanent", "value", ItemNotExpire)
+	assert.Nil(t, err, "Expected item to be stored")
+	assert.True(t, expireAt.IsZero(), "Expected zero time for an item without expiration")
+}
+
+func TestCacheGetRandom(t *testing.T) {
+	cache := NewCacheManualSweep()
+	defer cache.Close()
+
+	_, _, ok := cache.GetRandom()
+	assert.Equal(t, false, ok, "Expected no item from an empty cache")
+
+	cache.SetRandSource(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key_%d", i), i)
+		cache.SetWithTTL(fmt.Sprintf("expired_%d", i), i, time.Nanosecond)
+	}
+	<-time.After(time.Millisecond)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key, value, ok := cache.GetRandom()
+		assert.Equal(t, true, ok)
+		assert.Equal(t, fmt.Sprintf("key_%d", value), key, "Expected the value of the key")
+		counts[key]++
+	}
+	assert.Equal(t, 10, len(counts), "Expected only and all live items to be drawn")
+	for key, count := range counts {
+		assert.InDelta(t, 1000, count, 150, "Expected %s to be drawn about uniformly", key)
+	}
+
+	cache.RunCleanup()
+	cache.Purge()
+	cache.SetWithTTL("expired", "value", time.Nanosecond)
+	<-time.After(time.Millisecond)
+	_, _, ok = cache.GetRandom()
+	assert.Equal(t, false, ok, "Expected no expired item")
+}
@@ -0,0 +1,139 @@
+package ttlcache
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrReentrant is returned by the Set functions when they are called from a callback that runs while the cache
+// is locked, which would otherwise deadlock, or raised as panic in strict mode, see SetStrictMode. Other functions of the cache panic with it in that case, once the
+// function the callback runs for has released the lock, so the cache stays usable when the panic is recovered.
+var ErrReentrant = errors.New("ttlcache: cache used from a callback that runs while the cache is locked")
+
+// cacheMutex is the lock of the cache. It detects callbacks that run while it is held and use the cache, and
+// panics with ErrReentrant instead of deadlocking. Panics of callbacks are held back until the lock is released,
+// as the functions of the cache do not release it on a panic.
+type cacheMutex struct {
+	sync.RWMutex
+	// users is the number of goroutines holding or waiting for the lock, accessed atomically, see tryLock
+	users int32
+	// callbacks is the number of callbacks running under the lock, accessed atomically.
+	callbacks int32
+	// callbackGoroutines holds the ids of the goroutines running them.
+	callbackGoroutines sync.Map
+	// panics is the number of panics of callbacks held back, accessed atomically.
+	panics int32
+	// pendingPanics holds them by the id of the goroutine that raises them on Unlock or RUnlock.
+	pendingPanics sync.Map
+}
+
+func (m *cacheMutex) Lock() {
+	m.checkReentrant()
+	atomic.AddInt32(&m.users, 1)
+	m.RWMutex.Lock()
+}
+
+func (m *cacheMutex) Unlock() {
+	m.RWMutex.Unlock()
+	atomic.AddInt32(&m.users, -1)
+	m.raisePending()
+}
+
+func (m *cacheMutex) RLock() {
+	m.checkReentrant()
+	atomic.AddInt32(&m.users, 1)
+	m.RWMutex.RLock()
+}
+
+func (m *cacheMutex) RUnlock() {
+	m.RWMutex.RUnlock()
+	atomic.AddInt32(&m.users, -1)
+	m.raisePending()
+}
+
+// tryLock acquires the lock only when no goroutine holds or waits for it, so it never blocks, and returns
+// whether it did. It counts the users itself as sync.RWMutex.TryLock is not available to older Go versions.
+func (m *cacheMutex) tryLock() bool {
+	m.checkReentrant()
+	if !atomic.CompareAndSwapInt32(&m.users, 0, 1) {
+		return false
+	}
+	m.RWMutex.Lock()
+	return true
+}
+
+// guard calls the callback f, which is called while the lock is held, marking its goroutine so uses of the
+// cache from within it are detected. Callbacks may run concurrently under the read lock. It returns false when
+// f panicked, the panic is raised again when the goroutine releases the lock, and callers should leave the
+// cache unchanged until then.
+func (m *cacheMutex) guard(f func()) (completed bool) {
+	if m.reentrant() {
+		// nested callback, the goroutine is marked already and the outer guard recovers the panics
+		f()
+		return true
+	}
+	id := goroutineID()
+	m.callbackGoroutines.Store(id, struct{}{})
+	atomic.AddInt32(&m.callbacks, 1)
+	defer func() {
+		atomic.AddInt32(&m.callbacks, -1)
+		m.callbackGoroutines.Delete(id)
+		if completed {
+			return
+		}
+		if r := recover(); r != nil {
+			if _, held := m.pendingPanics.LoadOrStore(id, r); !held {
+				atomic.AddInt32(&m.panics, 1)
+			}
+		}
+	}()
+	f()
+	return true
+}
+
+// raisePending raises the panic of a callback the calling goroutine ran while it held the lock, see guard. It
+// is cheap while no panic is held back.
+func (m *cacheMutex) raisePending() {
+	if atomic.LoadInt32(&m.panics) == 0 {
+		return
+	}
+	id := goroutineID()
+	if r, found := m.pendingPanics.Load(id); found {
+		m.pendingPanics.Delete(id)
+		atomic.AddInt32(&m.panics, -1)
+		panic(r)
+	}
+}
+
+// reentrant reports whether the calling goroutine runs a callback under the lock. It is cheap while no
+// callback runs.
+func (m *cacheMutex) reentrant() bool {
+	if atomic.LoadInt32(&m.callbacks) == 0 {
+		return false
+	}
+	_, found := m.callbackGoroutines.Load(goroutineID())
+	return found
+}
+
+func (m *cacheMutex) checkReentrant() {
+	if m.reentrant() {
+		panic(ErrReentrant)
+	}
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the header of its stack trace
+// "goroutine 123 [running]:", as Go has no goroutine local storage.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
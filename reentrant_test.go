@@ -0,0 +1,125 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheReentrantCallbackFailsInsteadOfDeadlocking(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	errs := make(chan error, 1)
+	cache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		errs <- cache.Set("other", "value")
+		return true
+	})
+	cache.SetWithTTL("key", "value", 10*time.Millisecond)
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrReentrant, err, "Expected the Set from the callback to be refused")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the check expiration callback to be called")
+	}
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, 0, cache.Count(), "Expected the cache to keep working after the callback")
+
+	var recovered interface{}
+	cache.SetBeforeSetCallback(func(key string, value interface{}) bool {
+		func() {
+			defer func() { recovered = recover() }()
+			cache.Get("other")
+		}()
+		return true
+	})
+	assert.Nil(t, cache.Set("key", "value"))
+	assert.Equal(t, ErrReentrant, recovered, "Expected the Get from the callback to panic with ErrReentrant")
+
+	// the same functions are fine outside of the callbacks and from other goroutines
+	cache.SetBeforeSetCallback(nil)
+	assert.Nil(t, cache.Set("other", "value"))
+	value, _ := cache.Get("other")
+	assert.Equal(t, "value", value)
+}
+
+func TestCacheIterationCallbacksAreGuarded(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+	cache.SetWithTTL("key", "value", time.Minute)
+
+	assert.PanicsWithValue(t, ErrReentrant, func() {
+		cache.Range(func(key string, value interface{}) bool {
+			cache.Get("key")
+			return true
+		})
+	}, "Expected a Get from the Range callback to panic with ErrReentrant")
+	assert.PanicsWithValue(t, ErrReentrant, func() {
+		cache.RangeByExpiry(func(key string, value interface{}, remaining time.Duration) bool {
+			cache.Get("key")
+			return true
+		})
+	}, "Expected a Get from the RangeByExpiry callback to panic with ErrReentrant")
+
+	value, exists := cache.Get("key")
+	assert.True(t, exists)
+	assert.Equal(t, "value", value)
+}
+
+func TestCacheStaysUsableAfterReentrantPanic(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+	cache.Set("key", "value")
+
+	assert.PanicsWithValue(t, ErrReentrant, func() {
+		cache.Update("key", func(current interface{}, exists bool) (interface{}, bool) {
+			cache.Get("other")
+			return nil, false
+		}, false)
+	}, "Expected the Get from the Update function to panic with ErrReentrant")
+	value, exists := cache.Get("key")
+	assert.True(t, exists, "Expected the item to be kept when the Update function panics")
+	assert.Equal(t, "value", value)
+
+	assert.PanicsWithValue(t, ErrReentrant, func() {
+		cache.GetOrDefaultWithTTL("other", func(key string) (interface{}, error) {
+			cache.Get("key")
+			return "loaded", nil
+		}, time.Minute)
+	}, "Expected the Get from the generator to panic with ErrReentrant")
+	_, exists = cache.Get("other")
+	assert.False(t, exists, "Expected nothing to be stored when the generator panics")
+
+	cache.SetBeforeSetCallback(func(key string, value interface{}) bool {
+		cache.Get(key)
+		return true
+	})
+	assert.PanicsWithValue(t, ErrReentrant, func() { cache.Set("other", "value") })
+	cache.SetBeforeSetCallback(nil)
+
+	// the lock was released on every panic
+	assert.Nil(t, cache.Set("other", "value"))
+	assert.Equal(t, 2, cache.Count())
+}
+
+func TestCacheReentrantSetPanicsInStrictMode(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+	cache.SetStrictMode(true)
+
+	cache.SetBeforeSetCallback(func(key string, value interface{}) bool {
+		if key == "key" {
+			cache.Set("other", value)
+		}
+		return true
+	})
+	assert.PanicsWithValue(t, ErrReentrant, func() { cache.Set("key", "value") },
+		"Expected the Set from the callback to panic in strict mode")
+	assert.Equal(t, 0, cache.Count())
+
+	cache.SetStrictMode(false)
+	assert.Nil(t, cache.Set("key", "value"), "Expected the Set from the callback to be refused without a panic")
+	assert.Equal(t, 1, cache.Count())
+}
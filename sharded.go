@@ -0,0 +1,123 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ShardedCache spreads its items over several caches by the hash of their keys, so operations on keys of
+// different shards do not contend for the same lock.
+type ShardedCache struct {
+	shards []*Cache
+	// hasher holds the func(key string) uint64 that picks the shard of a key, see SetShardHasher
+	hasher atomic.Value
+}
+
+// NewShardedCache creates a cache of the given number of shards, at least one.
+func NewShardedCache(shards int) *ShardedCache {
+	if shards < 1 {
+		shards = 1
+	}
+	sharded := &ShardedCache{shards: make([]*Cache, shards)}
+	for i := range sharded.shards {
+		sharded.shards[i] = NewCache()
+	}
+	sharded.hasher.Store(hashKey)
+	return sharded
+}
+
+// SetShardHasher replaces the hash that picks the shard of a key, FNV-1a by default, with one suited better to
+// the keys, for example hashing only the distinct suffix of keys with long common prefixes. The hash must be
+// deterministic. Items stored before are not moved to the shards of the new hash, so set it before storing items.
+// A nil hash restores the default.
+func (sharded *ShardedCache) SetShardHasher(hasher func(key string) uint64) {
+	if hasher == nil {
+		hasher = hashKey
+	}
+	sharded.hasher.Store(hasher)
+}
+
+// shard returns the cache that holds the key.
+func (sharded *ShardedCache) shard(key string) *Cache {
+	hasher := sharded.hasher.Load().(func(key string) uint64)
+	return sharded.shards[hasher(key)%uint64(len(sharded.shards))]
+}
+
+// Set stores the item in its shard, see Cache.Set.
+func (sharded *ShardedCache) Set(key string, data interface{}) error {
+	return sharded.shard(key).Set(key, data)
+}
+
+// SetWithTTL stores the item with the ttl in its shard, see Cache.SetWithTTL.
+func (sharded *ShardedCache) SetWithTTL(key string, data interface{}, ttl time.Duration) error {
+	return sharded.shard(key).SetWithTTL(key, data, ttl)
+}
+
+// Get looks the item up in its shard, see Cache.Get.
+func (sharded *ShardedCache) Get(key string) (interface{}, bool) {
+	return sharded.shard(key).Get(key)
+}
+
+// Remove removes the item from its shard, see Cache.Remove.
+func (sharded *ShardedCache) Remove(key string) bool {
+	return sharded.shard(key).Remove(key)
+}
+
+// Count returns the number of items of all shards.
+func (sharded *ShardedCache) Count() int {
+	count := 0
+	for _, shard := range sharded.shards {
+		count += shard.Count()
+	}
+	return count
+}
+
+// Close closes all shards.
+func (sharded *ShardedCache) Close() {
+	for _, shard := range sharded.shards {
+		shard.Close()
+	}
+}
+
+// ShardMetrics returns the metrics of every shard, to find shards that are loaded more than others because of
+// a poor distribution of the keys or a hot key.
+func (sharded *ShardedCache) ShardMetrics() []Metrics {
+	metrics := make([]Metrics, len(sharded.shards))
+	for i, shard := range sharded.shards {
+		metrics[i] = shard.GetMetrics()
+	}
+	return metrics
+}
+
+// Metrics returns the metrics of all shards together: the counters and durations are summed, the extremes
+// are those of all shards.
+func (sharded *ShardedCache) Metrics() Metrics {
+	var total Metrics
+	for _, metrics := range sharded.ShardMetrics() {
+		total.Hits += metrics.Hits
+		total.Misses += metrics.Misses
+		total.Items += metrics.Items
+		total.RejectedKeys += metrics.RejectedKeys
+		total.OversizedValues += metrics.OversizedValues
+		total.PressureRejections += metrics.PressureRejections
+		total.FullRejections += metrics.FullRejections
+		total.SlowCallbacks += metrics.SlowCallbacks
+		total.ExpiredBacklog += metrics.ExpiredBacklog
+		total.LoaderCalls += metrics.LoaderCalls
+		total.LoaderErrors += metrics.LoaderErrors
+		total.LoaderLatency += metrics.LoaderLatency
+		if metrics.OldestExpiredAge > total.OldestExpiredAge {
+			total.OldestExpiredAge = metrics.OldestExpiredAge
+		}
+		if metrics.LoaderCalls > 0 && (total.LoaderLatencyMin == 0 || metrics.LoaderLatencyMin < total.LoaderLatencyMin) {
+			total.LoaderLatencyMin = metrics.LoaderLatencyMin
+		}
+		if metrics.LoaderLatencyMax > total.LoaderLatencyMax {
+			total.LoaderLatencyMax = metrics.LoaderLatencyMax
+		}
+		total.GetTimings = total.GetTimings.add(metrics.GetTimings)
+		total.SetTimings = total.SetTimings.add(metrics.SetTimings)
+		total.RemoveTimings = total.RemoveTimings.add(metrics.RemoveTimings)
+	}
+	return total
+}
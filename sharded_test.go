@@ -0,0 +1,98 @@
+package ttlcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCacheShardMetrics(t *testing.T) {
+	cache := NewShardedCache(4)
+	defer cache.Close()
+
+	// find keys of the hot shard
+	hot := cache.shard("hot")
+	var hotKeys []string
+	for i := 0; len(hotKeys) < 10; i++ {
+		if key := fmt.Sprintf("key_%d", i); cache.shard(key) == hot {
+			hotKeys = append(hotKeys, key)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("other_%d", i), i)
+	}
+	for _, key := range hotKeys {
+		cache.Set(key, key)
+		for i := 0; i < 10; i++ {
+			cache.Get(key)
+		}
+		cache.Get(key + "_missing")
+	}
+
+	shards := cache.ShardMetrics()
+	assert.Equal(t, 4, len(shards))
+	var hits, misses uint64
+	items := 0
+	var hottest Metrics
+	for _, metrics := range shards {
+		hits += metrics.Hits
+		misses += metrics.Misses
+		items += metrics.Items
+		if metrics.Hits > hottest.Hits {
+			hottest = metrics
+		}
+	}
+	assert.Equal(t, hot.GetMetrics().Hits, hottest.Hits, "Expected the hot shard to have the most hits")
+	assert.Equal(t, uint64(100), hottest.Hits, "Expected all hits on the hot shard")
+
+	aggregate := cache.Metrics()
+	assert.Equal(t, hits, aggregate.Hits, "Expected the aggregate to be the sum")
+	assert.Equal(t, misses, aggregate.Misses, "Expected the aggregate to be the sum")
+	assert.Equal(t, uint64(10), aggregate.Misses)
+	assert.Equal(t, 30, aggregate.Items)
+	assert.Equal(t, cache.Count(), items)
+}
+
+func TestShardedCacheSetShardHasher(t *testing.T) {
+	// keys sharing a long prefix that the default hash all puts into the first shard
+	var keys []string
+	probe := NewShardedCache(4)
+	defer probe.Close()
+	for i := 0; len(keys) < 40; i++ {
+		if key := fmt.Sprintf("tenant/region/service/%d", i); probe.shard(key) == probe.shards[0] {
+			keys = append(keys, key)
+		}
+	}
+	largestShard := func(cache *ShardedCache) int {
+		largest := 0
+		for _, metrics := range cache.ShardMetrics() {
+			if metrics.Items > largest {
+				largest = metrics.Items
+			}
+		}
+		return largest
+	}
+
+	defaultHashed := NewShardedCache(4)
+	defer defaultHashed.Close()
+	customHashed := NewShardedCache(4)
+	defer customHashed.Close()
+	customHashed.SetShardHasher(func(key string) uint64 {
+		suffix, _ := strconv.Atoi(key[strings.LastIndex(key, "/")+1:])
+		return uint64(suffix)
+	})
+	for _, key := range keys {
+		defaultHashed.Set(key, key)
+		customHashed.Set(key, key)
+	}
+
+	assert.Equal(t, 40, largestShard(defaultHashed), "Expected the default hash to put all keys into one shard")
+	assert.True(t, largestShard(customHashed) < 20, "Expected the custom hash to spread the keys more evenly")
+	for _, key := range keys {
+		value, _ := customHashed.Get(key)
+		assert.Equal(t, key, value, "Expected the keys to be found in the shards of the custom hash")
+	}
+}
@@ -0,0 +1,61 @@
+package ttlcache
+
+import (
+	"time"
+)
+
+// Snapshot is an immutable view of the live items of a cache at the time it was taken, see Cache.Snapshot.
+// Later changes and expirations in the cache do not affect it. It is safe for concurrent use.
+type Snapshot struct {
+	takenAt time.Time
+	keys    []string
+	records map[string]Record
+}
+
+// Snapshot captures all live items with their remaining TTL, without touching them. Taking it holds the read
+// lock for as long as it takes to copy the keys and values, the values themselves are shared with the cache.
+func (cache *Cache) Snapshot() Snapshot {
+	records := cache.records()
+	snapshot := Snapshot{
+		takenAt: time.Now(),
+		keys:    make([]string, len(records)),
+		records: make(map[string]Record, len(records)),
+	}
+	for i, record := range records {
+		snapshot.keys[i] = record.Key
+		snapshot.records[record.Key] = record
+	}
+	return snapshot
+}
+
+// TakenAt returns the time the snapshot was taken.
+func (snapshot Snapshot) TakenAt() time.Time {
+	return snapshot.takenAt
+}
+
+// Get returns the value of the key and its remaining TTL at the time the snapshot was taken, see Record for the
+// TTL of items that do not expire. It returns false when the key was not in the cache.
+func (snapshot Snapshot) Get(key string) (value interface{}, remainingTTL time.Duration, found bool) {
+	record, found := snapshot.records[key]
+	return record.Value, record.TTL, found
+}
+
+// Keys returns the keys of the snapshot, in no particular order.
+func (snapshot Snapshot) Keys() []string {
+	return append([]string(nil), snapshot.keys...)
+}
+
+// Len returns the number of items in the snapshot.
+func (snapshot Snapshot) Len() int {
+	return len(snapshot.keys)
+}
+
+// Range calls f for every item in the snapshot until f returns false.
+func (snapshot Snapshot) Range(f func(key string, value interface{}, remainingTTL time.Duration) bool) {
+	for _, key := range snapshot.keys {
+		record := snapshot.records[key]
+		if !f(key, record.Value, record.TTL) {
+			return
+		}
+	}
+}
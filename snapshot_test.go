@@ -0,0 +1,52 @@
+package ttlcache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSnapshotIsUnaffectedByChanges(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetWithTTL("short", "a", time.Minute)
+	cache.SetWithTTL("long", "b", time.Hour)
+	cache.SetWithTTL("permanent", "c", ItemNotExpire)
+	cache.SetWithTTL("expired", "d", time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	snapshot := cache.Snapshot()
+	cache.Set("long", "changed")
+	cache.Remove("permanent")
+	cache.Set("new", "e")
+	ageItem(cache, "short", time.Minute)
+	_, found := cache.Get("short")
+	assert.Equal(t, false, found, "Expected the item to expire in the cache")
+
+	keys := snapshot.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"long", "permanent", "short"}, keys, "Expected the live items at the time of the snapshot")
+	assert.Equal(t, 3, snapshot.Len())
+	value, ttl, found := snapshot.Get("long")
+	assert.Equal(t, true, found)
+	assert.Equal(t, "b", value, "Expected the value at the time of the snapshot")
+	assert.InDelta(t, float64(time.Hour), float64(ttl), float64(time.Second))
+	value, ttl, found = snapshot.Get("short")
+	assert.Equal(t, true, found, "Expected the expired item to stay in the snapshot")
+	assert.Equal(t, "a", value)
+	assert.True(t, ttl > 0 && ttl <= time.Minute, "Expected the remaining TTL at the time of the snapshot")
+	_, ttl, _ = snapshot.Get("permanent")
+	assert.Equal(t, ItemNotExpire, ttl)
+	_, _, found = snapshot.Get("new")
+	assert.Equal(t, false, found, "Expected later items not to be in the snapshot")
+
+	ranged := 0
+	snapshot.Range(func(key string, value interface{}, remainingTTL time.Duration) bool {
+		ranged++
+		return ranged < 2
+	})
+	assert.Equal(t, 2, ranged, "Expected Range to stop when f returns false")
+}
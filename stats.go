@@ -0,0 +1,141 @@
+package ttlcache
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// defaultAuxiliaryMapLimit bounds how many keys the structures tracking missed keys hold by default.
+const defaultAuxiliaryMapLimit = 1024
+
+// missStat counts the misses of a key, it is kept in the recently missed keys.
+type missStat struct {
+	key    string
+	misses int64
+}
+
+// EnablePerKeyStats allows the user to track hits and misses per key, see KeyStats. It is disabled by default,
+// as it costs a counter per item and keeps recently missed keys with their counts, dropping the least recently
+// missed ones beyond the auxiliary map limit, see SetAuxiliaryMapLimit. Disabling it drops the tracked misses.
+func (cache *Cache) EnablePerKeyStats(value bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.perKeyStats = value
+	if value && cache.missedKeys == nil {
+		cache.missedKeys = list.New()
+		cache.missedKeyIndex = make(map[string]*list.Element)
+	} else if !value {
+		cache.missedKeys = nil
+		cache.missedKeyIndex = nil
+	}
+}
+
+// KeyStats returns the number of lookups of the key that hit and missed since stats were enabled, see
+// EnablePerKeyStats. Hits are counted while the item is in the cache, misses while the key is among the
+// recently missed keys. It returns false when stats are disabled or nothing is known about the key.
+func (cache *Cache) KeyStats(key string) (hits, misses int64, ok bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	if !cache.perKeyStats {
+		return 0, 0, false
+	}
+	if item, exists := cache.items.get(key); exists {
+		hits = atomic.LoadInt64(&item.hits)
+		ok = true
+	}
+	if element, exists := cache.missedKeyIndex[key]; exists {
+		misses = element.Value.(*missStat).misses
+		ok = true
+	}
+	return hits, misses, ok
+}
+
+// SetHitCallback sets a callback that will be called with the key of every lookup that finds a live item, see
+// also SetSamplingRate. It is called while the cache is locked, possibly concurrently, and must not use the cache.
+func (cache *Cache) SetHitCallback(callback func(key string)) {
+	cache.mutex.Lock()
+	cache.hitCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetMissCallback sets a callback that will be called with the key of every lookup that misses, see also
+// SetSamplingRate. It is called while the cache is locked and must not use the cache.
+func (cache *Cache) SetMissCallback(callback func(key string)) {
+	cache.mutex.Lock()
+	cache.missCallback = callback
+	cache.mutex.Unlock()
+}
+
+// SetSamplingRate makes only the given fraction of lookups, chosen at random, call the hit and miss callbacks
+// and count in the per key stats, to cut their overhead for frequent lookups. The counts then are a sample,
+// divide them by the rate to estimate the totals. The lookups themselves are not affected. The default rate
+// of 1 observes every lookup, a rate of 0 none.
+func (cache *Cache) SetSamplingRate(rate float64) {
+	cache.mutex.Lock()
+	cache.samplingRate = rate
+	cache.mutex.Unlock()
+}
+
+// sampled decides whether a lookup is observed, see SetSamplingRate.
+func (cache *Cache) sampled() bool {
+	if cache.samplingRate >= 1 {
+		return true
+	}
+	return cache.samplingRate > 0 && cache.random.float64() < cache.samplingRate
+}
+
+// countHit counts a lookup that found the item. Must be called with at least the read lock held.
+func (cache *Cache) countHit(item *item) {
+	atomic.AddUint64(&cache.hits, 1)
+	if (!cache.perKeyStats && cache.hitCallback == nil) || !cache.sampled() {
+		return
+	}
+	if cache.perKeyStats {
+		atomic.AddInt64(&item.hits, 1)
+	}
+	if cache.hitCallback != nil {
+		cache.mutex.guard(func() { cache.hitCallback(item.key) })
+	}
+}
+
+// countMiss counts a lookup that did not find the key. Must be called with the lock held.
+func (cache *Cache) countMiss(key string) {
+	atomic.AddUint64(&cache.misses, 1)
+	if (!cache.perKeyStats && cache.missCallback == nil) || !cache.sampled() {
+		return
+	}
+	if cache.missCallback != nil {
+		cache.mutex.guard(func() { cache.missCallback(key) })
+	}
+	if !cache.perKeyStats {
+		return
+	}
+	if element, exists := cache.missedKeyIndex[key]; exists {
+		element.Value.(*missStat).misses++
+		cache.missedKeys.MoveToFront(element)
+		return
+	}
+	cache.missedKeyIndex[key] = cache.missedKeys.PushFront(&missStat{key: key, misses: 1})
+	cache.trimMissedKeys()
+}
+
+// SetAuxiliaryMapLimit bounds how many keys the structures tracking missed keys hold each, such as the per key
+// stats, so a stream of distinct missing keys cannot grow them without limit. Beyond the limit the least recently
+// missed keys are dropped, lowering it drops them right away. The default limit is 1024 keys.
+func (cache *Cache) SetAuxiliaryMapLimit(limit int) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.auxiliaryMapLimit = limit
+	cache.trimMissedKeys()
+}
+
+// trimMissedKeys drops the least recently missed keys beyond the limit. Must be called with the lock held.
+func (cache *Cache) trimMissedKeys() {
+	if cache.missedKeys == nil {
+		return
+	}
+	for cache.missedKeys.Len() > cache.auxiliaryMapLimit {
+		oldest := cache.missedKeys.Remove(cache.missedKeys.Back()).(*missStat)
+		delete(cache.missedKeyIndex, oldest.key)
+	}
+}
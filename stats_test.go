@@ -0,0 +1,112 @@
+package ttlcache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheKeyStats(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("hot", "value")
+	cache.Get("hot")
+	_, _, ok := cache.KeyStats("hot")
+	assert.Equal(t, false, ok, "Expected no stats while disabled")
+
+	cache.EnablePerKeyStats(true)
+	for i := 0; i < 3; i++ {
+		cache.Get("hot")
+	}
+	cache.Get("cold")
+	cache.Get("cold")
+
+	hits, misses, ok := cache.KeyStats("hot")
+	assert.Equal(t, true, ok, "Expected stats for a hit key")
+	assert.Equal(t, int64(3), hits, "Expected the hits since stats were enabled")
+	assert.Equal(t, int64(0), misses, "Expected no misses")
+
+	hits, misses, ok = cache.KeyStats("cold")
+	assert.Equal(t, true, ok, "Expected stats for a missed key")
+	assert.Equal(t, int64(0), hits, "Expected no hits")
+	assert.Equal(t, int64(2), misses, "Expected the misses")
+
+	_, _, ok = cache.KeyStats("unknown")
+	assert.Equal(t, false, ok, "Expected no stats for a key that was never looked up")
+}
+
+func TestCacheKeyStatsBoundsMisses(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.EnablePerKeyStats(true)
+	for i := 0; i <= defaultAuxiliaryMapLimit; i++ {
+		cache.Get(fmt.Sprintf("key_%d", i))
+	}
+
+	_, _, ok := cache.KeyStats("key_0")
+	assert.Equal(t, false, ok, "Expected the least recently missed key to be dropped")
+	_, misses, ok := cache.KeyStats(fmt.Sprintf("key_%d", defaultAuxiliaryMapLimit))
+	assert.Equal(t, true, ok, "Expected a recently missed key to be tracked")
+	assert.Equal(t, int64(1), misses, "Expected the misses")
+}
+
+func TestCacheSetAuxiliaryMapLimit(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.EnablePerKeyStats(true)
+	for i := 0; i < 2*defaultAuxiliaryMapLimit; i++ {
+		cache.Get(fmt.Sprintf("probe_%d", i))
+	}
+	cache.mutex.RLock()
+	assert.Equal(t, defaultAuxiliaryMapLimit, cache.missedKeys.Len(), "Expected the default limit")
+	cache.mutex.RUnlock()
+
+	cache.SetAuxiliaryMapLimit(100)
+	for i := 0; i < 1000; i++ {
+		cache.Get(fmt.Sprintf("another_probe_%d", i))
+	}
+	cache.mutex.RLock()
+	assert.Equal(t, 100, cache.missedKeys.Len(), "Expected the missed keys to stay bounded")
+	assert.Equal(t, 100, len(cache.missedKeyIndex), "Expected the index to stay bounded")
+	cache.mutex.RUnlock()
+	_, misses, ok := cache.KeyStats("another_probe_999")
+	assert.Equal(t, true, ok, "Expected the most recently missed key to be tracked")
+	assert.Equal(t, int64(1), misses, "Expected the misses")
+}
+
+func TestCacheSetSamplingRate(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var hits, misses int
+	cache.SetHitCallback(func(string) { hits++ })
+	cache.SetMissCallback(func(string) { misses++ })
+	cache.EnablePerKeyStats(true)
+	cache.Set("key", "value")
+
+	cache.SetSamplingRate(0)
+	for i := 0; i < 100; i++ {
+		value, found := cache.Get("key")
+		assert.Equal(t, true, found, "Expected sampling not to affect the lookup")
+		assert.Equal(t, "value", value)
+		cache.Get("missing")
+	}
+	assert.Equal(t, 0, hits, "Expected no hit to be sampled")
+	assert.Equal(t, 0, misses, "Expected no miss to be sampled")
+	keyHits, _, _ := cache.KeyStats("key")
+	assert.Equal(t, int64(0), keyHits, "Expected no per key stat to be sampled")
+
+	cache.SetSamplingRate(1)
+	for i := 0; i < 100; i++ {
+		cache.Get("key")
+		cache.Get("missing")
+	}
+	assert.Equal(t, 100, hits, "Expected every hit to be sampled")
+	assert.Equal(t, 100, misses, "Expected every miss to be sampled")
+	keyHits, _, _ = cache.KeyStats("key")
+	assert.Equal(t, int64(100), keyHits, "Expected every per key stat to be sampled")
+}
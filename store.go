@@ -0,0 +1,290 @@
+package ttlcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStoreNotReadable is returned by PreloadFromStore and PreloadPrefixFromStore when no store is set, or the
+// store does not implement ReadableStore.
+var ErrStoreNotReadable = errors.New("ttlcache: store is not readable")
+
+// Store is a backing store the cache writes through to, see SetStore.
+type Store interface {
+	// Put stores the value with its remaining TTL, ItemNotExpire for values that do not expire.
+	Put(key string, value interface{}, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// BatchStore is a Store that also accepts writes in batches, see SetStoreBatching.
+type BatchStore interface {
+	Store
+	PutBatch(records []Record) error
+	DeleteBatch(keys []string) error
+}
+
+// ReadableStore is a Store the cache can also read from, see PreloadFromStore.
+type ReadableStore interface {
+	Store
+	// Get returns the value of the key with its remaining TTL, ItemNotExpire for values that do not expire.
+	// It returns false for keys that are not in the store.
+	Get(key string) (value interface{}, ttl time.Duration, found bool, err error)
+	// Scan calls f with the value and remaining TTL of every key with the prefix, until f returns false.
+	Scan(prefix string, f func(record Record) bool) error
+}
+
+// storeWrite is a write to the store that is buffered for the next batch, a put or a delete of the key.
+type storeWrite struct {
+	record Record
+	delete bool
+}
+
+// SetStore makes the cache write through to the store: items stored by the Set functions are put to the store,
+// and keys removed with Remove are deleted from it. Expired and evicted items are left to the store.
+// Unless writes are batched, see SetStoreBatching, a failing put is returned by Set after the item was stored
+// in the cache, and a failing delete is passed to the error callback. A nil store disables writing through.
+func (cache *Cache) SetStore(store Store) {
+	cache.storeMutex.Lock()
+	cache.store = store
+	cache.storeMutex.Unlock()
+}
+
+// SetStoreErrorCallback sets the callback that receives the errors of writes to the store that cannot be
+// returned to the caller, such as failing batch flushes.
+func (cache *Cache) SetStoreErrorCallback(callback func(err error)) {
+	cache.storeMutex.Lock()
+	cache.storeErrorCallback = callback
+	cache.storeMutex.Unlock()
+}
+
+// SetStoreBatching buffers the writes to the store, and flushes them with PutBatch and DeleteBatch once writes
+// for size keys are buffered or flushInterval elapsed, whichever comes first. Repeated writes of a key between
+// two flushes are coalesced into the last one. Stores that do not implement BatchStore receive the buffered
+// writes one by one. Close flushes the remaining writes. Errors of a flush are passed to the error callback,
+// see SetStoreErrorCallback. A size of 0 flushes the buffer and disables batching.
+func (cache *Cache) SetStoreBatching(size int, flushInterval time.Duration) {
+	cache.mutex.Lock()
+	if cache.storeFlushStop != nil {
+		close(cache.storeFlushStop)
+		cache.storeFlushStop = nil
+	}
+	if size > 0 && flushInterval > 0 && !cache.isShutDown {
+		cache.storeFlushStop = make(chan struct{})
+		cache.backgroundWorkers.Add(1)
+		go cache.flushStorePeriodically(cache.storeFlushStop, flushInterval)
+	}
+	cache.storeMutex.Lock()
+	cache.storeBatchSize = size
+	cache.storeMutex.Unlock()
+	cache.mutex.Unlock()
+
+	if size <= 0 {
+		cache.flushStore()
+	}
+}
+
+func (cache *Cache) flushStorePeriodically(stop chan struct{}, interval time.Duration) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			cache.flushStore()
+		}
+	}
+}
+
+// PreloadFromStore reads the keys from the store and stores them in the cache with their remaining TTL, so the cache
+// is warm after a restart rather than filling up on misses. Keys that are not in the store, and items rejected by
+// the before set callback, are skipped. The preloaded items are not written back to the store. It stops at the
+// first error of the store, or when the context is done, keeping the items preloaded so far.
+func (cache *Cache) PreloadFromStore(ctx context.Context, keys []string) error {
+	store, err := cache.readableStore()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		value, ttl, found, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if err := cache.preload(Record{Key: key, Value: value, TTL: ttl}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PreloadPrefixFromStore works like PreloadFromStore for all keys in the store with the prefix.
+func (cache *Cache) PreloadPrefixFromStore(ctx context.Context, prefix string) error {
+	store, err := cache.readableStore()
+	if err != nil {
+		return err
+	}
+	var preloadErr error
+	err = store.Scan(prefix, func(record Record) bool {
+		if preloadErr = ctx.Err(); preloadErr != nil {
+			return false
+		}
+		preloadErr = cache.preload(record)
+		return preloadErr == nil
+	})
+	if preloadErr != nil {
+		return preloadErr
+	}
+	return err
+}
+
+func (cache *Cache) readableStore() (ReadableStore, error) {
+	cache.storeMutex.Lock()
+	store, readable := cache.store.(ReadableStore)
+	cache.storeMutex.Unlock()
+	if !readable {
+		return nil, ErrStoreNotReadable
+	}
+	return store, nil
+}
+
+// preload stores a record read from the store like Load does, without writing it back. A remaining TTL fixes the
+// expiration, so lookups do not extend it.
+func (cache *Cache) preload(record Record) error {
+	var expireAt time.Time
+	if record.TTL > 0 {
+		expireAt = time.Now().Add(record.TTL)
+	}
+	_, err := cache.setLocal(record.Key, record.Value, record.TTL, expireAt, 0)
+	if err == ErrRejected {
+		return nil
+	}
+	return err
+}
+
+// putToStore writes the item through to the store, with the remaining life until expireAt.
+func (cache *Cache) putToStore(key string, data interface{}, expireAt time.Time) error {
+	ttl := ItemNotExpire
+	if !expireAt.IsZero() {
+		ttl = time.Until(expireAt)
+	}
+	return cache.writeToStore(storeWrite{record: Record{Key: key, Value: data, TTL: ttl}})
+}
+
+// deleteFromStore deletes the key from the store, errors are passed to the error callback.
+func (cache *Cache) deleteFromStore(key string) {
+	if err := cache.writeToStore(storeWrite{record: Record{Key: key}, delete: true}); err != nil {
+		cache.storeMutex.Lock()
+		callback := cache.storeErrorCallback
+		cache.storeMutex.Unlock()
+		cache.reportStoreErrors(callback, []error{err})
+	}
+}
+
+// writeToStore writes to the store right away, or buffers the write when batching is enabled.
+// Only errors of writes that are not buffered are returned.
+func (cache *Cache) writeToStore(write storeWrite) error {
+	cache.storeMutex.Lock()
+	if cache.store == nil {
+		cache.storeMutex.Unlock()
+		return nil
+	}
+	if cache.storeBatchSize <= 0 {
+		store := cache.store
+		cache.storeMutex.Unlock()
+		return write.apply(store)
+	}
+
+	if i, buffered := cache.storeIndex[write.record.Key]; buffered {
+		cache.storeBuffer[i] = write
+	} else {
+		if cache.storeIndex == nil {
+			cache.storeIndex = make(map[string]int)
+		}
+		cache.storeIndex[write.record.Key] = len(cache.storeBuffer)
+		cache.storeBuffer = append(cache.storeBuffer, write)
+	}
+	var errs []error
+	if len(cache.storeBuffer) >= cache.storeBatchSize {
+		errs = cache.flushStoreLocked()
+	}
+	callback := cache.storeErrorCallback
+	cache.storeMutex.Unlock()
+	cache.reportStoreErrors(callback, errs)
+	return nil
+}
+
+// flushStore writes the buffered writes to the store, errors are passed to the error callback.
+func (cache *Cache) flushStore() {
+	cache.storeMutex.Lock()
+	errs := cache.flushStoreLocked()
+	callback := cache.storeErrorCallback
+	cache.storeMutex.Unlock()
+	cache.reportStoreErrors(callback, errs)
+}
+
+// flushStoreLocked writes the buffered writes to the store and returns the errors. The store lock is held
+// while writing, so flushes do not overtake each other. Must be called with the store lock held.
+func (cache *Cache) flushStoreLocked() []error {
+	writes := cache.storeBuffer
+	cache.storeBuffer = nil
+	cache.storeIndex = nil
+	if len(writes) == 0 || cache.store == nil {
+		return nil
+	}
+
+	var errs []error
+	batch, ok := cache.store.(BatchStore)
+	if !ok {
+		for _, write := range writes {
+			if err := write.apply(cache.store); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errs
+	}
+
+	var records []Record
+	var deleted []string
+	for _, write := range writes {
+		if write.delete {
+			deleted = append(deleted, write.record.Key)
+		} else {
+			records = append(records, write.record)
+		}
+	}
+	if len(records) > 0 {
+		if err := batch.PutBatch(records); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(deleted) > 0 {
+		if err := batch.DeleteBatch(deleted); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (cache *Cache) reportStoreErrors(callback func(err error), errs []error) {
+	if callback == nil {
+		return
+	}
+	for _, err := range errs {
+		callback(err)
+	}
+}
+
+func (write storeWrite) apply(store Store) error {
+	if write.delete {
+		return store.Delete(write.record.Key)
+	}
+	return store.Put(write.record.Key, write.record.Value, write.record.TTL)
+}
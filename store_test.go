@@ -0,0 +1,176 @@
+package ttlcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore records the writes it receives, failing them with err when it is set.
+type fakeStore struct {
+	mutex   sync.Mutex
+	puts    []string
+	deletes []string
+	batches [][]string
+	err     error
+}
+
+func (store *fakeStore) Put(key string, value interface{}, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.puts = append(store.puts, key)
+	return store.err
+}
+
+func (store *fakeStore) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.deletes = append(store.deletes, key)
+	return store.err
+}
+
+func (store *fakeStore) PutBatch(records []Record) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	batch := []string{"put"}
+	for _, record := range records {
+		batch = append(batch, record.Key)
+	}
+	store.batches = append(store.batches, batch)
+	return store.err
+}
+
+func (store *fakeStore) DeleteBatch(keys []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.batches = append(store.batches, append([]string{"delete"}, keys...))
+	return store.err
+}
+
+func (store *fakeStore) recordedBatches() [][]string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return append([][]string(nil), store.batches...)
+}
+
+func TestCacheSetStoreWritesThrough(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	store := &fakeStore{}
+	cache.SetStore(store)
+	cache.Set("a", 1)
+	cache.Remove("a")
+
+	assert.Equal(t, []string{"a"}, store.puts)
+	assert.Equal(t, []string{"a"}, store.deletes)
+
+	store.err = errors.New("store down")
+	assert.Equal(t, store.err, cache.Set("b", 2), "Expected the error of the put to be returned")
+	value, exists := cache.Get("b")
+	assert.True(t, exists)
+	assert.Equal(t, 2, value, "Expected the item to be cached although the put failed")
+}
+
+func TestCacheSetStoreBatchingCoalescesWrites(t *testing.T) {
+	cache := NewCache()
+
+	store := &fakeStore{}
+	cache.SetStore(store)
+	cache.SetStoreBatching(3, time.Hour)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("a", 3)
+	assert.Empty(t, store.recordedBatches(), "Expected writes to be buffered")
+	cache.Set("c", 4)
+	assert.Equal(t, [][]string{{"put", "a", "b", "c"}}, store.recordedBatches(), "Expected a full buffer to be flushed")
+
+	cache.Set("d", 5)
+	cache.Remove("e")
+	cache.Close()
+	assert.Equal(t, [][]string{{"put", "a", "b", "c"}, {"put", "d"}, {"delete", "e"}}, store.recordedBatches(), "Expected Close to flush the remainder")
+	assert.Empty(t, store.puts, "Expected no single writes")
+}
+
+func TestCacheSetStoreBatchingFlushesAfterInterval(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	store := &fakeStore{err: errors.New("store down")}
+	errs := make(chan error, 1)
+	cache.SetStore(store)
+	cache.SetStoreErrorCallback(func(err error) {
+		errs <- err
+	})
+	cache.SetStoreBatching(100, 10*time.Millisecond)
+
+	assert.Nil(t, cache.Set("a", 1), "Expected the failing flush not to fail the Set")
+	select {
+	case err := <-errs:
+		assert.Equal(t, store.err, err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the error of the flush to be passed to the callback")
+	}
+	assert.Equal(t, [][]string{{"put", "a"}}, store.recordedBatches())
+}
+
+// readableStore is a fakeStore that serves the given records.
+type readableStore struct {
+	fakeStore
+	records map[string]Record
+}
+
+func (store *readableStore) Get(key string) (interface{}, time.Duration, bool, error) {
+	record, found := store.records[key]
+	return record.Value, record.TTL, found, store.err
+}
+
+func (store *readableStore) Scan(prefix string, f func(record Record) bool) error {
+	for key, record := range store.records {
+		if strings.HasPrefix(key, prefix) && !f(record) {
+			break
+		}
+	}
+	return store.err
+}
+
+func TestCachePreloadFromStore(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	assert.Equal(t, ErrStoreNotReadable, cache.PreloadFromStore(context.Background(), []string{"a"}))
+
+	store := &readableStore{records: map[string]Record{
+		"user/a": {Key: "user/a", Value: "a", TTL: time.Hour},
+		"user/b": {Key: "user/b", Value: "b", TTL: ItemNotExpire},
+		"page/c": {Key: "page/c", Value: "c", TTL: time.Minute},
+	}}
+	cache.SetStore(store)
+	assert.Nil(t, cache.PreloadFromStore(context.Background(), []string{"user/a", "user/b", "missing"}))
+
+	cache.mutex.RLock()
+	now := time.Now()
+	a, b := storedItem(cache, "user/a"), storedItem(cache, "user/b")
+	assert.InDelta(t, float64(time.Hour), float64(a.remainingTTL(now)), float64(time.Second), "Expected the remaining TTL of the store")
+	assert.True(t, a.fixedExpiry, "Expected lookups not to extend the remaining TTL")
+	assert.Equal(t, "b", b.data)
+	assert.True(t, b.expireAt.IsZero(), "Expected the item not to expire")
+	cache.mutex.RUnlock()
+	assert.Equal(t, 2, cache.Count(), "Expected keys missing in the store to be skipped")
+	assert.Empty(t, store.puts, "Expected preloaded items not to be written back")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, cache.PreloadPrefixFromStore(ctx, "page/"))
+	assert.Nil(t, cache.PreloadPrefixFromStore(context.Background(), "page/"))
+	value, found := cache.Get("page/c")
+	assert.Equal(t, true, found, "Expected the keys with the prefix to be preloaded")
+	assert.Equal(t, "c", value)
+	assert.Equal(t, 3, cache.Count())
+}
@@ -0,0 +1,119 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OpTimings summarizes how long calls of an operation waited for the lock of the cache, and how long they
+// held it, see EnableOpTimings. Divide the totals by Calls for the averages.
+type OpTimings struct {
+	// Calls is the number of timed calls.
+	Calls uint64
+	// LockWait is the total time the calls waited to acquire the lock.
+	LockWait time.Duration
+	// LockWaitMax is the longest time a call waited to acquire the lock.
+	LockWaitMax time.Duration
+	// LockHold is the total time the calls held the lock.
+	LockHold time.Duration
+}
+
+// add sums the timings with other timings, keeping the longer maximum wait.
+func (timings OpTimings) add(other OpTimings) OpTimings {
+	timings.Calls += other.Calls
+	timings.LockWait += other.LockWait
+	timings.LockHold += other.LockHold
+	if other.LockWaitMax > timings.LockWaitMax {
+		timings.LockWaitMax = other.LockWaitMax
+	}
+	return timings
+}
+
+// op identifies a timed operation.
+type op int
+
+const (
+	opGet op = iota
+	opSet
+	opRemove
+	opCount
+)
+
+// opTimer accumulates the timings of an operation, all fields are accessed atomically.
+type opTimer struct {
+	calls       int64
+	lockWait    int64
+	lockWaitMax int64
+	lockHold    int64
+}
+
+// lockTiming tracks a call holding the lock, locked is zero when timings are disabled.
+type lockTiming struct {
+	op     op
+	start  time.Time
+	locked time.Time
+}
+
+// EnableOpTimings records for Get, Set and Remove how long they wait for the lock of the cache and how long they
+// hold it, to tell lock contention from slow callbacks. The timings are reported by GetMetrics. Timing costs two
+// clock reads per call, so it is disabled by default. All Set functions are recorded as Set.
+func (cache *Cache) EnableOpTimings(value bool) {
+	var enabled uint32
+	if value {
+		enabled = 1
+	}
+	atomic.StoreUint32(&cache.opTimingsEnabled, enabled)
+}
+
+// lockOp acquires the lock for the operation, timing the wait when timings are enabled.
+func (cache *Cache) lockOp(op op) lockTiming {
+	if atomic.LoadUint32(&cache.opTimingsEnabled) == 0 {
+		cache.mutex.Lock()
+		return lockTiming{}
+	}
+	start := time.Now()
+	cache.mutex.Lock()
+	return lockTiming{op: op, start: start, locked: time.Now()}
+}
+
+// unlockOp releases the lock acquired by lockOp and records the timings.
+func (cache *Cache) unlockOp(timing lockTiming) {
+	if timing.locked.IsZero() {
+		cache.mutex.Unlock()
+		return
+	}
+	hold := time.Since(timing.locked)
+	cache.mutex.Unlock()
+	timer := &cache.opTimers[timing.op]
+	wait := int64(timing.locked.Sub(timing.start))
+	atomic.AddInt64(&timer.calls, 1)
+	atomic.AddInt64(&timer.lockWait, wait)
+	atomic.AddInt64(&timer.lockHold, int64(hold))
+	for {
+		current := atomic.LoadInt64(&timer.lockWaitMax)
+		if current >= wait || atomic.CompareAndSwapInt64(&timer.lockWaitMax, current, wait) {
+			break
+		}
+	}
+}
+
+// opTimings returns the timings of the operation.
+func (cache *Cache) opTimings(op op) OpTimings {
+	timer := &cache.opTimers[op]
+	return OpTimings{
+		Calls:       uint64(atomic.LoadInt64(&timer.calls)),
+		LockWait:    time.Duration(atomic.LoadInt64(&timer.lockWait)),
+		LockWaitMax: time.Duration(atomic.LoadInt64(&timer.lockWaitMax)),
+		LockHold:    time.Duration(atomic.LoadInt64(&timer.lockHold)),
+	}
+}
+
+// resetOpTimings clears the timings of all operations.
+func (cache *Cache) resetOpTimings() {
+	for i := range cache.opTimers {
+		timer := &cache.opTimers[i]
+		for _, counter := range []*int64{&timer.calls, &timer.lockWait, &timer.lockWaitMax, &timer.lockHold} {
+			atomic.StoreInt64(counter, 0)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package ttlcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheOpTimingsSeparateWaitFromHold(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("key", "value")
+	assert.Equal(t, uint64(0), cache.GetMetrics().SetTimings.Calls, "Expected timings to be disabled by default")
+
+	cache.EnableOpTimings(true)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		// the lock is held elsewhere, so the lookups queue up behind it
+		cache.mutex.Lock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get("key")
+		}()
+		<-time.After(10 * time.Millisecond)
+		cache.mutex.Unlock()
+		wg.Wait()
+	}
+	cache.Remove("key")
+
+	metrics := cache.GetMetrics()
+	assert.Equal(t, uint64(5), metrics.GetTimings.Calls)
+	assert.True(t, metrics.GetTimings.LockWait >= 50*time.Millisecond, "Expected the contention to show as wait time")
+	assert.True(t, metrics.GetTimings.LockWaitMax >= 10*time.Millisecond, "Expected the longest wait to be recorded")
+	assert.True(t, metrics.GetTimings.LockWait > 10*metrics.GetTimings.LockHold, "Expected the wait to dominate the hold time")
+	assert.Equal(t, uint64(1), metrics.RemoveTimings.Calls)
+	assert.Equal(t, uint64(0), metrics.SetTimings.Calls)
+}
@@ -0,0 +1,41 @@
+package ttlcache
+
+// GetString is a thread-safe way to lookup a string. It returns false when the item is missing or does not hold
+// a string. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetString(key string) (string, bool) {
+	data, _ := cache.Get(key)
+	value, ok := data.(string)
+	return value, ok
+}
+
+// GetInt is a thread-safe way to lookup an int. It returns false when the item is missing or does not hold
+// an int, other integer types are not converted. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetInt(key string) (int, bool) {
+	data, _ := cache.Get(key)
+	value, ok := data.(int)
+	return value, ok
+}
+
+// GetInt64 is a thread-safe way to lookup an int64. It returns false when the item is missing or does not hold
+// an int64, other integer types are not converted. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetInt64(key string) (int64, bool) {
+	data, _ := cache.Get(key)
+	value, ok := data.(int64)
+	return value, ok
+}
+
+// GetBool is a thread-safe way to lookup a bool. It returns false when the item is missing or does not hold
+// a bool. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetBool(key string) (bool, bool) {
+	data, _ := cache.Get(key)
+	value, ok := data.(bool)
+	return value, ok
+}
+
+// GetFloat64 is a thread-safe way to lookup a float64. It returns false when the item is missing or does not hold
+// a float64. Every lookup, also touches the item, hence extending it's life
+func (cache *Cache) GetFloat64(key string) (float64, bool) {
+	data, _ := cache.Get(key)
+	value, ok := data.(float64)
+	return value, ok
+}
@@ -0,0 +1,54 @@
+package ttlcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheTypedGetters(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("string", "value")
+	cache.Set("int", 42)
+	cache.Set("int64", int64(42))
+	cache.Set("bool", true)
+	cache.Set("float64", 4.2)
+
+	s, ok := cache.GetString("string")
+	assert.Equal(t, true, ok, "Expected the string to be found")
+	assert.Equal(t, "value", s)
+	i, ok := cache.GetInt("int")
+	assert.Equal(t, true, ok, "Expected the int to be found")
+	assert.Equal(t, 42, i)
+	i64, ok := cache.GetInt64("int64")
+	assert.Equal(t, true, ok, "Expected the int64 to be found")
+	assert.Equal(t, int64(42), i64)
+	b, ok := cache.GetBool("bool")
+	assert.Equal(t, true, ok, "Expected the bool to be found")
+	assert.Equal(t, true, b)
+	f, ok := cache.GetFloat64("float64")
+	assert.Equal(t, true, ok, "Expected the float64 to be found")
+	assert.Equal(t, 4.2, f)
+
+	for _, key := range []string{"int64", "missing"} {
+		s, ok = cache.GetString(key)
+		assert.Equal(t, false, ok, "Expected no string for %s", key)
+		assert.Equal(t, "", s)
+		i, ok = cache.GetInt(key)
+		assert.Equal(t, false, ok, "Expected no int for %s", key)
+		assert.Equal(t, 0, i)
+		b, ok = cache.GetBool(key)
+		assert.Equal(t, false, ok, "Expected no bool for %s", key)
+		assert.Equal(t, false, b)
+		f, ok = cache.GetFloat64(key)
+		assert.Equal(t, false, ok, "Expected no float64 for %s", key)
+		assert.Equal(t, 0.0, f)
+	}
+	for _, key := range []string{"int", "missing"} {
+		i64, ok = cache.GetInt64(key)
+		assert.Equal(t, false, ok, "Expected no int64 for %s", key)
+		assert.Equal(t, int64(0), i64)
+	}
+}
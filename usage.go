@@ -0,0 +1,84 @@
+package ttlcache
+
+import (
+	"container/list"
+	"sort"
+)
+
+// usageOrder keeps the items in least recently used order, separately for every eviction priority.
+type usageOrder struct {
+	lists map[int]*list.List
+	len   int
+}
+
+func newUsageOrder() *usageOrder {
+	return &usageOrder{lists: make(map[int]*list.List)}
+}
+
+// pushFront adds the item as the most recently used of its priority.
+func (order *usageOrder) pushFront(item *item) {
+	items, exists := order.lists[item.priority]
+	if !exists {
+		items = list.New()
+		order.lists[item.priority] = items
+	}
+	item.usageElement = items.PushFront(item)
+	order.len++
+}
+
+// moveToFront marks the item as the most recently used of its priority, pinned items are not in the order.
+func (order *usageOrder) moveToFront(item *item) {
+	if item.pinned {
+		return
+	}
+	order.lists[item.priority].MoveToFront(item.usageElement)
+}
+
+// remove drops the item from the order.
+func (order *usageOrder) remove(item *item) {
+	items := order.lists[item.priority]
+	items.Remove(item.usageElement)
+	item.usageElement = nil
+	order.len--
+	if items.Len() == 0 {
+		delete(order.lists, item.priority)
+	}
+}
+
+func (order *usageOrder) Len() int {
+	return order.len
+}
+
+// priorities returns the priorities that have items, lowest first.
+func (order *usageOrder) priorities() []int {
+	priorities := make([]int, 0, len(order.lists))
+	for priority := range order.lists {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+	return priorities
+}
+
+// leastRecentlyUsed returns the least recently used item of the lowest priority, or nil without items.
+func (order *usageOrder) leastRecentlyUsed() *item {
+	var lowest *list.List
+	lowestPriority := 0
+	for priority, items := range order.lists {
+		if lowest == nil || priority < lowestPriority {
+			lowest, lowestPriority = items, priority
+		}
+	}
+	if lowest == nil {
+		return nil
+	}
+	return lowest.Back().Value.(*item)
+}
+
+// each calls f for all items, the lowest priority first and the least recently used first within a priority.
+func (order *usageOrder) each(f func(item *item)) {
+	for _, priority := range order.priorities() {
+		for element := order.lists[priority].Back(); element != nil; element = element.Prev() {
+			f(element.Value.(*item))
+		}
+	}
+}
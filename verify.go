@@ -0,0 +1,132 @@
+package ttlcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// Verify checks the internal consistency of the cache: every item in the map is in the priority queue at
+// its recorded position, the queue holds no duplicates or items missing from the map, and the queue satisfies
+// the heap property. The usage order must hold the same items except the pinned ones and, when tracked, the
+// insertion order must hold the same items.
+// It is meant for tests and debugging, and locks the cache while checking.
+func (cache *Cache) Verify() error {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.checkInvariants()
+}
+
+func (cache *Cache) checkInvariants() error {
+	queue := cache.priorityQueue
+	if cache.items.Len() != queue.Len() {
+		return fmt.Errorf("ttlcache: map holds %d items, queue holds %d", cache.items.Len(), queue.Len())
+	}
+
+	seen := make(map[string]bool, queue.Len())
+	for i, item := range queue.items {
+		if item.queueIndex != i {
+			return fmt.Errorf("ttlcache: item %q at queue position %d records position %d", item.key, i, item.queueIndex)
+		}
+		if seen[item.key] {
+			return fmt.Errorf("ttlcache: item %q is queued more than once", item.key)
+		}
+		seen[item.key] = true
+		if stored, _ := cache.items.get(item.key); stored != item {
+			return fmt.Errorf("ttlcache: queued item %q is not in the map", item.key)
+		}
+		if i > 0 && queue.Less(i, (i-1)/2) {
+			return fmt.Errorf("ttlcache: item %q at queue position %d expires before its parent", item.key, i)
+		}
+	}
+
+	var err error
+	pinned := 0
+	cache.items.Range(func(key string, value interface{}) bool {
+		item := value.(*item)
+		if item.pinned {
+			pinned++
+		}
+		switch {
+		case item.key != key:
+			err = fmt.Errorf("ttlcache: item %q is stored under key %q", item.key, key)
+		case item.pinned && item.usageElement != nil:
+			err = fmt.Errorf("ttlcache: pinned item %q is in the usage order", key)
+		case !item.pinned && item.usageElement == nil:
+			err = fmt.Errorf("ttlcache: item %q is missing from the usage order", key)
+		case cache.insertionOrder != nil && item.insertionElement == nil:
+			err = fmt.Errorf("ttlcache: item %q is missing from the insertion order", key)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if cache.usageOrder.Len()+pinned != cache.items.Len() {
+		return fmt.Errorf("ttlcache: map holds %d items, usage order holds %d and %d are pinned", cache.items.Len(), cache.usageOrder.Len(), pinned)
+	}
+	if cache.insertionOrder != nil && cache.insertionOrder.Len() != cache.items.Len() {
+		return fmt.Errorf("ttlcache: map holds %d items, insertion order holds %d", cache.items.Len(), cache.insertionOrder.Len())
+	}
+	return nil
+}
+
+// SetIntegrityCheckInterval makes a background goroutine compare the number of items in the map, the priority
+// queue, the usage order and the insertion order every interval, and pass a description of any divergence to
+// the violation callback, see SetIntegrityViolationCallback. Unlike Verify it only compares the counts, so it is
+// cheap enough to run in production as a canary. The goroutine stops on Close. An interval of 0 stops checking.
+func (cache *Cache) SetIntegrityCheckInterval(interval time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.integrityCheckStop != nil {
+		close(cache.integrityCheckStop)
+		cache.integrityCheckStop = nil
+	}
+	if interval > 0 && !cache.isShutDown {
+		cache.integrityCheckStop = make(chan struct{})
+		cache.backgroundWorkers.Add(1)
+		go cache.checkIntegrityPeriodically(cache.integrityCheckStop, interval)
+	}
+}
+
+// SetIntegrityViolationCallback sets the callback that receives the divergences found by the integrity check,
+// see SetIntegrityCheckInterval.
+func (cache *Cache) SetIntegrityViolationCallback(callback func(details string)) {
+	cache.mutex.Lock()
+	cache.integrityCallback = callback
+	cache.mutex.Unlock()
+}
+
+func (cache *Cache) checkIntegrityPeriodically(stop chan struct{}, interval time.Duration) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			cache.mutex.RLock()
+			details := cache.checkCounts()
+			callback := cache.integrityCallback
+			cache.mutex.RUnlock()
+			if details != "" && callback != nil {
+				callback(details)
+			}
+		}
+	}
+}
+
+// checkCounts compares the number of items in the structures of the cache, and describes the first divergence
+// it finds. Pinned items are left out of the usage order, so it can hold fewer items than the map.
+func (cache *Cache) checkCounts() string {
+	size := cache.items.Len()
+	switch {
+	case cache.priorityQueue.Len() != size:
+		return fmt.Sprintf("map holds %d items, queue holds %d", size, cache.priorityQueue.Len())
+	case cache.usageOrder.Len() > size:
+		return fmt.Sprintf("map holds %d items, usage order holds %d", size, cache.usageOrder.Len())
+	case cache.insertionOrder != nil && cache.insertionOrder.Len() != size:
+		return fmt.Sprintf("map holds %d items, insertion order holds %d", size, cache.insertionOrder.Len())
+	}
+	return ""
+}
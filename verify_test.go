@@ -0,0 +1,91 @@
+package ttlcache
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheVerifyDuringRapidChanges(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.SetTTL(5 * time.Millisecond)
+	cache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		return value.(int)%3 != 0
+	})
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key_%d", random.Intn(50))
+				switch random.Intn(4) {
+				case 0:
+					cache.Set(key, i)
+				case 1:
+					cache.SetWithTTL(key, i, time.Duration(random.Intn(10))*time.Millisecond)
+				case 2:
+					cache.Remove(key)
+				case 3:
+					cache.Get(key)
+				}
+				assert.Nil(t, cache.Verify(), "Expected invariants to hold")
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	<-time.After(20 * time.Millisecond)
+	assert.Nil(t, cache.Verify(), "Expected invariants to hold after expiration")
+}
+
+func TestCacheVerifyDetectsDivergence(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	cache.Set("key", "value")
+	assert.Nil(t, cache.Verify(), "Expected invariants to hold")
+
+	cache.mutex.Lock()
+	cache.items.Set("orphan", newItem("orphan", "value", ItemNotExpire))
+	cache.mutex.Unlock()
+	assert.Error(t, cache.Verify(), "Expected an item missing from the queue to be detected")
+}
+
+func TestCacheSetIntegrityCheckInterval(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	violations := make(chan string, 10)
+	cache.SetIntegrityViolationCallback(func(details string) {
+		select {
+		case violations <- details:
+		default:
+		}
+	})
+	cache.SetIntegrityCheckInterval(5 * time.Millisecond)
+	cache.Set("first", 1)
+	cache.Set("second", 2)
+	<-time.After(20 * time.Millisecond)
+	assert.Equal(t, 0, len(violations), "Expected no violation for a consistent cache")
+
+	// induce a divergence by removing an item from the map only
+	cache.mutex.Lock()
+	cache.items.Delete("first")
+	cache.mutex.Unlock()
+	select {
+	case details := <-violations:
+		assert.Equal(t, "map holds 1 items, queue holds 2", details)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the violation callback to be called")
+	}
+	cache.SetIntegrityCheckInterval(0)
+}
@@ -0,0 +1,64 @@
+package ttlcache
+
+import (
+	"time"
+)
+
+// keyWaiter receives the value of the key it waits for, see WaitForKey.
+type keyWaiter chan interface{}
+
+// WaitForKey is a thread-safe way to wait until the key is stored, and returns its value. It returns right away
+// when the item is in the cache already, and false when the timeout elapses before the key is stored.
+// All callers waiting for the same key are woken up. Like Get, it touches an item that is found right away.
+func (cache *Cache) WaitForKey(key string, timeout time.Duration) (interface{}, bool) {
+	cache.mutex.Lock()
+	item, exists, triggerExpirationNotification := cache.getItem(key)
+	if exists {
+		dataToReturn := item.data
+		cache.mutex.Unlock()
+		if triggerExpirationNotification {
+			cache.notifyExpiration()
+		}
+		return dataToReturn, true
+	}
+	waiter := make(keyWaiter, 1)
+	if cache.keyWaiters[key] == nil {
+		cache.keyWaiters[key] = make(map[keyWaiter]struct{})
+	}
+	cache.keyWaiters[key][waiter] = struct{}{}
+	cache.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data := <-waiter:
+		return data, true
+	case <-timer.C:
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	select {
+	case data := <-waiter:
+		// the key was stored while the timeout elapsed
+		return data, true
+	default:
+	}
+	delete(cache.keyWaiters[key], waiter)
+	if len(cache.keyWaiters[key]) == 0 {
+		delete(cache.keyWaiters, key)
+	}
+	return nil, false
+}
+
+// wakeWaiters passes the stored value to all callers waiting for the key. Must be called with the lock held.
+func (cache *Cache) wakeWaiters(key string, data interface{}) {
+	waiters, exists := cache.keyWaiters[key]
+	if !exists {
+		return
+	}
+	for waiter := range waiters {
+		waiter <- data
+	}
+	delete(cache.keyWaiters, key)
+}
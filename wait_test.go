@@ -0,0 +1,45 @@
+package ttlcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheWaitForKey(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, found := cache.WaitForKey("key", time.Second)
+			assert.Equal(t, true, found, "Expected the waiter to be woken up")
+			assert.Equal(t, "value", data, "Expected the stored value")
+		}()
+	}
+	<-time.After(20 * time.Millisecond)
+	cache.Set("key", "value")
+	wg.Wait()
+
+	data, found := cache.WaitForKey("key", time.Millisecond)
+	assert.Equal(t, true, found, "Expected an existing key to return right away")
+	assert.Equal(t, "value", data, "Expected the stored value")
+}
+
+func TestCacheWaitForKeyTimeout(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	data, found := cache.WaitForKey("key", 10*time.Millisecond)
+	assert.Equal(t, false, found, "Expected the waiter to time out")
+	assert.Nil(t, data)
+
+	cache.mutex.RLock()
+	assert.Equal(t, 0, len(cache.keyWaiters), "Expected the waiter to be unregistered")
+	cache.mutex.RUnlock()
+}
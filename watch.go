@@ -0,0 +1,75 @@
+package ttlcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watched is a read-only view of an item that is refreshed in the background, see Watch.
+type Watched struct {
+	value atomic.Value
+}
+
+// watchedValue boxes the value, as an atomic.Value holds neither nil nor values of different types.
+type watchedValue struct {
+	value interface{}
+}
+
+// Value returns the latest value of the watched item, without locking. It returns nil until the loader
+// succeeded once.
+func (watched *Watched) Value() interface{} {
+	boxed, _ := watched.value.Load().(watchedValue)
+	return boxed.value
+}
+
+func (watched *Watched) store(value interface{}) {
+	watched.value.Store(watchedValue{value: value})
+}
+
+// Watch returns a view of the item of the key for hot paths: its Value is read without locking, and a background
+// goroutine reloads it with the loader every refresh interval, storing the new value in the cache as well. The
+// first value is looked up like GetOrSet does before Watch returns. When the loader fails the previous value is
+// kept until the next refresh. The returned function stops the refreshes, Close stops them as well.
+func (cache *Cache) Watch(key string, loader func(string) (interface{}, error), refresh time.Duration) (*Watched, func()) {
+	watched := &Watched{}
+	if value, err := cache.GetOrSet(key, loader); err == nil {
+		watched.store(value)
+	}
+
+	stop := make(chan struct{})
+	var once sync.Once
+	stopWatching := func() { once.Do(func() { close(stop) }) }
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if refresh > 0 && !cache.isShutDown {
+		cache.backgroundWorkers.Add(1)
+		go cache.refreshWatched(watched, key, loader, refresh, stop)
+	}
+	return watched, stopWatching
+}
+
+func (cache *Cache) refreshWatched(watched *Watched, key string, loader func(string) (interface{}, error), refresh time.Duration, stop chan struct{}) {
+	defer cache.backgroundWorkers.Done()
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-cache.shutdownSignal:
+			return
+		case <-ticker.C:
+			cache.mutex.RLock()
+			semaphore := cache.loaderSemaphore
+			cache.mutex.RUnlock()
+			value, err := cache.invokeLoader(semaphore, key, loader)
+			if err != nil {
+				continue
+			}
+			watched.store(value)
+			cache.Set(key, value)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package ttlcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheWatch(t *testing.T) {
+	cache := NewCache()
+	defer cache.Close()
+
+	var loads int32
+	loader := func(key string) (interface{}, error) {
+		return int(atomic.AddInt32(&loads, 1)), nil
+	}
+	watched, stop := cache.Watch("config", loader, 10*time.Millisecond)
+	assert.Equal(t, 1, watched.Value(), "Expected the first value to be loaded right away")
+
+	start := time.Now()
+	for watched.Value().(int) == 1 && time.Since(start) < time.Second {
+		<-time.After(5 * time.Millisecond)
+	}
+	refreshed := watched.Value().(int)
+	assert.True(t, refreshed > 1, "Expected the value to be refreshed in the background")
+	value, _ := cache.Get("config")
+	assert.True(t, value.(int) >= refreshed, "Expected the refreshed value to be stored in the cache")
+
+	stop()
+	stop()
+	<-time.After(15 * time.Millisecond)
+	stopped := atomic.LoadInt32(&loads)
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&loads), "Expected no reloads once stopped")
+
+	// a watcher that is not stopped ends on Close, see goleak in TestMain
+	cache.Watch("other", loader, time.Millisecond)
+}